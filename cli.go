@@ -0,0 +1,671 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/config"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/registry"
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the commands printing results
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// globalOptions are the flags shared by all commands
+type globalOptions struct {
+	configPath  string
+	output      string
+	logLevel    string
+	concurrency int
+	record      string
+	replay      string
+
+	flags    *pflag.FlagSet            // Tells which of the above were set
+	recorder *docker.RecordingExecutor // Set by executor with --record
+}
+
+// newRootCommand builds the command tree for opts. Help and the completion command are generated by cobra.
+func newRootCommand(opts *globalOptions) *cobra.Command {
+	root := &cobra.Command{
+		Use:   "docker-sbom",
+		Short: "Generate SBOMs of Docker containers and images and scan them for CVEs",
+		Long: `docker-sbom generates SBOMs of Docker containers and images with syft, scans them for CVEs with
+grype, prints the reports and notifies the configured channels. It runs single scans or, with serve,
+scheduled and event-triggered ones behind an HTTP API.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != outputText && opts.output != outputJSON {
+				return fmt.Errorf("unsupported output format %q, expected text or json", opts.output)
+			}
+			return nil
+		},
+	}
+
+	flags := root.PersistentFlags()
+	opts.flags = flags
+	flags.StringVarP(&opts.configPath, "config", "c", "config.yaml", "configuration file")
+	flags.StringVarP(&opts.output, "output", "o", outputText, "output format: text or json")
+	flags.StringVar(&opts.logLevel, "log-level", "", "log level: debug, info, warn or error, overrides logging.level")
+	flags.IntVar(&opts.concurrency, "concurrency", 0, "number of syft and of grype processes running at the same time, overrides scan.concurrency")
+	flags.StringVar(&opts.record, "record", "", "record the syft, grype and docker commands run and their output to a fixture file")
+	flags.StringVar(&opts.replay, "replay", "", "serve the commands from a fixture file recorded with --record instead of running them")
+	root.MarkFlagsMutuallyExclusive("record", "replay")
+	root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputText, outputJSON}, cobra.ShellCompDirectiveNoFileComp))
+	root.RegisterFlagCompletionFunc("log-level", cobra.FixedCompletions([]string{"debug", "info", "warn", "error"}, cobra.ShellCompDirectiveNoFileComp))
+
+	root.AddCommand(
+		newScanCommand(opts),
+		newSBOMCommand(opts),
+		newReportCommand(opts),
+		newDiffCommand(opts),
+		newServeCommand(opts),
+		newRouteCommand(opts),
+		newNotifyCommand(opts),
+		newConfigCommand(opts),
+		newDBCommand(opts),
+	)
+	return root
+}
+
+// path returns the configuration file to read, empty when config.yaml is missing and --config does not name it
+func (o *globalOptions) path() string {
+	if !o.flags.Changed("config") {
+		if _, err := os.Stat(o.configPath); errors.Is(err, fs.ErrNotExist) {
+			return ""
+		}
+	}
+	return o.configPath
+}
+
+// load layers the defaults, the configuration file, the CVE_* environment variables and the flags
+func (o *globalOptions) load() (*config.Config, error) {
+	cfg, err := config.Load(o.path(), os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	if o.flags.Changed("log-level") {
+		cfg.Logging.Level = o.logLevel
+	}
+	if o.flags.Changed("concurrency") {
+		cfg.Scan.Concurrency = o.concurrency
+	}
+	return cfg, nil
+}
+
+// loadValid loads the configuration and fails unless it is valid
+func (o *globalOptions) loadValid() (*config.Config, error) {
+	cfg, err := o.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration, run config validate for details: %s", strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	return cfg, nil
+}
+
+// loadConfig loads and validates the configuration and sets up logging
+func (o *globalOptions) loadConfig() (*config.Config, *slog.Logger, error) {
+	cfg, err := o.loadValid()
+	if err != nil {
+		return nil, nil, err
+	}
+	logger, err := setupLogging(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, logger, nil
+}
+
+// executor returns what runs syft, grype and docker: local processes within the configured limits, recorded
+// with --record, or the recorded session given with --replay
+func (o *globalOptions) executor(cfg *config.Config) (docker.CommandExecutor, error) {
+	if o.replay != "" {
+		return docker.LoadReplayExecutor(o.replay)
+	}
+	var executor docker.CommandExecutor = &docker.RealCommandExecutor{
+		Limits:    cfg.Scan.ProcessLimits(),
+		CgroupDir: cfg.Scan.CgroupDir,
+		Env:       cfg.Registry.Env(),
+	}
+	if o.record != "" {
+		o.recorder = docker.NewRecordingExecutor(executor, o.record)
+		executor = o.recorder
+	}
+	return executor, nil
+}
+
+// saveRecording writes the commands recorded with --record, whether the command succeeded or not
+func (o *globalOptions) saveRecording() error {
+	if o.recorder == nil {
+		return nil
+	}
+	return o.recorder.Save()
+}
+
+// newApp loads the configuration and creates the services scans report to
+func (o *globalOptions) newApp(options scanOptions) (*app, *config.Config, error) {
+	cfg, logger, err := o.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Results printed as JSON replace the text reports
+	var out io.Writer = os.Stdout
+	if o.output == outputJSON {
+		out = io.Discard
+	}
+	executor, err := o.executor(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := newApp(cfg, logger, executor, options, out)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, cfg, nil
+}
+
+// openStore loads the configuration and opens the results store, for commands only reading past runs
+func (o *globalOptions) openStore() (*store.Store, *config.Config, error) {
+	cfg, _, err := o.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := store.NewStore(cfg.Store.Dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open results store: %w", err)
+	}
+	return results, cfg, nil
+}
+
+func newScanCommand(opts *globalOptions) *cobra.Command {
+	var options scanOptions
+	cmd := &cobra.Command{
+		Use:   "scan [target...]",
+		Short: "Scan targets for CVEs, print the reports and send notifications",
+		Long: `Scan targets for CVEs, print the reports and send notifications. Each target is recorded as a
+separate run in the results store. Targets are running (the default), all (including
+stopped containers), images, dangling, filter:<reference pattern>, container:<id|name>, image:<ref> or
+registry:<ref>, and can be combined with commas, e.g. all,dangling, to scan images only once when
+containers use them. Registry images are read without pulling them; the tag can be a glob like 1.4.* or a
+version constraint like ">=1.5.0-rc1 <2" selecting every matching tag. Images that never reached a daemon
+are scanned from docker-archive:<docker save tarball>, oci-archive:<tarball> or oci-dir:<OCI layout
+directory>, where the path can be a glob.`,
+		Example: `  docker-sbom scan
+  docker-sbom scan image:nginx:1.25 container:web --plan
+  docker-sbom scan "registry:registry.local/app:>=1.5.0-rc1"
+  docker-sbom scan docker-archive:build/app.tar oci-dir:build/oci
+  docker-sbom scan -o json > results.json`,
+		RunE: func(cmd *cobra.Command, targets []string) error {
+			a, cfg, err := opts.newApp(options)
+			if err != nil {
+				return err
+			}
+			shutdownTracing, err := startTracing(cmd.Context(), cfg, a.logger)
+			if err != nil {
+				return err
+			}
+			defer shutdownTracing()
+
+			ctx := cmd.Context()
+
+			// Deliver what earlier runs could not before adding new notifications
+			a.outbox.Redeliver(ctx)
+
+			if len(targets) == 0 {
+				targets = []string{docker.TargetRunning}
+			}
+			var runIDs []string
+			var errs []error
+			for _, target := range targets {
+				runID, err := a.recordScan(ctx, target, "cli", a.scan)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error scanning %s: %w", target, err))
+				}
+				if runID != "" {
+					runIDs = append(runIDs, runID)
+				}
+			}
+			a.flushNotifications()
+
+			if opts.output == outputJSON {
+				runs := make([]store.Run, 0, len(runIDs))
+				for _, id := range runIDs {
+					if run, ok := a.results.Get(id); ok {
+						// The SBOMs are printed by the sbom command
+						run.SBOMs = nil
+						runs = append(runs, run)
+					}
+				}
+				if err := writeJSON(cmd.OutOrStdout(), runs); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&options.simulateUpgrades, "simulate-upgrades", false, "simulate an in-place OS package upgrade in each container and list the CVEs it would close")
+	flags.BoolVar(&options.showPlan, "plan", false, "print a remediation plan grouping CVEs by fix action")
+	flags.StringVar(&options.planFormat, "plan-format", remediation.FormatTable, "remediation plan format: table, json or text")
+	cmd.RegisterFlagCompletionFunc("plan-format", cobra.FixedCompletions([]string{remediation.FormatTable, remediation.FormatJSON, remediation.FormatText}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.ValidArgsFunction = completeTargets
+	return cmd
+}
+
+// completeTargets completes the target prefixes; container names and image references are left to the user
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{docker.TargetRunning, docker.TargetAll, docker.TargetImages, docker.TargetDangling, docker.TargetFilter, docker.TargetContainer, docker.TargetImage, docker.TargetRegistry, docker.TargetDockerArchive, docker.TargetOCIArchive, docker.TargetOCIDir}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+func newSBOMCommand(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom [target...]",
+		Short: "Generate and print SBOMs without scanning them for CVEs",
+		Long: `Generate and print the syft SBOMs of the targets without scanning them for CVEs, recording a run or
+sending notifications. Targets are running (the default), all (including stopped containers), images,
+dangling, filter:<reference pattern>, container:<id|name>, image:<ref>, registry:<ref with tag pattern>,
+docker-archive:<path>, oci-archive:<path> or oci-dir:<path>.
+Images are only generated once when containers or other targets already cover their digest.`,
+		RunE: func(cmd *cobra.Command, selectors []string) error {
+			cfg, logger, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+			shutdownTracing, err := startTracing(cmd.Context(), cfg, logger)
+			if err != nil {
+				return err
+			}
+			defer shutdownTracing()
+
+			executor, err := opts.executor(cfg)
+			if err != nil {
+				return err
+			}
+			sbomService := docker.NewDockerSBOMService(executor)
+			sbomService.SetLogger(logger)
+			sbomService.SetLimits(scanLimits(cfg, logger))
+			sbomService.SetRegistry(registry.NewClient(cfg.Registry))
+
+			ctx := cmd.Context()
+			if cfg.Scan.TimeoutMinutes > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Scan.TimeoutMinutes)*time.Minute)
+				defer cancel()
+			}
+
+			if len(selectors) == 0 {
+				selectors = []string{docker.TargetRunning}
+			}
+			// Resolved together so targets listed by several selectors are generated once
+			targets, err := sbomService.ResolveTargets(ctx, strings.Join(selectors, ","))
+			if err != nil {
+				return err
+			}
+
+			sbomResults := sbomService.GenerateSBOMs(ctx, targets)
+			if opts.output == outputJSON {
+				sboms := make(map[string]json.RawMessage, len(sbomResults))
+				for key, sbom := range sbomResults {
+					sboms[key] = json.RawMessage(sbom)
+				}
+				if err := writeJSON(cmd.OutOrStdout(), sboms); err != nil {
+					return err
+				}
+			} else {
+				for _, key := range sortedKeys(sbomResults) {
+					fmt.Fprintf(cmd.OutOrStdout(), "SBOM for container %s:\n%s\n", key, sbomResults[key])
+				}
+			}
+			if len(sbomResults) < len(targets) {
+				return fmt.Errorf("failed to generate %d of %d SBOMs", len(targets)-len(sbomResults), len(targets))
+			}
+			return nil
+		},
+	}
+	cmd.ValidArgsFunction = completeTargets
+	return cmd
+}
+
+func newReportCommand(opts *globalOptions) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "report <run-id>",
+		Short: "Print the CVE report of a recorded scan run",
+		Long: `Print the CVE report of a scan run from the results store as a table, a remediation plan or HTML.
+With -o json the run's CVEs are printed as JSON instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results, cfg, err := opts.openStore()
+			if err != nil {
+				return err
+			}
+			run, ok := results.Get(args[0])
+			if !ok {
+				return fmt.Errorf("scan run %s not found", args[0])
+			}
+
+			out := cmd.OutOrStdout()
+			if opts.output == outputJSON {
+				return writeJSON(out, run.CVEs)
+			}
+			switch format {
+			case "html":
+				templates, err := loadTemplates(cfg)
+				if err != nil {
+					return err
+				}
+				return templates.HTMLReport(out, run)
+			case "table", "plan":
+				for _, key := range sortedKeys(run.CVEs) {
+					fmt.Fprintf(out, "CVE Report for %s:\n", key)
+					if format == "plan" {
+						if err := remediation.BuildPlan(run.CVEs[key], cfg.Releases()).Write(out, remediation.FormatTable); err != nil {
+							return err
+						}
+					} else {
+						tableprinter.WriteCVEResults(out, run.CVEs[key])
+					}
+					fmt.Fprintln(out)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unsupported report format %q, expected table, plan or html", format)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "table", "report format: table, plan or html")
+	cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{"table", "plan", "html"}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.ValidArgsFunction = opts.completeRunIDs(1)
+	return cmd
+}
+
+func newDiffCommand(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <run-id> <later-run-id>",
+		Short: "Show the CVEs that appeared and were fixed between two scan runs",
+		Long: `Show the CVEs that appeared and were fixed between two scan runs. Findings are matched by target,
+CVE and package, so compare runs of the same target.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results, _, err := opts.openStore()
+			if err != nil {
+				return err
+			}
+			var runs [2]store.Run
+			for i, id := range args {
+				run, ok := results.Get(id)
+				if !ok {
+					return fmt.Errorf("scan run %s not found", id)
+				}
+				runs[i] = run
+			}
+
+			diff := store.Diff(runs[0], runs[1])
+			if opts.output == outputJSON {
+				return writeJSON(cmd.OutOrStdout(), diff)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "%d new and %d fixed CVEs between run %s and run %s\n", len(diff.New), len(diff.Fixed), diff.From, diff.To)
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			for _, finding := range diff.New {
+				fmt.Fprintf(w, "+\t%s\t%s\t%s\t%s %s\n", finding.Target, finding.CVEName, finding.Severity, finding.Package, finding.CurrentVersion)
+			}
+			for _, finding := range diff.Fixed {
+				fmt.Fprintf(w, "-\t%s\t%s\t%s\t%s %s\n", finding.Target, finding.CVEName, finding.Severity, finding.Package, finding.CurrentVersion)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.ValidArgsFunction = opts.completeRunIDs(2)
+	return cmd
+}
+
+// completeRunIDs completes the IDs of recorded scan runs, newest first, for commands taking up to n of them
+func (o *globalOptions) completeRunIDs(n int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= n {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		cfg, err := o.load()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		results, err := store.NewStore(cfg.Store.Dir)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var ids []string
+		for _, run := range results.List() {
+			ids = append(ids, run.ID+"\t"+run.Target)
+		}
+		return ids, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+	}
+}
+
+func newServeCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Run scheduled and event-triggered scans, the HTTP API and the metrics endpoint",
+		Long: `Run scheduled and event-triggered scans, the HTTP API and the metrics endpoint.
+
+The configuration is reloaded on SIGHUP and when the file changes. Routing rules, alerting and
+schedules are swapped in without interrupting running scans; other settings need a restart. An
+invalid configuration is not applied, the previous one stays in effect and a notification is sent.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, cfg, err := opts.newApp(scanOptions{planFormat: remediation.FormatTable})
+			if err != nil {
+				return err
+			}
+			shutdownTracing, err := startTracing(cmd.Context(), cfg, a.logger)
+			if err != nil {
+				return err
+			}
+			defer shutdownTracing()
+
+			if err := a.serve(cmd.Context(), cfg, configSource{path: opts.path(), load: opts.loadValid}); err != nil {
+				return fmt.Errorf("error running scheduled scans: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+func newRouteCommand(opts *globalOptions) *cobra.Command {
+	options := routeOptions{labels: map[string]string{}}
+	cmd := &cobra.Command{
+		Use:   "route",
+		Short: "Print which channels a finding would be sent to, without sending anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Only the routing is evaluated, so the outbox and the results store are left alone
+			cfg, logger, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+			executor, err := opts.executor(cfg)
+			if err != nil {
+				return err
+			}
+			a, err := newRoutingApp(cfg, logger, executor)
+			if err != nil {
+				return err
+			}
+			return a.routeDryRun(cmd.Context(), options, cmd.OutOrStdout())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&options.image, "image", "", "image reference of the finding")
+	flags.StringVar(&options.container, "container", "", "container of the finding; its image and labels are looked up unless given")
+	flags.StringVar(&options.severity, "severity", "", "worst severity of the finding, empty for status messages")
+	flags.StringToStringVar(&options.labels, "label", nil, "container label as key=value, may be repeated")
+	cmd.RegisterFlagCompletionFunc("severity", cobra.FixedCompletions([]string{"critical", "high", "medium", "low", "negligible"}, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
+
+func newNotifyCommand(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "notify",
+		Short: "Work with notification channels",
+	}
+
+	var channels []string
+	test := &cobra.Command{
+		Use:   "test",
+		Short: "Send a test notification to every channel or the given ones",
+		Long: `Send a test notification to every configured channel or the given ones, ignoring routing rules,
+severity filters and quiet hours, and report which channels could not be reached.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, _, err := opts.newApp(scanOptions{})
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), time.Minute)
+			defer cancel()
+			return a.notificationService.SendTest(ctx, channels)
+		},
+	}
+	test.Flags().StringSliceVar(&channels, "channel", nil, "channel to send to, may be repeated (default all channels)")
+	test.RegisterFlagCompletionFunc("channel", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		cfg, err := opts.load()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var names []string
+		for _, channel := range cfg.NotificationChannels() {
+			names = append(names, channel.Name)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	cmd.AddCommand(test)
+	return cmd
+}
+
+func newConfigCommand(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with the configuration file",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration, including channels, templates, routing rules, alerting and schedules",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.load()
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				for _, problem := range strings.Split(err.Error(), "\n") {
+					fmt.Fprintf(cmd.OutOrStdout(), "- %s\n", problem)
+				}
+				return errors.New("the configuration is invalid")
+			}
+
+			// Channels, templates, routing rules and alerting are checked in depth without touching the
+			// outbox and results store, which a running scanner may be using
+			if _, err := setupLogging(cfg); err != nil {
+				return err
+			}
+			if err := checkServices(cfg); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "The configuration is valid")
+			return nil
+		},
+	})
+	return cmd
+}
+
+func newDBCommand(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Work with grype's vulnerability database",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Print when the vulnerability database was built",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Only the process limits are used, so the rest of the configuration need not be valid
+			cfg, err := opts.load()
+			if err != nil {
+				return err
+			}
+			executor, err := opts.executor(cfg)
+			if err != nil {
+				return err
+			}
+			sbomService := docker.NewDockerSBOMService(executor)
+			built, err := sbomService.VulnerabilityDBBuilt(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			age := time.Since(built).Round(time.Minute)
+			if opts.output == outputJSON {
+				return writeJSON(cmd.OutOrStdout(), map[string]any{"built": built, "age_seconds": int(age.Seconds())})
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Vulnerability database built %s (%s ago)\n", built.Local().Format("2006-01-02 15:04"), age)
+			return nil
+		},
+	})
+	return cmd
+}
+
+// loadTemplates returns the configured report and message templates
+func loadTemplates(cfg *config.Config) (*notify.Templates, error) {
+	if cfg.Notifications.TemplatesDir == "" {
+		return notify.DefaultTemplates(), nil
+	}
+	templates, err := notify.LoadTemplates(cfg.Notifications.TemplatesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification templates: %w", err)
+	}
+	return templates, nil
+}
+
+// writeJSON prints v as indented JSON
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// sortedKeys returns the keys of a map in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,105 +1,237 @@
 package main
 
 import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/config"
 	"AutomaticCVEResolver/services/docker"
-	ntfyclient "AutomaticCVEResolver/services/ntfy"
-	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/registry"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tracing"
 	"context"
+	"errors"
 	"fmt"
-	"gopkg.in/yaml.v3"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-// Define the configuration structure
-type Config struct {
-	Ntfy struct {
-		ServerURL      string `yaml:"server_url"`
-		Topic          string `yaml:"topic"`
-		Username       string `yaml:"username"`
-		Password       string `yaml:"password"`
-		TimeoutSeconds int    `yaml:"timeout_seconds"`
-	} `yaml:"ntfy"`
-}
+func main() {
+	// Cancel everything, including running syft and grype processes, on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-// Function to load configuration from a YAML file
-func loadConfig(configFile string) (*Config, error) {
-	file, err := os.ReadFile(configFile)
+	opts := &globalOptions{}
+	err := newRootCommand(opts).ExecuteContext(ctx)
+	stop()
+	if saveErr := opts.saveRecording(); saveErr != nil {
+		err = errors.Join(err, saveErr)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		slog.Default().Error("Command failed", "error", err)
+		os.Exit(1)
 	}
+}
 
-	var config Config
-	err = yaml.Unmarshal(file, &config)
+// setupLogging installs the configured logger as the default one. Logs go to stderr so stdout only carries reports.
+func setupLogging(cfg *config.Config) (*slog.Logger, error) {
+	logger, err := logging.New(os.Stderr, cfg.Logging.Format, cfg.Logging.Level)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		return nil, fmt.Errorf("invalid logging configuration: %w", err)
 	}
-
-	return &config, nil
+	slog.SetDefault(logger)
+	return logger, nil
 }
 
-func main() {
-	// Initialize the NtfyClient
-	config, err := loadConfig("config.yaml")
+// newApp creates the services scans report to from the configuration; syft, grype and docker run with executor
+func newApp(cfg *config.Config, logger *slog.Logger, executor docker.CommandExecutor, options scanOptions, out io.Writer) (*app, error) {
+	// Every notification passes through the outbox, which retries those that could not be delivered
+	outbox, err := notify.NewOutbox(cfg.Notifications.Outbox.Dir)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		return nil, fmt.Errorf("failed to open notification outbox: %w", err)
+	}
+	outbox.SetLogger(logger)
+	if cfg.Notifications.Outbox.MaxAgeHours > 0 {
+		outbox.SetMaxAge(time.Duration(cfg.Notifications.Outbox.MaxAgeHours) * time.Hour)
 	}
 
-	// Create Ntfy client using configuration values
-	ntfy, err := ntfyclient.NewNtfyClient(
-		config.Ntfy.ServerURL, // Ntfy server URL
-		config.Ntfy.Topic,     // Topic
-		config.Ntfy.Username,  // Username
-		config.Ntfy.Password,  // Password
-		time.Duration(config.Ntfy.TimeoutSeconds)*time.Second, // Timeout
-	)
+	templates, err := loadTemplates(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize Ntfy client: %v", err)
+		return nil, err
 	}
 
-	// Initialize the notification service
-	notificationService := docker.NewNotificationService(ntfy)
+	channels, err := notificationChannels(cfg, templates, outbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize notification channels: %w", err)
+	}
 
-	// Initialize DockerSBOMService with RealCommandExecutor
-	executor := &docker.RealCommandExecutor{}
 	sbomService := docker.NewDockerSBOMService(executor)
+	sbomService.SetLogger(logger)
+	sbomService.SetLimits(scanLimits(cfg, logger))
+	sbomService.SetRegistry(registry.NewClient(cfg.Registry))
 
-	// Create a context with timeout for all operations
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+	// Initialize the notification service
+	notificationService := docker.NewNotificationService(channels...)
+	notificationService.SetLogger(logger)
+	notificationService.SetAPI(cfg.Notifications.APIURL, cfg.LinkSigner())
 
-	// Generate SBOMs and scan for CVEs for all running containers
-	sbomResults, cveResults, err := sbomService.GenerateSBOMAndScanForCVEs(ctx)
+	var router *routing.Router
+	if cfg.Notifications.RoutesFile != "" {
+		router, err = routing.LoadRouter(cfg.Notifications.RoutesFile, notificationService.ChannelNames())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load routing rules: %w", err)
+		}
+		notificationService.SetRouter(router, sbomService.ContainerLabels)
+	}
+
+	// Keep scan runs so they can be queried later through the HTTP API
+	results, err := store.NewStore(cfg.Store.Dir)
 	if err != nil {
-		log.Fatalf("Error generating SBOMs and scanning for CVEs: %v", err)
+		return nil, fmt.Errorf("failed to open results store: %w", err)
+	}
+	results.SetLogger(logger)
+	if err := results.SetRetention(cfg.Store.MaxRuns, time.Duration(cfg.Store.MaxAgeDays)*24*time.Hour); err != nil {
+		logger.Warn("Failed to remove old scan runs", "error", err)
+	}
+
+	// Alerts are repeated and escalated in serve mode; scans only raise them
+	var alerts *alerting.Manager
+	if cfg.Alerting.Enabled {
+		alerts, err = alerting.NewManager(cfg.Alerting, results, notificationService)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alerting configuration: %w", err)
+		}
+		alerts.SetLogger(logger)
+		notificationService.SetQuietHours(alerts.QuietHours())
 	}
 
-	// Print the SBOM results
-	for containerID, sbom := range sbomResults {
-		fmt.Printf("SBOM for container %s:\n%s\n", containerID, sbom)
+	ignored, err := ignore.NewList(cfg.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore list: %w", err)
 	}
 
-	// Print the CVE results and send notifications
-	for containerID, cveReport := range cveResults {
-		fmt.Printf("CVE Report for container %s:\n", containerID)
-		tableprinter.PrintCVEResults(containerID, cveReport)
-		// Format the title and message
-		message := fmt.Sprintf("CVE Report for container %s:\n%s", containerID, cveReport)
-		title := fmt.Sprintf("CVE Scan Results for Container %s", containerID)
+	a := &app{
+		sbomService:         sbomService,
+		notificationService: notificationService,
+		results:             results,
+		router:              router,
+		outbox:              outbox,
+		templates:           templates,
+		releases:            cfg.Releases(),
+		alerts:              alerts,
+		logger:              logger,
+		runTimeout:          time.Duration(cfg.Scan.TimeoutMinutes) * time.Minute,
+		options:             options,
+		out:                 out,
+	}
+	a.ignored.Store(ignored)
+	return a, nil
+}
 
-		// Send a notification about the CVE scan
-		err := notificationService.SendNotification(message, title)
+// newRoutingApp creates what routing a notification needs: the channels, routing rules and quiet hours, and
+// docker to look up containers with. Unlike newApp it opens neither the outbox nor the results store, which a
+// running scanner may be using or a read-only host may not allow.
+func newRoutingApp(cfg *config.Config, logger *slog.Logger, executor docker.CommandExecutor) (*app, error) {
+	templates, err := loadTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := notificationChannels(cfg, templates, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize notification channels: %w", err)
+	}
+
+	sbomService := docker.NewDockerSBOMService(executor)
+	sbomService.SetLogger(logger)
+	notificationService := docker.NewNotificationService(channels...)
+	notificationService.SetLogger(logger)
+
+	var router *routing.Router
+	if cfg.Notifications.RoutesFile != "" {
+		router, err = routing.LoadRouter(cfg.Notifications.RoutesFile, notificationService.ChannelNames())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load routing rules: %w", err)
+		}
+		notificationService.SetRouter(router, sbomService.ContainerLabels)
+	}
+	if cfg.Alerting.Enabled {
+		if err := cfg.Alerting.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid alerting configuration: %w", err)
+		}
+		quietHours, err := alerting.NewSchedule(cfg.Alerting.QuietHours)
 		if err != nil {
-			fmt.Printf("Failed to send notification for container %s: %v\n", containerID, err)
+			return nil, fmt.Errorf("invalid alerting configuration: %w", err)
 		}
+		notificationService.SetQuietHours(quietHours)
+	}
+
+	return &app{
+		sbomService:         sbomService,
+		notificationService: notificationService,
+		router:              router,
+		templates:           templates,
+		logger:              logger,
+	}, nil
+}
+
+// checkServices checks what creating the services would: the templates, notification channels, routing
+// rules and alerting settings. Like newRoutingApp it opens neither the outbox nor the results store.
+func checkServices(cfg *config.Config) error {
+	_, err := newRoutingApp(cfg, slog.Default(), &docker.RealCommandExecutor{})
+	return err
+}
+
+// scanLimits returns the configured workers and timeouts of the scan stages, lowered to what the host can
+// spare when scans adapt to it
+func scanLimits(cfg *config.Config, logger *slog.Logger) docker.Limits {
+	limits := cfg.Scan.Limits()
+	if !cfg.Scan.Adaptive {
+		return limits
 	}
 
-	// Send a final notification that the process is complete
-	finalMessage := "SBOM and CVE scanning completed for all running containers"
-	finalTitle := "Scan Complete"
-	err = notificationService.SendNotification(finalMessage, finalTitle)
+	host := docker.HostResources()
+	adapted := limits.Adapt(host, uint64(cfg.Scan.WorkerMemoryMB)*1024*1024)
+	if adapted != limits {
+		logger.Info("Lowered scan workers to what the host can spare",
+			"syft_workers", adapted.SyftWorkers, "grype_workers", adapted.GrypeWorkers,
+			"cpus", host.CPUs, "available_memory_mb", host.AvailableMemory/1024/1024)
+	}
+	return adapted
+}
+
+// startTracing exports spans for every scan run, target, syft and grype call and notification.
+// The returned function flushes pending spans.
+func startTracing(ctx context.Context, cfg *config.Config, logger *slog.Logger) (func(), error) {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
 	if err != nil {
-		fmt.Printf("Failed to send final notification: %v\n", err)
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	return func() {
+		// Flush pending spans even when the main context is already cancelled
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("Failed to flush traces", "error", err)
+		}
+	}, nil
+}
+
+// notificationChannels creates the configured notification channels, rendering their messages with
+// the templates and recording their deliveries in the outbox
+func notificationChannels(cfg *config.Config, templates *notify.Templates, outbox *notify.Outbox) ([]notify.Channel, error) {
+	channelConfigs := cfg.NotificationChannels()
+	channels := make([]notify.Channel, 0, len(channelConfigs))
+	for _, channelConfig := range channelConfigs {
+		channel, err := notify.NewChannel(channelConfig, templates, outbox)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
 	}
+	return channels, nil
 }
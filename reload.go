@@ -0,0 +1,221 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/api"
+	"AutomaticCVEResolver/services/config"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/scheduler"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configSource is where serve reads its configuration again when reloading
+type configSource struct {
+	path string                         // Checked for changes, nothing is watched when empty
+	load func() (*config.Config, error) // Loads and validates the configuration
+}
+
+// reloader applies changes of the configuration while serving. Routing rules, the alerting policy, the
+// ignore list and the schedules are swapped in together; scans in progress finish with the settings they
+// started with.
+// Other settings only take effect after a restart.
+type reloader struct {
+	app       *app
+	source    configSource
+	scheduler *scheduler.Scheduler
+	scan      api.ScanFunc
+
+	current         *config.Config
+	stopRouteWatch  context.CancelFunc
+	routeWatchGroup sync.WaitGroup
+}
+
+func newReloader(a *app, source configSource, cfg *config.Config, s *scheduler.Scheduler, scan api.ScanFunc) *reloader {
+	return &reloader{app: a, source: source, scheduler: s, scan: scan, current: cfg}
+}
+
+// run reloads the configuration on SIGHUP and whenever the modification time of the file changes,
+// until ctx is cancelled
+func (r *reloader) run(ctx context.Context) {
+	defer r.routeWatchGroup.Wait()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var ticks <-chan time.Time
+	if r.source.path != "" && r.current.ReloadSeconds > 0 {
+		ticker := time.NewTicker(time.Duration(r.current.ReloadSeconds) * time.Second)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	lastModified := modTime(r.source.path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.app.logger.Info("Received SIGHUP, reloading configuration")
+			lastModified = modTime(r.source.path)
+		case <-ticks:
+			modified := modTime(r.source.path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+		}
+		r.reload(ctx)
+	}
+}
+
+// reload loads the configuration again and applies it. An invalid configuration is not applied at all:
+// the previous one stays in effect and every channel is notified.
+func (r *reloader) reload(ctx context.Context) error {
+	cfg, err := r.source.load()
+	if err == nil {
+		err = r.apply(ctx, cfg)
+	}
+	if err != nil {
+		r.app.logger.Error("Keeping the previous configuration, the new one is invalid", "path", r.source.path, "error", err)
+		message := fmt.Sprintf("The changed configuration was not applied, the previous one stays in effect: %v", err)
+		if err := r.app.notificationService.SendNotification(ctx, message, "Configuration reload failed"); err != nil {
+			r.app.logger.Error("Failed to send notification", "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// apply swaps in the parts of cfg that can change while serving. Every part is built and checked before
+// any is replaced, so an invalid one leaves all of them as they were.
+func (r *reloader) apply(ctx context.Context, cfg *config.Config) error {
+	jobs, err := r.app.scheduleJobs(cfg.Schedules, r.scan)
+	if err != nil {
+		return err
+	}
+	if err := scheduler.ValidateJobs(jobs); err != nil {
+		return err
+	}
+
+	routed := r.app.router != nil && cfg.Notifications.RoutesFile != ""
+	var rules routing.Config
+	if routed {
+		if rules, err = routing.LoadConfig(cfg.Notifications.RoutesFile); err != nil {
+			return err
+		}
+		if err := r.app.router.Check(rules); err != nil {
+			return fmt.Errorf("invalid routing rules: %w", err)
+		}
+	}
+
+	alerting := r.app.alerts != nil && cfg.Alerting.Enabled
+	if alerting {
+		if err := cfg.Alerting.Validate(); err != nil {
+			return fmt.Errorf("invalid alerting configuration: %w", err)
+		}
+	}
+
+	ignored, err := ignore.NewList(cfg.Ignore)
+	if err != nil {
+		return fmt.Errorf("invalid ignore list: %w", err)
+	}
+
+	// Everything was checked above, so none of the replacements below fails half way
+	if routed {
+		if err := r.app.router.Reload(rules); err != nil {
+			return fmt.Errorf("invalid routing rules: %w", err)
+		}
+	}
+	if alerting {
+		if err := r.app.alerts.Reconfigure(cfg.Alerting); err != nil {
+			return fmt.Errorf("invalid alerting configuration: %w", err)
+		}
+		r.app.notificationService.SetQuietHours(r.app.alerts.QuietHours())
+	}
+	r.app.ignored.Store(ignored)
+	if err := r.scheduler.Replace(jobs); err != nil {
+		return err
+	}
+
+	if routed && (cfg.Notifications.RoutesFile != r.current.Notifications.RoutesFile ||
+		cfg.Notifications.RoutesReloadSeconds != r.current.Notifications.RoutesReloadSeconds) {
+		r.watchRoutes(ctx, cfg)
+	}
+
+	if settings := restartRequired(r.current, cfg); len(settings) > 0 {
+		r.app.logger.Warn("Some changed settings only take effect after a restart", "settings", settings)
+	}
+	r.current = cfg
+	r.app.logger.Info("Reloaded configuration", "path", r.source.path, "schedules", len(jobs), "ignore_rules", ignored.Len())
+	return nil
+}
+
+// watchRoutes reloads the routing rules file on its own as well, replacing the previous watch
+func (r *reloader) watchRoutes(ctx context.Context, cfg *config.Config) {
+	if r.app.router == nil {
+		return
+	}
+	if r.stopRouteWatch != nil {
+		r.stopRouteWatch()
+	}
+
+	interval := time.Duration(cfg.Notifications.RoutesReloadSeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ctx, r.stopRouteWatch = context.WithCancel(ctx)
+	path := cfg.Notifications.RoutesFile
+	r.routeWatchGroup.Add(1)
+	go func() {
+		defer r.routeWatchGroup.Done()
+		r.app.router.Watch(ctx, path, interval, r.app.logger)
+	}()
+}
+
+// restartRequired lists the changed settings that are only read on start
+func restartRequired(old, updated *config.Config) []string {
+	var settings []string
+	changed := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			settings = append(settings, name)
+		}
+	}
+	changed("ntfy", old.Ntfy, updated.Ntfy)
+	changed("notifications.channels", old.Notifications.Channels, updated.Notifications.Channels)
+	changed("notifications.api_url", old.Notifications.APIURL, updated.Notifications.APIURL)
+	changed("notifications.link_secret", old.Notifications.LinkSecret, updated.Notifications.LinkSecret)
+	changed("notifications.link_ttl_hours", old.Notifications.LinkTTLHours, updated.Notifications.LinkTTLHours)
+	changed("notifications.templates_dir", old.Notifications.TemplatesDir, updated.Notifications.TemplatesDir)
+	changed("notifications.outbox", old.Notifications.Outbox, updated.Notifications.Outbox)
+	changed("notifications.routes_file", old.Notifications.RoutesFile == "", updated.Notifications.RoutesFile == "")
+	changed("alerting.enabled", old.Alerting.Enabled, updated.Alerting.Enabled)
+	changed("scan", old.Scan, updated.Scan)
+	changed("events", old.Events, updated.Events)
+	changed("api", old.API, updated.API)
+	changed("store", old.Store, updated.Store)
+	changed("metrics", old.Metrics, updated.Metrics)
+	changed("tracing", old.Tracing, updated.Tracing)
+	changed("logging", old.Logging, updated.Logging)
+	changed("reload_seconds", old.ReloadSeconds, updated.ReloadSeconds)
+	return settings
+}
+
+// modTime returns the modification time of the file, zero when it cannot be read
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/config"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/scheduler"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reloadTest serves with routing rules, alerting and a schedule, and records the notifications sent
+type reloadTest struct {
+	app      *app
+	reloader *reloader
+	routes   string
+	next     *config.Config // Returned by the next load
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func newReloadTest(t *testing.T) *reloadTest {
+	rt := &reloadTest{routes: filepath.Join(t.TempDir(), "routes.yaml")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rt.mu.Lock()
+		rt.sent = append(rt.sent, string(body))
+		rt.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	rt.writeRoutes(t, "hook")
+	cfg := reloadConfig(server.URL, rt.routes)
+	a, err := newApp(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), &docker.RealCommandExecutor{}, scanOptions{}, io.Discard)
+	assert.NoError(t, err)
+	rt.app = a
+
+	s := scheduler.NewScheduler()
+	jobs, err := a.scheduleJobs(cfg.Schedules, a.scan)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Replace(jobs))
+	source := configSource{load: func() (*config.Config, error) { return rt.next, nil }}
+	rt.reloader = newReloader(a, source, cfg, s, a.scan)
+	return rt
+}
+
+// reloadConfig returns a configuration with two webhook channels and the routing rules file
+func reloadConfig(url, routes string) *config.Config {
+	cfg := config.Default()
+	cfg.Notifications.Channels = []notify.ChannelConfig{
+		{Name: "hook", Type: notify.TypeWebhook, URL: url},
+		{Name: "pager", Type: notify.TypeWebhook, URL: url},
+	}
+	cfg.Notifications.RoutesFile = routes
+	cfg.Alerting.Enabled = true
+	cfg.Schedules = []config.ScheduleConfig{{Name: "nightly", Cron: "0 3 * * *", Target: "running"}}
+	return cfg
+}
+
+// writeRoutes replaces the routing rules with a default route to the channel
+func (rt *reloadTest) writeRoutes(t *testing.T, channel string) {
+	assert.NoError(t, os.WriteFile(rt.routes, []byte("default:\n  channels: ["+channel+"]\n"), 0o600))
+}
+
+func (rt *reloadTest) defaultChannels() []string {
+	return rt.app.router.Route(routing.Subject{})[0].Channels
+}
+
+func TestReload_InvalidKeepsPrevious(t *testing.T) {
+	rt := newReloadTest(t)
+	previous := rt.reloader.current
+
+	// Valid routing rules, quiet hours, ignore list and schedules, but an escalation without channels
+	rt.writeRoutes(t, "pager")
+	rt.next = reloadConfig(previous.Notifications.Channels[0].URL, rt.routes)
+	rt.next.Alerting.QuietHours = alertingQuietHours
+	rt.next.Alerting.EscalateAfterHours = 4
+	rt.next.Ignore = []ignore.Rule{{CVE: "CVE-2023-44487"}}
+	rt.next.Schedules[0].Cron = "0 * * * *"
+
+	err := rt.reloader.reload(context.Background())
+	assert.ErrorContains(t, err, "invalid alerting configuration")
+
+	// None of the parts was replaced
+	assert.Equal(t, []string{"hook"}, rt.defaultChannels())
+	assert.Nil(t, rt.app.alerts.QuietHours())
+	assert.Nil(t, rt.app.ignored.Load())
+	assert.Same(t, previous, rt.reloader.current)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	assert.Len(t, rt.sent, 1)
+	assert.Contains(t, rt.sent[0], "Configuration reload failed")
+}
+
+func TestReload_SwapsAllParts(t *testing.T) {
+	rt := newReloadTest(t)
+
+	rt.writeRoutes(t, "pager")
+	rt.next = reloadConfig(rt.reloader.current.Notifications.Channels[0].URL, rt.routes)
+	rt.next.Alerting.QuietHours = alertingQuietHours
+	rt.next.Ignore = []ignore.Rule{{CVE: "CVE-2023-44487"}}
+	rt.next.Schedules[0].Cron = "0 * * * *"
+
+	assert.NoError(t, rt.reloader.reload(context.Background()))
+	assert.Equal(t, []string{"pager"}, rt.defaultChannels())
+	assert.NotNil(t, rt.app.alerts.QuietHours())
+	assert.Equal(t, 1, rt.app.ignored.Load().Len())
+	assert.Same(t, rt.next, rt.reloader.current)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	assert.Empty(t, rt.sent)
+}
+
+var alertingQuietHours = alerting.QuietHours{Start: "19:00", End: "07:00"}
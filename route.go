@@ -0,0 +1,73 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/routing"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// routeOptions describes the finding to route
+type routeOptions struct {
+	image     string
+	container string // Its image and labels are looked up unless given
+	severity  string // Empty for status messages
+	labels    map[string]string
+}
+
+// routeDryRun prints which channels a finding would be sent to, without sending anything. Like delivery it
+// leaves out channels whose min_severity skips the finding and drops what quiet hours suppress.
+func (a *app) routeDryRun(ctx context.Context, options routeOptions, out io.Writer) error {
+	severity := strings.ToLower(options.severity)
+	if a.notificationService.SuppressedNow(severity) {
+		fmt.Fprintln(out, "Quiet hours are active, the message would be dropped and sent to no channel")
+	}
+	if a.router == nil {
+		fmt.Fprintf(out, "No routing rules configured, every message goes to: %s\n", a.describeChannels(a.notificationService.ChannelNames(), severity))
+		return nil
+	}
+
+	subject := routing.Subject{Image: options.image, ContainerID: options.container, Labels: options.labels, Severity: severity}
+	if options.container != "" {
+		if subject.Image == "" {
+			target, err := a.sbomService.ResolveTargets(ctx, "container:"+options.container)
+			if err != nil {
+				return err
+			}
+			subject.Image = target[0].Image
+		}
+		if len(options.labels) == 0 {
+			containerLabels, err := a.sbomService.ContainerLabels(ctx, options.container)
+			if err != nil {
+				return err
+			}
+			subject.Labels = containerLabels
+		}
+	}
+
+	for _, match := range a.router.Route(subject) {
+		fmt.Fprintf(out, "rule %q: channels %s", match.Rule, a.describeChannels(match.Channels, severity))
+		if match.Topic != "" {
+			fmt.Fprintf(out, ", ntfy topic %s", match.Topic)
+		}
+		if len(match.Fallback) > 0 {
+			fmt.Fprintf(out, ", fallback %s", a.describeChannels(match.Fallback, severity))
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// describeChannels lists the channels taking a message of the severity and, in brackets, those skipping it
+func (a *app) describeChannels(names []string, severity string) string {
+	accepting, skipping := a.notificationService.AcceptingChannels(names, severity)
+	description := strings.Join(accepting, ", ")
+	if description == "" {
+		description = "none"
+	}
+	if len(skipping) > 0 {
+		description += fmt.Sprintf(" (below min_severity: %s)", strings.Join(skipping, ", "))
+	}
+	return description
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteDryRun_LeavesOutboxAndStoreAlone(t *testing.T) {
+	// Neither directory can be created below a file, so opening the outbox or the store would fail
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600))
+	routes := filepath.Join(t.TempDir(), "routes.yaml")
+	assert.NoError(t, os.WriteFile(routes, []byte("default:\n  channels: [pager]\n"), 0o600))
+
+	cfg := reloadConfig("http://hooks.example.com", routes)
+	cfg.Notifications.Outbox.Dir = filepath.Join(file, "outbox")
+	cfg.Store.Dir = filepath.Join(file, "runs")
+
+	a, err := newRoutingApp(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), &docker.RealCommandExecutor{})
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, a.routeDryRun(context.Background(), routeOptions{image: "nginx", severity: "high"}, &out))
+	assert.Equal(t, "rule \"default\": channels pager\n", out.String())
+	assert.NoFileExists(t, cfg.Notifications.Outbox.Dir)
+}
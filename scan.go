@@ -0,0 +1,252 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/metrics"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/tracing"
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// scanOptions holds the optional report sections requested on the command line
+type scanOptions struct {
+	simulateUpgrades bool
+	showPlan         bool
+	planFormat       string
+}
+
+// app bundles the services every scan run reports to, whatever triggered it
+type app struct {
+	sbomService         *docker.DockerSBOMService
+	notificationService *docker.NotificationService
+	results             *store.Store
+	metrics             *metrics.Registry // Only set in serve mode
+	router              *routing.Router   // Only set when routing rules are configured
+	outbox              *notify.Outbox
+	templates           *notify.Templates
+	releases            remediation.Releases // Targeted by base image bumps in remediation plans
+	alerts              *alerting.Manager    // Only set when alerting is enabled
+	ignored             atomic.Pointer[ignore.List]
+	logger              *slog.Logger
+	options             scanOptions
+	out                 io.Writer     // Where reports are printed, discarded when results are printed as JSON
+	runTimeout          time.Duration // Bounds a scan run across all its targets, none when zero
+}
+
+// scan runs runScan under the run timeout
+func (a *app) scan(ctx context.Context, selector string) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+	if a.runTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.runTimeout)
+		defer cancel()
+	}
+	return a.runScan(ctx, selector)
+}
+
+// runScan generates SBOMs and scans the selected targets for CVEs, prints the reports and sends notifications
+func (a *app) runScan(ctx context.Context, selector string) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+	ctx, span := otel.Tracer(tracing.TracerName).Start(ctx, "scan.run", trace.WithAttributes(attribute.String("scan.target", selector)))
+	defer span.End()
+
+	targets, err := a.sbomService.ResolveTargets(ctx, selector)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, err
+	}
+
+	// Generate SBOMs and scan for CVEs for the selected targets
+	sbomResults, cveResults, cancelled := a.sbomService.ScanTargets(ctx, targets)
+	cveResults = a.filterIgnored(ctx, cveResults)
+	span.SetAttributes(
+		attribute.Int("scan.targets", len(targets)),
+		attribute.Int("scan.succeeded", len(cveResults)),
+		attribute.Int("scan.cancelled", len(cancelled)),
+	)
+	a.reportScan(ctx, targets, sbomResults, cveResults, cancelled)
+	return sbomResults, cveResults, nil
+}
+
+// filterIgnored removes the findings of the ignore list from the results, before anything reports them
+func (a *app) filterIgnored(ctx context.Context, cveResults map[string][]tableprinter.CVEInfo) map[string][]tableprinter.CVEInfo {
+	filtered, ignored := a.ignored.Load().Filter(cveResults)
+	if ignored > 0 {
+		logging.FromContext(ctx, a.logger).Info("Left out ignored findings", "count", ignored)
+	}
+	return filtered
+}
+
+// recordScan runs scan as a new run in the results store, logging with the run's ID attached, and returns the run's ID
+func (a *app) recordScan(ctx context.Context, target, trigger string, scan func(ctx context.Context, target string) (map[string]string, map[string][]tableprinter.CVEInfo, error)) (string, error) {
+	run, err := a.results.Create(target, trigger)
+	if err != nil {
+		return "", err
+	}
+
+	logger := logging.FromContext(ctx, a.logger).With("run_id", run.ID, "trigger", trigger)
+	ctx = store.WithRunID(logging.WithLogger(ctx, logger), run.ID)
+	logger.Info("Starting scan", "target", target)
+
+	return run.ID, a.results.Execute(run.ID, func() (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+		return scan(ctx, target)
+	})
+}
+
+// reportScan prints the SBOMs and CVE reports of a finished scan, updates metrics and sends notifications.
+// cancelled holds the targets that timed out or were cancelled, which are recorded with the run.
+func (a *app) reportScan(ctx context.Context, targets []docker.ScanTarget, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string) {
+	a.observeScan(ctx, targets, cveResults)
+	logger := logging.FromContext(ctx, a.logger)
+
+	if runID := store.RunIDFromContext(ctx); runID != "" && len(cancelled) > 0 {
+		err := a.results.Update(runID, func(run *store.Run) {
+			run.Cancelled = cancelled
+		})
+		if err != nil {
+			logger.Error("Failed to record cancelled targets", "error", err)
+		}
+	}
+
+	// Print the SBOM results
+	for containerID, sbom := range sbomResults {
+		fmt.Fprintf(a.out, "SBOM for container %s:\n%s\n", containerID, sbom)
+	}
+
+	targetsByKey := make(map[string]docker.ScanTarget, len(targets))
+	for _, target := range targets {
+		targetsByKey[target.Key()] = target
+	}
+
+	// Print the CVE results and send notifications
+	for containerID, cveReport := range cveResults {
+		fmt.Fprintf(a.out, "CVE Report for container %s:\n", containerID)
+		tableprinter.WriteCVEResults(a.out, cveReport)
+
+		// The full report is attached to the notification, which only summarises it
+		reportData := notify.ReportData{Target: containerID, CVEs: cveReport}
+
+		// Group the findings into ranked fix actions
+		if a.options.showPlan {
+			reportData.Plan = remediation.BuildPlan(cveReport, a.releases)
+			fmt.Fprintf(a.out, "Remediation plan for container %s:\n", containerID)
+			if err := reportData.Plan.Write(a.out, a.options.planFormat); err != nil {
+				logger.Error("Failed to print remediation plan", "container", containerID, "error", err)
+			}
+		}
+
+		report, err := a.templates.Report(reportData)
+		if err != nil {
+			logger.Error("Failed to render report", "container", containerID, "error", err)
+			continue
+		}
+
+		target, ok := targetsByKey[containerID]
+		if !ok {
+			target = docker.ScanTarget{ContainerID: containerID}
+		}
+
+		// Send a notification about the CVE scan
+		err = a.notificationService.SendCVEReport(ctx, store.RunIDFromContext(ctx), target, cveReport, report)
+		if err != nil {
+			logger.Error("Failed to send notification", "container", containerID, "error", err)
+		}
+	}
+
+	// Check which CVEs an in-place package upgrade would close, without rebuilding images
+	if a.options.simulateUpgrades {
+		for _, target := range targets {
+			cveReport, ok := cveResults[target.Key()]
+			if !ok || target.ContainerID == "" || target.Stopped {
+				// Image-only targets and stopped containers have no running container to exec into
+				continue
+			}
+			containerID := target.ContainerID
+			simulation, err := a.sbomService.SimulatePackageUpgrades(ctx, containerID, cveReport)
+			if err != nil {
+				logger.Error("Failed to simulate package upgrade", "container", containerID, "error", err)
+				continue
+			}
+			fmt.Fprintf(a.out, "%s upgrade in container %s would upgrade %d packages and close %d of %d CVEs:\n",
+				simulation.PackageManager, containerID, len(simulation.Upgrades), len(simulation.ClosedCVEs), len(cveReport))
+			tableprinter.WriteCVEResults(a.out, simulation.ClosedCVEs)
+		}
+	}
+
+	// Send a final notification that the process is complete, naming the targets that did not finish
+	finalMessage := fmt.Sprintf("SBOM and CVE scanning completed for %d targets", len(targets))
+	if len(cancelled) > 0 {
+		reasons := make([]string, 0, len(cancelled))
+		for _, key := range sortedKeys(cancelled) {
+			fmt.Fprintf(a.out, "Scan of container %s cancelled: %s\n", key, cancelled[key])
+			reasons = append(reasons, fmt.Sprintf("%s (%s)", key, cancelled[key]))
+		}
+		finalMessage += fmt.Sprintf(", %d cancelled: %s", len(cancelled), strings.Join(reasons, ", "))
+	}
+	finalTitle := "Scan Complete"
+	err := a.notificationService.SendNotification(ctx, finalMessage, finalTitle)
+	if err != nil {
+		logger.Error("Failed to send final notification", "error", err)
+	}
+
+	// Channels batching per run send their digest now that all of the run's notifications are queued
+	if err := a.notificationService.FlushRun(ctx, store.RunIDFromContext(ctx)); err != nil {
+		logger.Error("Failed to send notification digest", "error", err)
+	}
+
+	// Severe findings keep being notified about until someone acknowledges them
+	if runID := store.RunIDFromContext(ctx); a.alerts != nil && runID != "" {
+		if err := a.alerts.Raise(runID, cveResults); err != nil {
+			logger.Error("Failed to raise alert", "error", err)
+		}
+	}
+}
+
+// flushNotifications sends the digests still held back by windowed batching before exiting
+func (a *app) flushNotifications() {
+	// The main context may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := a.notificationService.Flush(ctx); err != nil {
+		a.logger.Error("Failed to send notification digests", "error", err)
+	}
+}
+
+// observeScan exports the vulnerability counts of a finished scan and refreshes the vulnerability DB age
+func (a *app) observeScan(ctx context.Context, targets []docker.ScanTarget, cveResults map[string][]tableprinter.CVEInfo) {
+	if a.metrics == nil {
+		return
+	}
+
+	for _, target := range targets {
+		if cveReport, ok := cveResults[target.Key()]; ok {
+			a.metrics.SetVulnerabilities(target.Image, cveReport)
+		}
+	}
+	if len(cveResults) > 0 {
+		a.metrics.SetLastSuccessfulScan(time.Now())
+	}
+
+	built, err := a.sbomService.VulnerabilityDBBuilt(ctx)
+	if err != nil {
+		logging.FromContext(ctx, a.logger).Error("Failed to get vulnerability DB status", "error", err)
+		return
+	}
+	a.metrics.SetVulnerabilityDBBuilt(built)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"AutomaticCVEResolver/services/api"
+	"AutomaticCVEResolver/services/config"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/metrics"
+	"AutomaticCVEResolver/services/scheduler"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventStreamRetryDelay is how long to wait before following docker events again after the stream ended
+const eventStreamRetryDelay = 5 * time.Second
+
+// serve runs the configured scan schedules, the docker events watcher and the HTTP API until ctx is cancelled.
+// On shutdown in-flight scans are cancelled, which also kills running syft and grype processes.
+// Changes to the configuration read from source are applied while serving, see reloader.
+func (a *app) serve(ctx context.Context, cfg *config.Config, source configSource) error {
+	if len(cfg.Schedules) == 0 && !cfg.Events.Enabled && cfg.API.Listen == "" {
+		return errors.New("no schedules configured, event watching and the HTTP API are disabled")
+	}
+
+	// Every scan, whatever triggered it, runs under the same timeout and ends up in the results store
+	scan := a.scan
+
+	jobs, err := a.scheduleJobs(cfg.Schedules, scan)
+	if err != nil {
+		return err
+	}
+	s := scheduler.NewScheduler()
+	s.SetLogger(a.logger)
+	if err := s.Replace(jobs); err != nil {
+		return err
+	}
+
+	var server *api.Server
+	if cfg.API.Listen != "" {
+		server, err = api.NewServer(ctx, a.results, scan, cfg.API.Tokens)
+		if err != nil {
+			return fmt.Errorf("failed to set up HTTP API: %w", err)
+		}
+		if a.outbox != nil {
+			server.SetOutbox(a.outbox)
+		}
+		server.SetTemplates(a.templates)
+		server.SetReleases(cfg.Releases())
+		if signer := cfg.LinkSigner(); signer != nil {
+			server.SetLinks(signer)
+		}
+	}
+
+	if cfg.Metrics.Listen != "" {
+		host := cfg.Metrics.Host
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		a.metrics = metrics.NewRegistry(host)
+		a.metrics.SetStages(docker.StageSyft, docker.StageGrype, docker.StageParse)
+		a.metrics.SetVulnerabilityMaxAge(time.Duration(cfg.Metrics.VulnerabilitiesMaxAgeHours) * time.Hour)
+		a.sbomService.SetStageObserver(a.metrics)
+	}
+
+	var wg sync.WaitGroup
+	if cfg.Events.Enabled {
+		watcher := docker.NewEventWatcher(
+			a.sbomService,
+			time.Duration(cfg.Events.DebounceSeconds)*time.Second,
+			time.Duration(cfg.Events.RescanAfterMinutes)*time.Minute,
+			func(ctx context.Context, targets []docker.ScanTarget, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string) {
+				_, err := a.recordScan(ctx, "event", "event", func(ctx context.Context, _ string) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+					cveResults := a.filterIgnored(ctx, cveResults)
+					a.reportScan(ctx, targets, sbomResults, cveResults, cancelled)
+					return sbomResults, cveResults, nil
+				})
+				if err != nil {
+					a.logger.Error("Failed to record event-triggered scan", "error", err)
+				}
+			},
+		)
+		watcher.SetMaxDelay(time.Duration(cfg.Events.MaxDelaySeconds) * time.Second)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchEvents(ctx, watcher, a.logger)
+		}()
+	}
+
+	reloader := newReloader(a, source, cfg, s, scan)
+	reloader.watchRoutes(ctx, cfg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reloader.run(ctx)
+	}()
+
+	if a.alerts != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.alerts.Run(ctx, time.Minute)
+		}()
+	}
+
+	if a.outbox != nil {
+		interval := time.Duration(cfg.Notifications.Outbox.RetrySeconds) * time.Second
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.outbox.Run(ctx, interval)
+		}()
+	}
+
+	if server != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.logger.Info("HTTP API listening", "address", cfg.API.Listen)
+			if err := server.ListenAndServe(ctx, cfg.API.Listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error("HTTP API stopped", "error", err)
+			}
+		}()
+	}
+
+	if a.metrics != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.logger.Info("Metrics endpoint listening", "address", cfg.Metrics.Listen)
+			if err := serveMetrics(ctx, cfg.Metrics.Listen, a.metrics); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error("Metrics endpoint stopped", "error", err)
+			}
+		}()
+	}
+
+	a.logger.Info("Serving", "schedules", len(cfg.Schedules), "events", cfg.Events.Enabled)
+	s.Start(ctx)
+	<-ctx.Done()
+	s.Wait()
+	wg.Wait()
+	a.flushNotifications()
+	a.logger.Info("Shut down scheduled scans")
+	return nil
+}
+
+// scheduleJobs turns the configured schedules into scheduler jobs recording their scans
+func (a *app) scheduleJobs(schedules []config.ScheduleConfig, scan api.ScanFunc) ([]scheduler.Job, error) {
+	jobs := make([]scheduler.Job, 0, len(schedules))
+	for _, schedule := range schedules {
+		cron, err := scheduler.ParseCron(schedule.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %s: %w", schedule.Name, err)
+		}
+
+		target := schedule.Target
+		jobs = append(jobs, scheduler.Job{
+			Name:       schedule.Name,
+			Schedule:   cron,
+			Jitter:     time.Duration(schedule.JitterSeconds) * time.Second,
+			RunOnStart: schedule.RunOnStart,
+			Run: func(ctx context.Context) error {
+				_, err := a.recordScan(ctx, target, "schedule", scan)
+				return err
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// watchEvents keeps following the docker events stream, reconnecting when the daemon restarts
+func watchEvents(ctx context.Context, watcher *docker.EventWatcher, logger *slog.Logger) {
+	for {
+		err := watcher.Watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("Docker events watcher stopped, retrying", "delay", eventStreamRetryDelay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventStreamRetryDelay):
+		}
+	}
+}
+
+// serveMetrics exposes the registry on addr/metrics until ctx is cancelled
+func serveMetrics(ctx context.Context, addr string, registry *metrics.Registry) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	return server.ListenAndServe()
+}
@@ -0,0 +1,332 @@
+package alerting
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // Quiet hours name time zones, which slim images lack
+)
+
+// Config configures the alert lifecycle of severe findings
+type Config struct {
+	Enabled            bool       `yaml:"enabled"`
+	MinSeverity        string     `yaml:"min_severity"`         // Findings raising an alert, defaults to critical
+	RepeatMinutes      int        `yaml:"repeat_minutes"`       // Reminder interval until acknowledged, 0 sends none
+	EscalateAfterHours int        `yaml:"escalate_after_hours"` // 0 never escalates
+	EscalationChannels []string   `yaml:"escalation_channels"`
+	QuietHours         QuietHours `yaml:"quiet_hours"`
+}
+
+// QuietHours configures when only the most severe notifications get through
+type QuietHours struct {
+	Start       string `yaml:"start"`        // e.g. "19:00", empty disables quiet hours on weekdays
+	End         string `yaml:"end"`          // e.g. "07:30"; before Start when the quiet hours span midnight
+	Weekends    bool   `yaml:"weekends"`     // Quiet all day on Saturdays and Sundays
+	Timezone    string `yaml:"timezone"`     // IANA name, defaults to the local time zone
+	MinSeverity string `yaml:"min_severity"` // Notifications less severe are suppressed, defaults to critical
+}
+
+// Schedule decides whether a notification is suppressed by quiet hours
+type Schedule struct {
+	start, end  int // Minutes after midnight; equal when only weekends are quiet
+	weekends    bool
+	location    *time.Location
+	minSeverity string
+}
+
+// NewSchedule validates the quiet hours. It returns nil, which suppresses nothing, when none are configured.
+func NewSchedule(config QuietHours) (*Schedule, error) {
+	if config.Start == "" && config.End == "" && !config.Weekends {
+		return nil, nil
+	}
+
+	s := &Schedule{weekends: config.Weekends, location: time.Local, minSeverity: "critical"}
+	if config.Start != "" || config.End != "" {
+		var err error
+		if s.start, err = parseClock(config.Start); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours start: %w", err)
+		}
+		if s.end, err = parseClock(config.End); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours end: %w", err)
+		}
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours timezone: %w", err)
+		}
+		s.location = location
+	}
+	if config.MinSeverity != "" {
+		if notify.SeverityRank(config.MinSeverity) == 0 {
+			return nil, fmt.Errorf("invalid quiet hours severity %q", config.MinSeverity)
+		}
+		s.minSeverity = strings.ToLower(config.MinSeverity)
+	}
+	return s, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether t falls into the quiet hours
+func (s *Schedule) Active(t time.Time) bool {
+	if s == nil {
+		return false
+	}
+	t = t.In(s.location)
+	if s.weekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case s.start == s.end:
+		return false
+	case s.start < s.end:
+		return minute >= s.start && minute < s.end
+	default:
+		// Spans midnight
+		return minute >= s.start || minute < s.end
+	}
+}
+
+// Suppresses reports whether a notification with the given worst severity is suppressed at t. Suppressed
+// notifications are dropped, only the reminders of alerts are sent once the quiet hours are over.
+// Status messages, which have no severity, are suppressed during quiet hours as well.
+func (s *Schedule) Suppresses(severity string, t time.Time) bool {
+	return s.Active(t) && notify.SeverityRank(severity) < notify.SeverityRank(s.minSeverity)
+}
+
+// Sender delivers alert reminders and escalations. Nil channels use the regular routing.
+type Sender interface {
+	SendAlert(ctx context.Context, run store.Run, escalation bool, channels []string) error
+}
+
+// Manager raises alerts for severe findings and repeats and escalates them until they are acknowledged.
+// Alerts live in the scan run store, so they survive restarts and are acknowledged through it.
+type Manager struct {
+	store  *store.Store
+	sender Sender
+	policy atomic.Pointer[policy]
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// policy holds the validated settings of a Manager, which are replaced as a whole
+type policy struct {
+	minSeverity string
+	repeat      time.Duration
+	escalate    time.Duration
+	escalation  []string
+	quiet       *Schedule
+}
+
+func newPolicy(config Config) (*policy, error) {
+	p := &policy{
+		minSeverity: "critical",
+		repeat:      time.Duration(config.RepeatMinutes) * time.Minute,
+		escalate:    time.Duration(config.EscalateAfterHours) * time.Hour,
+		escalation:  config.EscalationChannels,
+	}
+	if config.MinSeverity != "" {
+		if notify.SeverityRank(config.MinSeverity) == 0 {
+			return nil, fmt.Errorf("invalid alerting severity %q", config.MinSeverity)
+		}
+		p.minSeverity = strings.ToLower(config.MinSeverity)
+	}
+	if p.escalate > 0 && len(p.escalation) == 0 {
+		return nil, errors.New("escalation needs at least one escalation channel")
+	}
+
+	var err error
+	if p.quiet, err = NewSchedule(config.QuietHours); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the configuration without creating a Manager
+func (c Config) Validate() error {
+	_, err := newPolicy(c)
+	return err
+}
+
+// NewManager validates the configuration and creates a Manager
+func NewManager(config Config, st *store.Store, sender Sender) (*Manager, error) {
+	p, err := newPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		store:  st,
+		sender: sender,
+		logger: slog.Default(),
+		now:    time.Now,
+	}
+	m.policy.Store(p)
+	return m, nil
+}
+
+// Reconfigure validates the configuration and, when it is valid, applies it to the alerts checked
+// from then on. Whether alerting is enabled is not changed by it.
+func (m *Manager) Reconfigure(config Config) error {
+	p, err := newPolicy(config)
+	if err != nil {
+		return err
+	}
+	m.policy.Store(p)
+	return nil
+}
+
+// SetLogger replaces the default logger
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// SetClock replaces time.Now, for tests
+func (m *Manager) SetClock(now func() time.Time) {
+	m.now = now
+}
+
+// QuietHours returns the quiet hours schedule, nil when there are none
+func (m *Manager) QuietHours() *Schedule {
+	return m.policy.Load().quiet
+}
+
+// Raise is called with the results of every completed run, keyed by scanned target. It records an alert
+// for the targets whose findings are severe enough; the run's regular notifications count as its first one.
+// Alerts of earlier runs no longer cover the targets scanned again, whether their findings were fixed or
+// alerted on anew, and are superseded once none of their targets is left.
+func (m *Manager) Raise(runID string, cveResults map[string][]tableprinter.CVEInfo) error {
+	p := m.policy.Load()
+	var all []tableprinter.CVEInfo
+	var targets []string
+	for target, cveList := range cveResults {
+		if notify.SeverityRank(notify.WorstSeverity(cveList)) >= notify.SeverityRank(p.minSeverity) {
+			all = append(all, cveList...)
+			targets = append(targets, target)
+		}
+	}
+	slices.Sort(targets)
+
+	current, ok := m.store.Get(runID)
+	if !ok {
+		return fmt.Errorf("scan run %s not found", runID)
+	}
+	if len(targets) > 0 {
+		now := m.now().UTC()
+		alert := &store.Alert{Severity: notify.WorstSeverity(all), RaisedAt: now, LastNotifiedAt: now, Targets: targets}
+		if err := m.store.Update(runID, func(run *store.Run) { run.Alert = alert }); err != nil {
+			return err
+		}
+	}
+
+	for _, run := range m.store.List() {
+		if run.ID == runID || !run.Active() || run.CreatedAt.After(current.CreatedAt) {
+			continue
+		}
+		remaining := slices.DeleteFunc(slices.Clone(run.AlertTargets()), func(target string) bool {
+			_, scanned := cveResults[target]
+			return scanned
+		})
+		if len(remaining) == len(run.AlertTargets()) {
+			continue
+		}
+		err := m.update(run.ID, func(alert *store.Alert) {
+			alert.Targets = remaining
+			if len(remaining) == 0 {
+				alert.SupersededBy = runID
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check sends the reminders and escalations that are due
+func (m *Manager) Check(ctx context.Context) error {
+	p := m.policy.Load()
+	now := m.now().UTC()
+
+	var errs []error
+	for _, run := range m.store.List() {
+		if !run.Active() {
+			continue
+		}
+		logger := m.logger.With("run_id", run.ID, "targets", run.AlertTargets())
+
+		if p.escalate > 0 && run.Alert.EscalatedAt.IsZero() && now.Sub(run.Alert.RaisedAt) >= p.escalate {
+			// Escalations are not held back by quiet hours, they exist for unanswered alerts
+			if err := m.sender.SendAlert(ctx, run, true, p.escalation); err != nil {
+				errs = append(errs, fmt.Errorf("failed to escalate alert of run %s: %w", run.ID, err))
+				continue
+			}
+			logger.Warn("Escalated unacknowledged alert", "channels", p.escalation, "raised_at", run.Alert.RaisedAt)
+			errs = append(errs, m.update(run.ID, func(alert *store.Alert) {
+				alert.EscalatedAt = now
+				alert.LastNotifiedAt = now
+			}))
+			continue
+		}
+
+		if p.repeat <= 0 || now.Sub(run.Alert.LastNotifiedAt) < p.repeat {
+			continue
+		}
+		if p.quiet.Suppresses(run.Alert.Severity, now) {
+			// Reminded once the quiet hours are over
+			continue
+		}
+		if err := m.sender.SendAlert(ctx, run, false, nil); err != nil {
+			errs = append(errs, fmt.Errorf("failed to repeat alert of run %s: %w", run.ID, err))
+			continue
+		}
+		logger.Info("Repeated unacknowledged alert", "reminders", run.Alert.Reminders+1)
+		errs = append(errs, m.update(run.ID, func(alert *store.Alert) {
+			alert.Reminders++
+			alert.LastNotifiedAt = now
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// update changes a copy of the run's alert, as copies of the run handed out earlier share the old one
+func (m *Manager) update(runID string, fn func(alert *store.Alert)) error {
+	return m.store.Update(runID, func(run *store.Run) {
+		alert := *run.Alert
+		fn(&alert)
+		run.Alert = &alert
+	})
+}
+
+// Run checks for due reminders and escalations every interval until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Check(ctx); err != nil {
+			m.logger.Error("Failed to process alerts", "error", err)
+		}
+	}
+}
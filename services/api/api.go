@@ -0,0 +1,500 @@
+package api
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/links"
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScanFunc scans the given target selector and returns its SBOMs and CVEs keyed by target
+type ScanFunc func(ctx context.Context, target string) (map[string]string, map[string][]tableprinter.CVEInfo, error)
+
+// Server exposes scan jobs and their results over HTTP
+type Server struct {
+	store     *store.Store
+	scan      ScanFunc
+	tokens    []string
+	links     *links.Signer     // Checks the tokens of links in notifications, which are rejected when nil
+	outbox    *notify.Outbox    // Source of notification delivery status, nil when not available
+	templates *notify.Templates // Renders the HTML report, the built-in template when nil
+	releases  remediation.Releases
+
+	// Scans started through the API outlive the request, so they run under the server's context
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+// ScanRequest is the body of POST /api/v1/scans. Target takes a full selector; Container and
+// Image are shortcuts for container:<ref> and image:<ref>. An empty request scans all running containers.
+type ScanRequest struct {
+	Target    string `json:"target"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+}
+
+// selector returns the target selector the request asks for, empty when it names none
+func (r ScanRequest) selector() string {
+	switch {
+	case r.Container != "":
+		return "container:" + r.Container
+	case r.Image != "":
+		return "image:" + r.Image
+	}
+	return r.Target
+}
+
+// key returns the key the results of the requested container or image are stored under
+func (r ScanRequest) key() string {
+	if r.Container != "" {
+		return r.Container
+	}
+	return r.Image
+}
+
+// RunStatus describes a scan run without its potentially large results
+type RunStatus struct {
+	ID             string         `json:"id"`
+	Target         string         `json:"target"`
+	Trigger        string         `json:"trigger"`
+	Status         string         `json:"status"`
+	Error          string         `json:"error,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	StartedAt      time.Time      `json:"started_at,omitempty"`
+	FinishedAt     time.Time      `json:"finished_at,omitempty"`
+	Targets        []string       `json:"targets,omitempty"`
+	SeverityCounts map[string]int `json:"severity_counts,omitempty"`
+	AcknowledgedAt time.Time      `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string         `json:"acknowledged_by,omitempty"`
+	Alert          *store.Alert   `json:"alert,omitempty"`
+}
+
+// AckRequest is the optional body of POST /api/v1/scans/{id}/ack
+type AckRequest struct {
+	By string `json:"by"` // Who acknowledged the findings, defaults to "api"
+}
+
+// DeliveryStatus describes the delivery of one notification to one channel, without its content
+type DeliveryStatus struct {
+	ID          string    `json:"id"`
+	Channel     string    `json:"channel"`
+	Title       string    `json:"title"`
+	RunID       string    `json:"run_id,omitempty"`
+	Status      string    `json:"status"` // pending, delivered or failed
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+}
+
+// NewServer creates a Server that authenticates requests against the given bearer tokens
+func NewServer(ctx context.Context, st *store.Store, scan ScanFunc, tokens []string) (*Server, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("at least one API token is required")
+	}
+	return &Server{store: st, scan: scan, tokens: tokens, releases: remediation.DefaultReleases, ctx: ctx}, nil
+}
+
+// SetLinks makes the server accept the signed links of notifications in place of a bearer token for the
+// report, acknowledgement and rescan of the run each link belongs to
+func (s *Server) SetLinks(signer *links.Signer) {
+	s.links = signer
+}
+
+// SetOutbox exposes the delivery status of notifications under /api/v1/notifications
+func (s *Server) SetOutbox(outbox *notify.Outbox) {
+	s.outbox = outbox
+}
+
+// SetTemplates makes the HTML report use the report.html.tmpl of the given templates
+func (s *Server) SetTemplates(templates *notify.Templates) {
+	s.templates = templates
+}
+
+// SetReleases replaces the built-in latest distribution releases remediation plans offer base image bumps to
+func (s *Server) SetReleases(releases remediation.Releases) {
+	s.releases = releases
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("POST /api/v1/scans", s.authenticate(s.handleStartScan))
+	mux.Handle("GET /api/v1/scans", s.authenticate(s.handleListScans))
+	mux.Handle("GET /api/v1/scans/{id}", s.authenticate(s.handleGetScan))
+	mux.Handle("GET /api/v1/scans/{id}/results", s.authenticate(s.handleGetResults))
+	mux.Handle("GET /api/v1/scans/{id}/sboms/{target...}", s.authenticate(s.handleGetSBOM))
+	mux.Handle("GET /api/v1/scans/{id}/report", s.authenticateLink(links.ActionReport, s.handleGetReport))
+	mux.Handle("POST /api/v1/scans/{id}/ack", s.authenticateLink(links.ActionAck, s.handleAcknowledge))
+	mux.Handle("POST /api/v1/scans/{id}/rescan", s.authenticateLink(links.ActionRescan, s.handleRescan))
+	mux.Handle("GET /api/v1/alerts", s.authenticate(s.handleListAlerts))
+	mux.Handle("GET /api/v1/notifications", s.authenticate(s.handleListNotifications))
+	mux.Handle("GET /api/v1/notifications/{id}", s.authenticate(s.handleGetNotification))
+	return mux
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled, then waits for running scans to stop
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	s.wg.Wait()
+	return err
+}
+
+// authenticate rejects requests without a valid "Authorization: Bearer <token>" header
+func (s *Server) authenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cve-scanner"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// authenticateLink accepts a bearer token or, in the token query parameter, a signed link granting action on the run
+func (s *Server) authenticateLink(action string, next http.HandlerFunc) http.Handler {
+	withToken := s.authenticate(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" || s.links == nil {
+			withToken.ServeHTTP(w, r)
+			return
+		}
+		if err := s.links.Verify(r.PathValue("id"), action, token); err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) validToken(token string) bool {
+	valid := false
+	for _, candidate := range s.tokens {
+		// Compare against every token in constant time so timing does not leak which one matched
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (s *Server) handleStartScan(w http.ResponseWriter, r *http.Request) {
+	var request ScanRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid scan request: %v", err))
+			return
+		}
+	}
+
+	target := request.selector()
+	if target == "" {
+		target = "running"
+	}
+	s.startScan(w, target)
+}
+
+// handleRescan scans one of the run's targets again, or the run's whole selector without a body
+func (s *Server) handleRescan(w http.ResponseWriter, r *http.Request) {
+	var request ScanRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid rescan request: %v", err))
+			return
+		}
+	}
+	run, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	target := request.selector()
+	if target == "" {
+		target = run.Target
+	} else if _, scanned := run.CVEs[request.key()]; !scanned || request.Target != "" {
+		// Links to a run must not start scans of anything else
+		writeError(w, http.StatusBadRequest, "only a container or image scanned in this run can be rescanned")
+		return
+	}
+	s.startScan(w, target)
+}
+
+// startScan records a run for the target and scans it in the background
+func (s *Server) startScan(w http.ResponseWriter, target string) {
+	// A malformed selector is the client's mistake and must not leave a failed run behind
+	if err := docker.ValidateTarget(target); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	run, err := s.store.Create(target, "api")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Tag everything the scan logs with the run it belongs to
+	logger := logging.FromContext(s.ctx, slog.Default()).With("run_id", run.ID, "trigger", "api")
+	ctx := store.WithRunID(logging.WithLogger(s.ctx, logger), run.ID)
+	logger.Info("Starting scan", "target", target)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.store.Execute(run.ID, func() (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+			return s.scan(ctx, target)
+		})
+	}()
+
+	w.Header().Set("Location", "/api/v1/scans/"+run.ID)
+	writeJSON(w, http.StatusAccepted, statusOf(run))
+}
+
+func (s *Server) handleListScans(w http.ResponseWriter, r *http.Request) {
+	runs := s.store.List()
+	statuses := make([]RunStatus, 0, len(runs))
+	for _, run := range runs {
+		statuses = append(statuses, statusOf(run))
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleListAlerts lists the runs whose alerts are still waiting for an acknowledgement
+func (s *Server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]RunStatus, 0)
+	for _, run := range s.store.List() {
+		if run.Active() {
+			statuses = append(statuses, statusOf(run))
+		}
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) handleGetScan(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOf(run))
+}
+
+func (s *Server) handleGetResults(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, run.CVEs)
+}
+
+func (s *Server) handleGetSBOM(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	sbom, ok, err := s.store.SBOM(run.ID, r.PathValue("target"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no SBOM for this target")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(sbom))
+}
+
+func (s *Server) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	var request AckRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid acknowledgement: %v", err))
+			return
+		}
+	}
+	if request.By == "" {
+		request.By = "api"
+	}
+
+	if _, ok := s.lookup(w, r); !ok {
+		return
+	}
+	run, err := s.store.Acknowledge(r.PathValue("id"), request.By)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, statusOf(run))
+}
+
+// handleGetReport renders the run's CVEs as a table, HTML, JSON or a remediation plan (?format=table|html|json|plan)
+func (s *Server) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "json":
+		writeJSON(w, http.StatusOK, run.CVEs)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := s.templates.HTMLReport(w, run); err != nil {
+			slog.Default().Error("Failed to render HTML report", "run_id", run.ID, "error", err)
+		}
+	case "table", "", "plan":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, key := range sortedKeys(run.CVEs) {
+			fmt.Fprintf(w, "CVE Report for %s:\n", key)
+			if format == "plan" {
+				remediation.BuildPlan(run.CVEs[key], s.releases).Write(w, remediation.FormatTable)
+			} else {
+				tableprinter.WriteCVEResults(w, run.CVEs[key])
+			}
+			fmt.Fprintln(w)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported report format %q", format))
+	}
+}
+
+// handleListNotifications lists notification deliveries, newest first, optionally only those with a
+// status or belonging to a run (?status=pending|delivered|failed&run=<id>)
+func (s *Server) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	if s.outbox == nil {
+		writeError(w, http.StatusNotFound, "notification status is not available")
+		return
+	}
+
+	status, runID := r.URL.Query().Get("status"), r.URL.Query().Get("run")
+	deliveries := make([]DeliveryStatus, 0)
+	for _, delivery := range s.outbox.List() {
+		if (status == "" || delivery.Status == status) && (runID == "" || delivery.Message.RunID == runID) {
+			deliveries = append(deliveries, deliveryStatusOf(delivery))
+		}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (s *Server) handleGetNotification(w http.ResponseWriter, r *http.Request) {
+	if s.outbox == nil {
+		writeError(w, http.StatusNotFound, "notification status is not available")
+		return
+	}
+	delivery, ok := s.outbox.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "notification not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveryStatusOf(delivery))
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (store.Run, bool) {
+	run, ok := s.store.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "scan not found")
+	}
+	return run, ok
+}
+
+func statusOf(run store.Run) RunStatus {
+	status := RunStatus{
+		ID:         run.ID,
+		Target:     run.Target,
+		Trigger:    run.Trigger,
+		Status:     run.Status,
+		Error:      run.Error,
+		CreatedAt:  run.CreatedAt,
+		StartedAt:  run.StartedAt,
+		FinishedAt: run.FinishedAt,
+		Targets:    sortedKeys(run.CVEs),
+
+		AcknowledgedAt: run.AcknowledgedAt,
+		AcknowledgedBy: run.AcknowledgedBy,
+		Alert:          run.Alert,
+	}
+	if len(run.CVEs) > 0 {
+		status.SeverityCounts = make(map[string]int)
+		for _, cveList := range run.CVEs {
+			for _, cve := range cveList {
+				status.SeverityCounts[strings.ToLower(cve.Severity)]++
+			}
+		}
+	}
+	return status
+}
+
+func deliveryStatusOf(delivery notify.Delivery) DeliveryStatus {
+	return DeliveryStatus{
+		ID:          delivery.ID,
+		Channel:     delivery.Channel,
+		Title:       delivery.Message.Title,
+		RunID:       delivery.Message.RunID,
+		Status:      delivery.Status,
+		Attempts:    delivery.Attempts,
+		LastError:   delivery.LastError,
+		CreatedAt:   delivery.CreatedAt,
+		UpdatedAt:   delivery.UpdatedAt,
+		NextAttempt: delivery.NextAttempt,
+	}
+}
+
+func sortedKeys(cveResults map[string][]tableprinter.CVEInfo) []string {
+	keys := make([]string, 0, len(cveResults))
+	for key := range cveResults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/links"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/registry"
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/tracing"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the names of the environment variables overriding the configuration file, e.g. CVE_NTFY_PASSWORD
+const EnvPrefix = "CVE"
+
+// Config is the configuration of the scanner
+type Config struct {
+	Ntfy struct {
+		ServerURL      string `yaml:"server_url"`
+		Topic          string `yaml:"topic"`
+		Username       string `yaml:"username"`
+		Password       string `yaml:"password"`
+		TimeoutSeconds int    `yaml:"timeout_seconds"`
+	} `yaml:"ntfy"`
+	Notifications struct {
+		APIURL       string                 `yaml:"api_url"`        // External URL of the HTTP API, enables report links in notifications
+		LinkSecret   string                 `yaml:"link_secret"`    // Signs the links, which then work without an API token
+		LinkTTLHours int                    `yaml:"link_ttl_hours"` // How long the links in a notification keep working
+		Channels     []notify.ChannelConfig `yaml:"channels"`       // Replaces the ntfy section when set
+
+		TemplatesDir string `yaml:"templates_dir"` // Templates overriding the built-in message and report templates by file name
+
+		RoutesFile          string `yaml:"routes_file"`           // Routing rules picking channels per finding, all channels get everything when empty
+		RoutesReloadSeconds int    `yaml:"routes_reload_seconds"` // How often serve mode checks the rules file for changes
+
+		Outbox struct {
+			Dir          string `yaml:"dir"`           // Keeps undelivered notifications across restarts, in memory only when empty
+			RetrySeconds int    `yaml:"retry_seconds"` // How often serve mode retries undelivered notifications
+			MaxAgeHours  int    `yaml:"max_age_hours"` // Undelivered notifications are given up after this long
+		} `yaml:"outbox"`
+	} `yaml:"notifications"`
+	Alerting    alerting.Config `yaml:"alerting"`
+	Ignore      []ignore.Rule   `yaml:"ignore"` // Assessed vulnerabilities left out of reports, notifications and alerts
+	Scan        ScanConfig      `yaml:"scan"`
+	Remediation struct {
+		LatestReleases map[string]string `yaml:"latest_releases"` // By distribution, merged over the built-in table
+	} `yaml:"remediation"`
+	Registry  registry.Config  `yaml:"registry"`
+	Schedules []ScheduleConfig `yaml:"schedules"`
+	Events    struct {
+		Enabled            bool `yaml:"enabled"`
+		DebounceSeconds    int  `yaml:"debounce_seconds"`     // Quiet period before scanning a burst of events
+		MaxDelaySeconds    int  `yaml:"max_delay_seconds"`    // Longest a burst postpones its scan, 0 for no limit
+		RescanAfterMinutes int  `yaml:"rescan_after_minutes"` // Skip image digests scanned more recently than this
+	} `yaml:"events"`
+	API struct {
+		Listen string   `yaml:"listen"` // Address of the HTTP API in serve mode, disabled when empty
+		Tokens []string `yaml:"tokens"` // Accepted bearer tokens
+	} `yaml:"api"`
+	Store struct {
+		Dir        string `yaml:"dir"`          // Directory for scan results, kept in memory only when empty
+		MaxRuns    int    `yaml:"max_runs"`     // Number of runs kept, 0 for no limit
+		MaxAgeDays int    `yaml:"max_age_days"` // Days a run is kept, 0 for no limit
+	} `yaml:"store"`
+	Metrics struct {
+		Listen                     string `yaml:"listen"`                        // Address of the Prometheus endpoint in serve mode, disabled when empty
+		Host                       string `yaml:"host"`                          // Host label on vulnerability series, defaults to the hostname
+		VulnerabilitiesMaxAgeHours int    `yaml:"vulnerabilities_max_age_hours"` // Series of images not scanned again are removed after this, 0 keeps them
+	} `yaml:"metrics"`
+	Tracing tracing.Config `yaml:"tracing"`
+	Logging struct {
+		Format string `yaml:"format"` // text or json
+		Level  string `yaml:"level"`  // debug, info, warn or error
+	} `yaml:"logging"`
+
+	ReloadSeconds int `yaml:"reload_seconds"` // How often serve mode checks this file for changes, 0 only reloads on SIGHUP
+}
+
+// ScanConfig configures how scans run
+type ScanConfig struct {
+	Concurrency         int  `yaml:"concurrency"`           // syft and grype processes each running at the same time
+	SyftWorkers         int  `yaml:"syft_workers"`          // Overrides concurrency for syft
+	GrypeWorkers        int  `yaml:"grype_workers"`         // Overrides concurrency for grype
+	SyftTimeoutSeconds  int  `yaml:"syft_timeout_seconds"`  // Per image, 0 for no limit
+	GrypeTimeoutSeconds int  `yaml:"grype_timeout_seconds"` // Per image, 0 for no limit
+	TimeoutMinutes      int  `yaml:"timeout_minutes"`       // Whole scan run, 0 for no limit
+	Adaptive            bool `yaml:"adaptive"`              // Lower the workers to what the host's CPUs and available memory allow
+	WorkerMemoryMB      int  `yaml:"worker_memory_mb"`      // Memory a syft or grype process is assumed to need when adapting
+
+	Process      ProcessConfig `yaml:"process"`       // Limits of every syft and grype process
+	SyftProcess  ProcessConfig `yaml:"syft_process"`  // Overrides the process settings it sets for syft
+	GrypeProcess ProcessConfig `yaml:"grype_process"` // Overrides the process settings it sets for grype
+	CgroupDir    string        `yaml:"cgroup_dir"`    // Delegated cgroup v2 directory, required by CPU and memory limits
+}
+
+// ProcessConfig limits a scanner subprocess; zero values leave the resource unrestricted
+type ProcessConfig struct {
+	Nice        int     `yaml:"nice"`          // 1 to 19 lower the CPU priority
+	IOClass     string  `yaml:"io_class"`      // idle, best-effort or realtime
+	IOPriority  int     `yaml:"io_priority"`   // 0 (highest) to 7 within best-effort and realtime
+	CPUs        float64 `yaml:"cpus"`          // CPU quota per process, e.g. 0.5
+	MemoryMB    int     `yaml:"memory_mb"`     // The process is killed above it
+	MaxOutputMB int     `yaml:"max_output_mb"` // Output kept in memory, the process is killed when it writes more
+}
+
+func (p ProcessConfig) limits() docker.ProcessLimits {
+	return docker.ProcessLimits{
+		Nice:       p.Nice,
+		IOClass:    p.IOClass,
+		IOPriority: p.IOPriority,
+		CPUs:       p.CPUs,
+		Memory:     int64(p.MemoryMB) * 1024 * 1024,
+		MaxOutput:  int64(p.MaxOutputMB) * 1024 * 1024,
+	}
+}
+
+// ProcessLimits returns the limits of the syft and grype processes by command name
+func (s ScanConfig) ProcessLimits() map[string]docker.ProcessLimits {
+	return map[string]docker.ProcessLimits{
+		docker.StageSyft:  s.Process.limits().Override(s.SyftProcess.limits()),
+		docker.StageGrype: s.Process.limits().Override(s.GrypeProcess.limits()),
+	}
+}
+
+// Limits returns the workers and timeouts of the scan stages, before any adaptation to the host
+func (s ScanConfig) Limits() docker.Limits {
+	limits := docker.Limits{
+		SyftWorkers:  s.Concurrency,
+		GrypeWorkers: s.Concurrency,
+		SyftTimeout:  time.Duration(s.SyftTimeoutSeconds) * time.Second,
+		GrypeTimeout: time.Duration(s.GrypeTimeoutSeconds) * time.Second,
+	}
+	if s.SyftWorkers > 0 {
+		limits.SyftWorkers = s.SyftWorkers
+	}
+	if s.GrypeWorkers > 0 {
+		limits.GrypeWorkers = s.GrypeWorkers
+	}
+	return limits
+}
+
+// ScheduleConfig defines a recurring scan of one target in serve mode
+type ScheduleConfig struct {
+	Name          string `yaml:"name"`
+	Cron          string `yaml:"cron"`           // Five-field cron expression or a macro like @daily
+	JitterSeconds int    `yaml:"jitter_seconds"` // Random delay added to each activation
+	Target        string `yaml:"target"`         // A scan target selector, e.g. running or all,dangling
+	RunOnStart    bool   `yaml:"run_on_start"`
+}
+
+// Default returns the configuration used for everything the file and the environment leave out
+func Default() *Config {
+	c := &Config{}
+	c.Ntfy.TimeoutSeconds = 10
+	c.Notifications.LinkTTLHours = 168
+	c.Notifications.RoutesReloadSeconds = 30
+	c.Notifications.Outbox.RetrySeconds = 30
+	c.Notifications.Outbox.MaxAgeHours = 24
+	c.Alerting.MinSeverity = "critical"
+	c.Scan.Concurrency = 5
+	c.Scan.SyftTimeoutSeconds = 300
+	c.Scan.GrypeTimeoutSeconds = 300
+	c.Scan.TimeoutMinutes = 60
+	c.Scan.Adaptive = true
+	c.Scan.WorkerMemoryMB = 512
+	c.Scan.Process.Nice = 10
+	c.Scan.Process.IOClass = docker.IOClassBestEffort
+	c.Scan.Process.IOPriority = 7
+	c.Scan.Process.MaxOutputMB = 512
+	c.Remediation.LatestReleases = maps.Clone(remediation.DefaultReleases.Latest)
+	c.Registry.MaxTags = 20
+	c.Registry.TimeoutSeconds = 30
+	c.Store.MaxRuns = 1000
+	c.Store.MaxAgeDays = 90
+	c.Metrics.VulnerabilitiesMaxAgeHours = 168
+	c.Events.DebounceSeconds = 10
+	c.Events.MaxDelaySeconds = 60
+	c.Events.RescanAfterMinutes = 60
+	c.Tracing.Endpoint = "localhost:4318"
+	c.Tracing.SampleRatio = 1
+	c.Logging.Format = "text"
+	c.Logging.Level = "info"
+	c.ReloadSeconds = 30
+	return c
+}
+
+// Load layers the defaults, the YAML file at path and the CVE_* variables of environ, in that order.
+// An empty path skips the file. Unknown keys in the file are errors. Any string setting can instead be
+// read from a file, e.g. a Docker or Kubernetes secret: password_file in YAML or CVE_NTFY_PASSWORD_FILE
+// in the environment. The result still needs to be validated once flags have been applied.
+func Load(path string, environ []string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if len(root.Content) > 0 {
+			if err := resolveKeys(&root, reflect.TypeOf(c), ""); err != nil {
+				return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+			}
+			if err := root.Decode(c); err != nil {
+				return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+			}
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, envMap(environ)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LinkSigner returns the signer of the links in notifications, nil when no link secret is configured
+func (c *Config) LinkSigner() *links.Signer {
+	if c.Notifications.LinkSecret == "" {
+		return nil
+	}
+	return links.NewSigner(c.Notifications.LinkSecret, time.Duration(c.Notifications.LinkTTLHours)*time.Hour)
+}
+
+// Releases returns the latest distribution releases base image bumps in remediation plans target
+func (c *Config) Releases() remediation.Releases {
+	if maps.Equal(c.Remediation.LatestReleases, remediation.DefaultReleases.Latest) {
+		return remediation.DefaultReleases
+	}
+	return remediation.Releases{Latest: c.Remediation.LatestReleases, Source: "remediation.latest_releases"}
+}
+
+// NotificationChannels returns the configured channels or, without any, the ntfy section as a channel,
+// which is how notifications were configured before channels existed
+func (c *Config) NotificationChannels() []notify.ChannelConfig {
+	if len(c.Notifications.Channels) > 0 {
+		return c.Notifications.Channels
+	}
+	return []notify.ChannelConfig{{
+		Name:           "ntfy",
+		Type:           notify.TypeNtfy,
+		URL:            c.Ntfy.ServerURL,
+		Topic:          c.Ntfy.Topic,
+		Username:       c.Ntfy.Username,
+		Password:       c.Ntfy.Password,
+		TimeoutSeconds: c.Ntfy.TimeoutSeconds,
+	}}
+}
+
+// resolveKeys rejects the unknown keys of the YAML mappings and replaces each <key>_file with <key>,
+// valued with the content of the file it names, where the struct decoded from the mapping has a string
+// field <key> but none named <key>_file. path is the position in the file, for error messages.
+func resolveKeys(node *yaml.Node, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, content := range node.Content {
+			if err := resolveKeys(content, t, path); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range node.Content {
+			if err := resolveKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if t.Kind() == reflect.Map {
+			for i := 1; i < len(node.Content); i += 2 {
+				if err := resolveKeys(node.Content[i], t.Elem(), join(path, node.Content[i-1].Value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+
+		fields := yamlFields(t)
+		keys := make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keys[node.Content[i].Value] = true
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if field, ok := fields[key.Value]; ok {
+				if err := resolveKeys(value, field.Type, join(path, key.Value)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			name, isFile := strings.CutSuffix(key.Value, "_file")
+			field, ok := fields[name]
+			if !isFile || !ok || field.Type.Kind() != reflect.String {
+				return fmt.Errorf("line %d: unknown setting %s", key.Line, join(path, key.Value))
+			}
+			if keys[name] {
+				return fmt.Errorf("line %d: set %s or %s, not both", key.Line, join(path, name), join(path, key.Value))
+			}
+			content, err := readSecret(value.Value)
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %w", key.Line, join(path, key.Value), err)
+			}
+			key.Value = name
+			*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: content, Line: value.Line, Column: value.Column}
+		}
+	}
+	return nil
+}
+
+// yamlFields returns the fields of a struct by their YAML key
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// readSecret returns the content of a secret file without the trailing newline editors and echo add
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
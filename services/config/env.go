@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envMap turns a list of KEY=value pairs, as returned by os.Environ, into a map
+func envMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, pair := range environ {
+		if key, value, found := strings.Cut(pair, "="); found {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// applyEnv sets the fields of the struct v from the variables named after their YAML keys: upper case
+// and joined by underscores under prefix, with list elements by index, e.g. CVE_NOTIFICATIONS_CHANNELS_0_TOKEN.
+// Only elements the file already configures can be set that way. Lists of strings are comma-separated, and
+// <name>_FILE reads a string setting from a file.
+func applyEnv(v reflect.Value, prefix string, env map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		value := v.Field(i)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			if err := applyEnv(value, key, env); err != nil {
+				return err
+			}
+			continue
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			for j := 0; j < value.Len(); j++ {
+				if err := applyEnv(value.Index(j), fmt.Sprintf("%s_%d", key, j), env); err != nil {
+					return err
+				}
+			}
+			continue
+		case field.Type.Kind() == reflect.Map:
+			// Maps have no fixed keys to name variables after
+			continue
+		}
+
+		raw, ok := env[key]
+		if path, fromFile := env[key+"_FILE"]; fromFile {
+			if ok {
+				return fmt.Errorf("set %s or %s_FILE, not both", key, key)
+			}
+			if field.Type.Kind() != reflect.String {
+				return fmt.Errorf("%s_FILE: only string settings can be read from a file", key)
+			}
+			secret, err := readSecret(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", key, err)
+			}
+			raw, ok = secret, true
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(value, raw); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses raw into the scalar or list of strings v
+func setValue(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/scheduler"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Validate checks the whole configuration and returns every problem found, each naming its setting.
+// Channels, templates and routing rules are checked further when they are created.
+func (c *Config) Validate() error {
+	var errs []error
+	problem := func(setting, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+	positive := func(setting string, value int) {
+		if value <= 0 {
+			problem(setting, "must be positive, got %d", value)
+		}
+	}
+	notNegative := func(setting string, value int) {
+		if value < 0 {
+			problem(setting, "must not be negative, got %d", value)
+		}
+	}
+	validURL := func(setting, value string) {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			problem(setting, "expected an absolute URL like https://host, got %q", value)
+		}
+	}
+
+	if len(c.Notifications.Channels) == 0 {
+		if c.Ntfy.ServerURL == "" || c.Ntfy.Topic == "" {
+			problem("ntfy", "server_url and topic are required unless notifications.channels are configured")
+		}
+		if c.Ntfy.ServerURL != "" {
+			validURL("ntfy.server_url", c.Ntfy.ServerURL)
+		}
+		positive("ntfy.timeout_seconds", c.Ntfy.TimeoutSeconds)
+	}
+
+	names := make(map[string]bool)
+	for i, channel := range c.NotificationChannels() {
+		setting := fmt.Sprintf("notifications.channels[%d]", i)
+		name := channel.Name
+		if name == "" {
+			name = channel.Type
+		}
+		if names[name] {
+			problem(setting, "duplicate channel name %q", name)
+		}
+		names[name] = true
+
+		if channel.MinSeverity != "" && notify.SeverityRank(channel.MinSeverity) == 0 {
+			problem(setting+".min_severity", "unknown severity %q", channel.MinSeverity)
+		}
+		notNegative(setting+".timeout_seconds", channel.TimeoutSeconds)
+		notNegative(setting+".batch_window_seconds", channel.BatchWindowSeconds)
+		notNegative(setting+".rate_limit_per_minute", channel.RateLimitPerMinute)
+		notNegative(setting+".max_message_bytes", channel.MaxMessageBytes)
+		notNegative(setting+".max_attempts", channel.MaxAttempts)
+	}
+	if c.Notifications.APIURL != "" {
+		validURL("notifications.api_url", c.Notifications.APIURL)
+		if c.Notifications.LinkSecret == "" {
+			problem("notifications.link_secret", "required when api_url is set, it signs the report and button links")
+		}
+	}
+	if c.Notifications.LinkSecret != "" && slices.Contains(c.API.Tokens, c.Notifications.LinkSecret) {
+		problem("notifications.link_secret", "must differ from every api.tokens entry")
+	}
+	positive("notifications.link_ttl_hours", c.Notifications.LinkTTLHours)
+	positive("notifications.routes_reload_seconds", c.Notifications.RoutesReloadSeconds)
+	positive("notifications.outbox.retry_seconds", c.Notifications.Outbox.RetrySeconds)
+	positive("notifications.outbox.max_age_hours", c.Notifications.Outbox.MaxAgeHours)
+
+	if c.Alerting.Enabled {
+		if err := c.Alerting.Validate(); err != nil {
+			problem("alerting", "%v", err)
+		}
+		notNegative("alerting.repeat_minutes", c.Alerting.RepeatMinutes)
+		notNegative("alerting.escalate_after_hours", c.Alerting.EscalateAfterHours)
+		for _, channel := range c.Alerting.EscalationChannels {
+			if !names[channel] {
+				problem("alerting.escalation_channels", "unknown channel %q", channel)
+			}
+		}
+	}
+
+	if _, err := ignore.NewList(c.Ignore); err != nil {
+		problem("ignore", "%v", err)
+	}
+
+	for name, release := range c.Remediation.LatestReleases {
+		if release == "" {
+			problem("remediation.latest_releases."+name, "release must not be empty")
+		}
+	}
+
+	positive("scan.concurrency", c.Scan.Concurrency)
+	notNegative("scan.syft_workers", c.Scan.SyftWorkers)
+	notNegative("scan.grype_workers", c.Scan.GrypeWorkers)
+	notNegative("scan.syft_timeout_seconds", c.Scan.SyftTimeoutSeconds)
+	notNegative("scan.grype_timeout_seconds", c.Scan.GrypeTimeoutSeconds)
+	notNegative("scan.timeout_minutes", c.Scan.TimeoutMinutes)
+	notNegative("scan.worker_memory_mb", c.Scan.WorkerMemoryMB)
+	processes := []struct {
+		setting string
+		process ProcessConfig
+	}{
+		{"scan.process", c.Scan.Process},
+		{"scan.syft_process", c.Scan.SyftProcess},
+		{"scan.grype_process", c.Scan.GrypeProcess},
+	}
+	for _, p := range processes {
+		setting, process := p.setting, p.process
+		if process.Nice < -20 || process.Nice > 19 {
+			problem(setting+".nice", "must be between -20 and 19, got %d", process.Nice)
+		}
+		switch process.IOClass {
+		case "", docker.IOClassIdle, docker.IOClassBestEffort, docker.IOClassRealtime:
+		default:
+			problem(setting+".io_class", "must be idle, best-effort or realtime, got %q", process.IOClass)
+		}
+		if process.IOPriority < 0 || process.IOPriority > 7 {
+			problem(setting+".io_priority", "must be between 0 and 7, got %d", process.IOPriority)
+		}
+		if process.CPUs < 0 {
+			problem(setting+".cpus", "must not be negative, got %g", process.CPUs)
+		}
+		notNegative(setting+".memory_mb", process.MemoryMB)
+		notNegative(setting+".max_output_mb", process.MaxOutputMB)
+	}
+	limits := c.Scan.ProcessLimits()
+	for _, stage := range []string{docker.StageSyft, docker.StageGrype} {
+		if (limits[stage].CPUs > 0 || limits[stage].Memory > 0) && c.Scan.CgroupDir == "" {
+			problem("scan.cgroup_dir", "required by the CPU and memory limits of %s", stage)
+		}
+	}
+	notNegative("reload_seconds", c.ReloadSeconds)
+
+	notNegative("registry.max_tags", c.Registry.MaxTags)
+	positive("registry.timeout_seconds", c.Registry.TimeoutSeconds)
+	for i, host := range c.Registry.Insecure {
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			problem(fmt.Sprintf("registry.insecure[%d]", i), "expected a registry host like localhost:5000, got %q", host)
+		}
+	}
+
+	schedules := make(map[string]bool)
+	for i, schedule := range c.Schedules {
+		setting := fmt.Sprintf("schedules[%d]", i)
+		if schedule.Name == "" {
+			problem(setting+".name", "is required")
+		} else if schedules[schedule.Name] {
+			problem(setting+".name", "duplicate schedule name %q", schedule.Name)
+		}
+		schedules[schedule.Name] = true
+		if _, err := scheduler.ParseCron(schedule.Cron); err != nil {
+			problem(setting+".cron", "%v", err)
+		}
+		notNegative(setting+".jitter_seconds", schedule.JitterSeconds)
+		if err := docker.ValidateTarget(schedule.Target); err != nil {
+			problem(setting+".target", "%v", err)
+		}
+	}
+
+	notNegative("store.max_runs", c.Store.MaxRuns)
+	notNegative("store.max_age_days", c.Store.MaxAgeDays)
+	notNegative("metrics.vulnerabilities_max_age_hours", c.Metrics.VulnerabilitiesMaxAgeHours)
+	notNegative("events.debounce_seconds", c.Events.DebounceSeconds)
+	notNegative("events.max_delay_seconds", c.Events.MaxDelaySeconds)
+	notNegative("events.rescan_after_minutes", c.Events.RescanAfterMinutes)
+
+	if c.API.Listen != "" && len(c.API.Tokens) == 0 {
+		problem("api.tokens", "at least one token is required when api.listen is set")
+	}
+	if slices.Contains(c.API.Tokens, "") {
+		problem("api.tokens", "tokens must not be empty")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problem("tracing.sample_ratio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	if _, err := logging.New(io.Discard, c.Logging.Format, c.Logging.Level); err != nil {
+		problem("logging", "%v", err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,8 +1,69 @@
 package docker
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
 
 // CommandExecutor defines an interface for executing system commands
 type CommandExecutor interface {
-	ExecCommand(ctx context.Context, command string, args ...string) ([]byte, error)
+	// ExecCommand runs the command to completion. When it cannot be started or does not exit successfully
+	// the error is an *ExecError, and the result holds whatever the command wrote before.
+	ExecCommand(ctx context.Context, command string, args ...string) (ExecResult, error)
+}
+
+// StreamingCommandExecutor is implemented by executors that can stream the output of long-running commands
+type StreamingCommandExecutor interface {
+	// StreamCommand starts the command and returns its stdout; closing the reader stops the command
+	StreamCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error)
+}
+
+// ExecResult is what a command wrote and how it ended
+type ExecResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int // -1 when the command did not run to completion
+	Duration time.Duration
+}
+
+// ExecError describes a command that could not be started or did not exit successfully
+type ExecError struct {
+	Command  string
+	Args     []string
+	ExitCode int // -1 when the command did not run to completion, e.g. it was not found or killed
+	Stderr   []byte
+	Duration time.Duration
+	Err      error // The underlying error, e.g. *exec.ExitError or the context's error
+}
+
+// maxDiagnosticBytes is how much of the end of stderr error messages quote
+const maxDiagnosticBytes = 1024
+
+func (e *ExecError) Error() string {
+	var message string
+	if e.ExitCode >= 0 {
+		message = fmt.Sprintf("%s exited with code %d", e.Command, e.ExitCode)
+	} else {
+		message = fmt.Sprintf("%s failed: %v", e.Command, e.Err)
+	}
+	if diagnostic := e.Diagnostic(); diagnostic != "" {
+		message += ": " + diagnostic
+	}
+	return message
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic returns the end of what the command wrote to stderr, where tools explain their failures
+func (e *ExecError) Diagnostic() string {
+	diagnostic := strings.TrimSpace(string(e.Stderr))
+	if len(diagnostic) > maxDiagnosticBytes {
+		diagnostic = "..." + diagnostic[len(diagnostic)-maxDiagnosticBytes:]
+	}
+	return diagnostic
 }
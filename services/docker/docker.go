@@ -1,36 +1,74 @@
 package docker
 
 import (
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/registry"
 	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/tracing"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
 )
 
-// Worker pool limit
+// Default number of syft and of grype processes running at the same time
 const maxConcurrency = 5
 
 // DockerSBOMService is the service that interacts with Docker, generates SBOMs, and detects CVEs
 type DockerSBOMService struct {
 	executor CommandExecutor // Use the CommandExecutor interface
+	observer StageObserver   // Optional, notified about stage timings
+	tracer   trace.Tracer
+	logger   *slog.Logger
+	registry *registry.Client // Lists the tags of registry targets
+
+	limits   Limits
+	syftSem  chan struct{} // Workers of the syft stage, shared by all scans of the service
+	grypeSem chan struct{} // Workers of the grype stage, shared by all scans of the service
 }
 
 // NewDockerSBOMService creates a new DockerSBOMService with a given executor
 func NewDockerSBOMService(executor CommandExecutor) *DockerSBOMService {
-	return &DockerSBOMService{executor: executor}
+	ds := &DockerSBOMService{
+		executor: executor,
+		tracer:   otel.Tracer(tracing.TracerName),
+		logger:   slog.Default(),
+		registry: registry.NewClient(registry.Config{}),
+	}
+	ds.SetLimits(Limits{})
+	return ds
+}
+
+// SetLogger replaces the default logger; loggers carried by the context take precedence
+func (ds *DockerSBOMService) SetLogger(logger *slog.Logger) {
+	ds.logger = logger
+}
+
+// SetRegistry replaces the client reaching registries with the default settings
+func (ds *DockerSBOMService) SetRegistry(client *registry.Client) {
+	ds.registry = client
+}
+
+// log returns the logger for a call, preferring the one carried by ctx so run attributes are kept
+func (ds *DockerSBOMService) log(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx, ds.logger)
 }
 
 // ListRunningContainers uses the Docker CLI to list running containers
 func (ds *DockerSBOMService) ListRunningContainers(ctx context.Context) ([]string, error) {
-	output, err := ds.executor.ExecCommand(ctx, "docker", "ps", "--format", "{{.ID}} {{.Image}}")
+	result, err := ds.executor.ExecCommand(ctx, "docker", "ps", "--format", "{{.ID}} {{.Image}}")
 	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %v", err)
+		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	lines := strings.Split(strings.TrimSpace(string(result.Stdout)), "\n")
 	if len(lines) == 0 {
 		return nil, fmt.Errorf("no running containers found")
 	}
@@ -39,18 +77,34 @@ func (ds *DockerSBOMService) ListRunningContainers(ctx context.Context) ([]strin
 
 // GenerateSBOM generates SBOM for a given Docker container image using Syft
 func (ds *DockerSBOMService) GenerateSBOM(ctx context.Context, imageName string) (string, error) {
-	output, err := ds.executor.ExecCommand(ctx, "syft", imageName, "-o", "json")
+	output, err := ds.execInSpan(ctx, "syft", []attribute.KeyValue{attribute.String("image", imageName)}, "syft", imageName, "-o", "json")
 	if err != nil {
-		return "", fmt.Errorf("failed to generate SBOM: %v", err)
+		return "", fmt.Errorf("failed to generate SBOM: %w", err)
 	}
 	return string(output), nil
 }
 
+// VulnerabilityDBBuilt returns when the vulnerability database used by grype was built
+func (ds *DockerSBOMService) VulnerabilityDBBuilt(ctx context.Context) (time.Time, error) {
+	result, err := ds.executor.ExecCommand(ctx, "grype", "db", "status", "-o", "json")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get vulnerability DB status: %w", err)
+	}
+
+	var status struct {
+		Built time.Time `json:"built"`
+	}
+	if err := json.Unmarshal(result.Stdout, &status); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse vulnerability DB status: %v", err)
+	}
+	return status.Built, nil
+}
+
 // ScanForCVEs scans a container image for known vulnerabilities using Grype
 func (ds *DockerSBOMService) ScanForCVEs(ctx context.Context, imageName string) (string, error) {
-	output, err := ds.executor.ExecCommand(ctx, "grype", imageName, "-o", "json")
+	output, err := ds.execInSpan(ctx, "grype", []attribute.KeyValue{attribute.String("image", imageName)}, "grype", imageName, "-o", "json")
 	if err != nil {
-		return "", fmt.Errorf("failed to scan for CVEs: %v", err)
+		return "", fmt.Errorf("failed to scan for CVEs: %w", err)
 	}
 	return string(output), nil
 }
@@ -63,7 +117,9 @@ func parseCVEs(cveReport string, cveList *[]tableprinter.CVEInfo) error {
 				Severity string `json:"severity"`
 			} `json:"vulnerability"`
 			Artifact struct {
+				Name      string `json:"name"`
 				Version   string `json:"version"`
+				Type      string `json:"type"`
 				Locations []struct {
 					Path string `json:"path"`
 				} `json:"locations"`
@@ -73,6 +129,10 @@ func parseCVEs(cveReport string, cveList *[]tableprinter.CVEInfo) error {
 				Versions []string `json:"versions"`
 			} `json:"fix"`
 		} `json:"matches"`
+		Distro struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"distro"`
 	}
 
 	// Parse the JSON report
@@ -81,15 +141,26 @@ func parseCVEs(cveReport string, cveList *[]tableprinter.CVEInfo) error {
 		return fmt.Errorf("failed to parse CVE report: %v", err)
 	}
 
+	distro := ""
+	if grypeOutput.Distro.Name != "" {
+		distro = grypeOutput.Distro.Name + ":" + grypeOutput.Distro.Version
+	}
+
 	// Populate CVEInfo structs
 	for _, match := range grypeOutput.Matches {
 		cve := tableprinter.CVEInfo{
 			CVEName:         match.Vulnerability.ID,
+			Package:         match.Artifact.Name,
+			PackageType:     match.Artifact.Type,
+			Distro:          distro,
 			Date:            time.Now(), // Assuming current date since date is not in the report
 			Severity:        match.Vulnerability.Severity,
 			CurrentVersion:  match.Artifact.Version,
 			ResolvedVersion: "", // Default to empty in case no resolved version is provided
-			Path:            match.Artifact.Locations[0].Path,
+		}
+		// Matches of packages grype found no file for, e.g. from an SBOM, come without locations
+		if len(match.Artifact.Locations) > 0 {
+			cve.Path = match.Artifact.Locations[0].Path
 		}
 
 		// If a fix is available, populate the ResolvedVersion
@@ -103,74 +174,189 @@ func parseCVEs(cveReport string, cveList *[]tableprinter.CVEInfo) error {
 	return nil
 }
 
-func processContainer(ctx context.Context, containerID string, imageName string, ds *DockerSBOMService, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, wg *sync.WaitGroup, sem chan struct{}) {
+// processTarget scans the image the targets share once and records the results under each of their keys
+func processTarget(ctx context.Context, group []ScanTarget, ds *DockerSBOMService, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string, mu *sync.Mutex, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// Acquire the semaphore (blocks if full)
-	sem <- struct{}{}
-	defer func() {
-		// Release the semaphore
-		<-sem
-	}()
+	target, imageName := group[0], group[0].source()
+	keys := make([]string, 0, len(group))
+	for _, t := range group {
+		keys = append(keys, t.Key())
+	}
+	logger := ds.log(ctx).With("container", target.ContainerID, "image", target.Image)
+	if len(group) > 1 {
+		logger = logger.With("shared_with", keys[1:])
+	}
 
-	fmt.Printf("Generating SBOM for container %s (image: %s)\n", containerID, imageName)
-	sbom, err := ds.GenerateSBOM(ctx, imageName)
+	ctx, span := ds.tracer.Start(ctx, "scan.target", trace.WithAttributes(
+		attribute.String("image", target.Image),
+		attribute.String("container.id", target.ContainerID),
+	))
+	defer span.End()
+	if digest := target.Digest; digest != "" {
+		span.SetAttributes(attribute.String("image.digest", digest))
+	} else if span.IsRecording() && daemonImage(target.Image) {
+		// Resolving the digest costs an extra docker call, so only do it when the span is kept. Registry
+		// and file images are not in the daemon, their digest is only known when it came with the target.
+		if digest, err := ds.ImageDigest(ctx, target.Image); err == nil {
+			span.SetAttributes(attribute.String("image.digest", digest))
+		}
+	}
+
+	// failed records why the target is left out of the results
+	failed := func(message string, err error) {
+		recordError(span, err)
+		var cancelledErr *CancelledError
+		if errors.As(err, &cancelledErr) {
+			logger.Warn("Scan of target cancelled", "reason", cancelledErr.Error())
+			mu.Lock()
+			for _, key := range keys {
+				cancelled[key] = cancelledErr.Error()
+			}
+			mu.Unlock()
+			return
+		}
+		logger.Error(message, "error", err)
+	}
+
+	sbom, err := runStage(ctx, StageSyft, ds.syftSem, ds.limits.SyftTimeout, func(ctx context.Context) (string, error) {
+		logger.Info("Generating SBOM")
+		started := time.Now()
+		sbom, err := ds.GenerateSBOM(ctx, imageName)
+		ds.observeStage(StageSyft, started, err)
+		return sbom, err
+	})
 	if err != nil {
-		fmt.Printf("Error generating SBOM for %s: %v\n", imageName, err)
+		failed("Error generating SBOM", err)
 		return
 	}
-	sbomResults[containerID] = sbom
+	mu.Lock()
+	for _, key := range keys {
+		sbomResults[key] = sbom
+	}
+	mu.Unlock()
 
-	fmt.Printf("Scanning for CVEs for container %s (image: %s)\n", containerID, imageName)
-	cveReport, err := ds.ScanForCVEs(ctx, imageName)
+	cveReport, err := runStage(ctx, StageGrype, ds.grypeSem, ds.limits.GrypeTimeout, func(ctx context.Context) (string, error) {
+		logger.Info("Scanning for CVEs")
+		started := time.Now()
+		cveReport, err := ds.ScanForCVEs(ctx, imageName)
+		ds.observeStage(StageGrype, started, err)
+		return cveReport, err
+	})
 	if err != nil {
-		fmt.Printf("Error scanning for CVEs for %s: %v\n", imageName, err)
+		failed("Error scanning for CVEs", err)
 		return
 	}
 	// Parse the CVE report into CVEInfo structs
 	var cveList []tableprinter.CVEInfo
+	started := time.Now()
+	_, parseSpan := ds.tracer.Start(ctx, "parse")
 	err = parseCVEs(cveReport, &cveList)
+	ds.observeStage(StageParse, started, err)
+	parseSpan.SetAttributes(attribute.Int("cve.count", len(cveList)))
+	recordError(parseSpan, err)
+	parseSpan.End()
 	if err != nil {
-		fmt.Printf("Error parsing CVEs for %s: %v\n", imageName, err)
+		failed("Error parsing CVEs", err)
 		return
 	}
 
+	logger.Info("Scan finished", "cves", len(cveList))
+
 	// Store the parsed CVEs in the map
-	cveResults[containerID] = cveList
+	mu.Lock()
+	for _, key := range keys {
+		cveResults[key] = cveList
+	}
+	mu.Unlock()
 }
 
-// GenerateSBOMAndScanForCVEs generates SBOMs for all running containers and scans for vulnerabilities
-func (ds *DockerSBOMService) GenerateSBOMAndScanForCVEs(ctx context.Context) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
-	containers, err := ds.ListRunningContainers(ctx)
-	if err != nil {
-		return nil, nil, err
+// groupBySource groups the targets syft and grype read from the same source, e.g. containers of one image,
+// keeping the order of their first occurrence
+func groupBySource(targets []ScanTarget) [][]ScanTarget {
+	var groups [][]ScanTarget
+	index := make(map[string]int)
+	for _, target := range targets {
+		if i, ok := index[target.source()]; ok {
+			groups[i] = append(groups[i], target)
+			continue
+		}
+		index[target.source()] = len(groups)
+		groups = append(groups, []ScanTarget{target})
 	}
+	return groups
+}
 
-	// Maps to store the results
+// ScanTargets generates SBOMs for the given targets and scans them for vulnerabilities, running each stage
+// with the workers and timeout set by SetLimits. Targets sharing an image are scanned once. Results are keyed
+// by ScanTarget.Key; targets that fail are logged and left out, and those that timed out or were cancelled
+// with ctx are also returned with the reason.
+func (ds *DockerSBOMService) ScanTargets(ctx context.Context, targets []ScanTarget) (map[string]string, map[string][]tableprinter.CVEInfo, map[string]string) {
+	// Maps to store the results, guarded by mu since workers write concurrently
 	sbomResults := make(map[string]string)
 	cveResults := make(map[string][]tableprinter.CVEInfo)
-
-	// Channel to limit the number of concurrent goroutines
-	sem := make(chan struct{}, maxConcurrency)
+	cancelled := make(map[string]string)
+	var mu sync.Mutex
 
 	// WaitGroup to wait for all goroutines to finish
 	var wg sync.WaitGroup
 
-	// Loop through containers and process them concurrently
-	for _, containerInfo := range containers {
-		containerDetails := strings.Split(containerInfo, " ")
-		containerID := containerDetails[0]
-		imageName := containerDetails[1]
-
+	// Loop through targets and process them concurrently, the stages limit how many run at once
+	for _, group := range groupBySource(targets) {
 		// Increment the WaitGroup counter
 		wg.Add(1)
 
-		// Process each container in a separate goroutine
-		go processContainer(ctx, containerID, imageName, ds, sbomResults, cveResults, &wg, sem)
+		// Process each image in a separate goroutine
+		go processTarget(ctx, group, ds, sbomResults, cveResults, cancelled, &mu, &wg)
 	}
 
 	// Wait for all goroutines to complete
 	wg.Wait()
 
+	return sbomResults, cveResults, cancelled
+}
+
+// GenerateSBOMs generates SBOMs for the given targets without scanning them for vulnerabilities. Targets
+// sharing an image get the same SBOM. Results are keyed by ScanTarget.Key; targets that fail are logged and left out.
+func (ds *DockerSBOMService) GenerateSBOMs(ctx context.Context, targets []ScanTarget) map[string]string {
+	sbomResults := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, group := range groupBySource(targets) {
+		target := group[0]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sbom, err := runStage(ctx, StageSyft, ds.syftSem, ds.limits.SyftTimeout, func(ctx context.Context) (string, error) {
+				started := time.Now()
+				sbom, err := ds.GenerateSBOM(ctx, target.source())
+				ds.observeStage(StageSyft, started, err)
+				return sbom, err
+			})
+			if err != nil {
+				ds.log(ctx).Error("Error generating SBOM", "container", target.ContainerID, "image", target.Image, "error", err)
+				return
+			}
+			mu.Lock()
+			for _, t := range group {
+				sbomResults[t.Key()] = sbom
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return sbomResults
+}
+
+// GenerateSBOMAndScanForCVEs generates SBOMs for all running containers and scans for vulnerabilities
+func (ds *DockerSBOMService) GenerateSBOMAndScanForCVEs(ctx context.Context) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+	targets, err := ds.runningContainerTargets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sbomResults, cveResults, _ := ds.ScanTargets(ctx, targets)
 	return sbomResults, cveResults, nil
 }
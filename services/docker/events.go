@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dockerEvent is the subset of a `docker events --format '{{json .}}'` line the watcher needs
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// ScanHandler receives the results of every scan triggered by the event watcher, see ScanTargets
+type ScanHandler func(ctx context.Context, targets []ScanTarget, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string)
+
+// EventWatcher scans containers and images as soon as Docker reports that they started, were pulled or were tagged
+type EventWatcher struct {
+	ds          *DockerSBOMService
+	debounce    time.Duration // Quiet period to wait for before scanning a burst of events
+	maxDelay    time.Duration // Longest a target waits for the quiet period, no limit when zero
+	rescanAfter time.Duration // Image digests scanned more recently than this are skipped
+	handler     ScanHandler
+
+	mu      sync.Mutex
+	scanned map[string]time.Time // Image digest -> last scan time, within rescanAfter
+}
+
+// NewEventWatcher creates an EventWatcher that hands scan results to handler
+func NewEventWatcher(ds *DockerSBOMService, debounce, rescanAfter time.Duration, handler ScanHandler) *EventWatcher {
+	return &EventWatcher{
+		ds:          ds,
+		debounce:    debounce,
+		rescanAfter: rescanAfter,
+		handler:     handler,
+		scanned:     make(map[string]time.Time),
+	}
+}
+
+// SetMaxDelay caps how long a steady stream of events, e.g. from a crash-looping container, can postpone
+// the scan of the targets collected so far
+func (w *EventWatcher) SetMaxDelay(maxDelay time.Duration) {
+	w.maxDelay = maxDelay
+}
+
+// Watch follows the Docker events stream until ctx is cancelled or the stream ends.
+// Events are collected until no new event arrived for the debounce period, so that a
+// `docker compose up` starting many containers results in a single scan run, but no
+// longer than the maximum delay after the first of them.
+func (w *EventWatcher) Watch(ctx context.Context) error {
+	streamer, ok := w.ds.executor.(StreamingCommandExecutor)
+	if !ok {
+		return errors.New("command executor does not support streaming docker events")
+	}
+
+	stream, err := streamer.StreamCommand(ctx, "docker", "events", "--format", "{{json .}}",
+		"--filter", "type=container", "--filter", "type=image",
+		"--filter", "event=start", "--filter", "event=pull", "--filter", "event=tag")
+	if err != nil {
+		return fmt.Errorf("failed to follow docker events: %v", err)
+	}
+	defer stream.Close()
+
+	events := make(chan dockerEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(stream)
+		for scanner.Scan() {
+			var event dockerEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				w.ds.log(ctx).Warn("Skipping unreadable docker event", "error", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	pending := make(map[string]ScanTarget)
+	var firstPending time.Time
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				// Scan what was collected before the stream ended
+				w.scanPending(ctx, pending)
+				return errors.New("docker events stream closed")
+			}
+			target, ok := targetFromEvent(event)
+			if !ok {
+				continue
+			}
+			if len(pending) == 0 {
+				firstPending = time.Now()
+			}
+			pending[target.Key()] = target
+			delay := w.debounce
+			if w.maxDelay > 0 {
+				delay = max(0, min(delay, w.maxDelay-time.Since(firstPending)))
+			}
+			timer.Reset(delay)
+		case <-timer.C:
+			w.scanPending(ctx, pending)
+			pending = make(map[string]ScanTarget)
+		}
+	}
+}
+
+// targetFromEvent maps a container start, image pull or image tag event to a scan target
+func targetFromEvent(event dockerEvent) (ScanTarget, bool) {
+	switch {
+	case event.Type == "container" && event.Action == "start":
+		image := event.Actor.Attributes["image"]
+		if event.Actor.ID == "" || image == "" {
+			return ScanTarget{}, false
+		}
+		containerID := event.Actor.ID
+		if len(containerID) > 12 {
+			containerID = containerID[:12]
+		}
+		return ScanTarget{ContainerID: containerID, Image: image}, true
+	case event.Type == "image" && event.Action == "pull":
+		return ScanTarget{Image: event.Actor.ID}, event.Actor.ID != ""
+	case event.Type == "image" && event.Action == "tag":
+		// Tag events carry the image ID as actor and the new reference as name
+		name := event.Actor.Attributes["name"]
+		return ScanTarget{Image: name}, name != ""
+	}
+	return ScanTarget{}, false
+}
+
+// scanPending scans the collected targets, skipping image digests that were scanned recently
+func (w *EventWatcher) scanPending(ctx context.Context, pending map[string]ScanTarget) {
+	if len(pending) == 0 {
+		return
+	}
+
+	logger := w.ds.log(ctx)
+	var targets []ScanTarget
+	digests := make(map[string]string)
+	inBatch := make(map[string]bool)
+	for _, target := range pending {
+		digest, err := w.ds.ImageDigest(ctx, target.Image)
+		if err != nil {
+			logger.Warn("Failed to resolve image digest, scanning anyway", "image", target.Image, "error", err)
+		} else if inBatch[digest] || w.recentlyScanned(digest) {
+			logger.Info("Skipping target, image was scanned recently", "container", target.ContainerID, "image", target.Image, "digest", digest)
+			continue
+		}
+		// Like targets resolved from selectors, so containers are scanned by their image ID and traced with it
+		target.Digest = digest
+		targets = append(targets, target)
+		digests[target.Key()] = digest
+		inBatch[digest] = true
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	logger.Info("Docker events triggered a scan", "targets", len(targets))
+	sbomResults, cveResults, cancelled := w.ds.ScanTargets(ctx, targets)
+
+	w.mu.Lock()
+	now := time.Now()
+	// Digests scanned longer ago are due again anyway, forgetting them keeps a long-running watcher small
+	for digest, last := range w.scanned {
+		if now.Sub(last) >= w.rescanAfter {
+			delete(w.scanned, digest)
+		}
+	}
+	for key := range cveResults {
+		if digest := digests[key]; digest != "" {
+			w.scanned[digest] = now
+		}
+	}
+	w.mu.Unlock()
+
+	if w.handler != nil {
+		w.handler(ctx, targets, sbomResults, cveResults, cancelled)
+	}
+}
+
+func (w *EventWatcher) recentlyScanned(digest string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	last, ok := w.scanned[digest]
+	return ok && time.Since(last) < w.rescanAfter
+}
+
+// ImageDigest returns the content-addressable ID of a local image
+func (ds *DockerSBOMService) ImageDigest(ctx context.Context, image string) (string, error) {
+	result, err := ds.executor.ExecCommand(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return strings.TrimSpace(string(result.Stdout)), nil
+}
@@ -0,0 +1,161 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits bounds how many syft and grype processes run at the same time and how long each may take per image
+type Limits struct {
+	SyftWorkers  int
+	GrypeWorkers int
+	SyftTimeout  time.Duration // Zero leaves syft bounded by the run only
+	GrypeTimeout time.Duration // Zero leaves grype bounded by the run only
+}
+
+// Host describes the resources the scanner may share with the containers it scans
+type Host struct {
+	CPUs            int
+	AvailableMemory uint64 // Bytes, zero when unknown
+}
+
+// HostResources returns the CPUs usable by this process and the memory available on the host
+func HostResources() Host {
+	host := Host{CPUs: runtime.NumCPU()}
+	if available, err := availableMemory("/proc/meminfo"); err == nil {
+		host.AvailableMemory = available
+	}
+	return host
+}
+
+// Adapt lowers the workers to what the host can spare, leaving it half of its CPUs and of its available
+// memory, with workerMemory being what a single syft or grype process is assumed to need. Every stage keeps
+// at least one worker.
+func (l Limits) Adapt(host Host, workerMemory uint64) Limits {
+	cpuBudget := max(1, host.CPUs/2)
+	l.SyftWorkers = max(1, min(l.SyftWorkers, cpuBudget))
+	l.GrypeWorkers = max(1, min(l.GrypeWorkers, cpuBudget))
+
+	if host.AvailableMemory == 0 || workerMemory == 0 {
+		return l
+	}
+	// syft and grype run side by side, so their workers share the memory budget
+	memoryBudget := max(2, int(host.AvailableMemory/2/workerMemory))
+	for l.SyftWorkers+l.GrypeWorkers > memoryBudget {
+		if l.GrypeWorkers >= l.SyftWorkers {
+			l.GrypeWorkers--
+		} else {
+			l.SyftWorkers--
+		}
+	}
+	return l
+}
+
+// SetLimits sets the workers and timeouts of the syft and grype stages, to be called before scanning.
+// Workers below 1 keep the default.
+func (ds *DockerSBOMService) SetLimits(limits Limits) {
+	if limits.SyftWorkers < 1 {
+		limits.SyftWorkers = maxConcurrency
+	}
+	if limits.GrypeWorkers < 1 {
+		limits.GrypeWorkers = maxConcurrency
+	}
+	ds.limits = limits
+	ds.syftSem = make(chan struct{}, limits.SyftWorkers)
+	ds.grypeSem = make(chan struct{}, limits.GrypeWorkers)
+}
+
+// SetConcurrency sets how many syft and how many grype processes run at the same time; values below 1 keep
+// the default
+func (ds *DockerSBOMService) SetConcurrency(n int) {
+	limits := ds.limits
+	limits.SyftWorkers, limits.GrypeWorkers = n, n
+	ds.SetLimits(limits)
+}
+
+// Limits returns the workers and timeouts scans run with
+func (ds *DockerSBOMService) Limits() Limits {
+	return ds.limits
+}
+
+// runStage runs a stage of a target under the stage's timeout once one of its workers is free.
+// The error tells whether the stage failed, timed out or was cancelled along with the run.
+func runStage[T any](ctx context.Context, stage string, sem chan struct{}, timeout time.Duration, fn func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	select {
+	case sem <- struct{}{}:
+		defer func() { <-sem }()
+	case <-ctx.Done():
+		return zero, cancellation(ctx, stage)
+	}
+
+	stageCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := fn(stageCtx)
+	if err == nil {
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return zero, cancellation(ctx, stage)
+	}
+	if errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		return zero, &CancelledError{Stage: stage, Reason: fmt.Sprintf("timed out after %s", timeout)}
+	}
+	return zero, err
+}
+
+// CancelledError is returned for a target whose scan did not run to completion because a stage timed out or
+// the run was cancelled
+type CancelledError struct {
+	Stage  string
+	Reason string
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("%s %s", e.Stage, e.Reason)
+}
+
+func cancellation(ctx context.Context, stage string) *CancelledError {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &CancelledError{Stage: stage, Reason: "cancelled, the scan run timed out"}
+	}
+	return &CancelledError{Stage: stage, Reason: "cancelled"}
+}
+
+// availableMemory reads MemAvailable from a meminfo file
+func availableMemory(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "MemAvailable:")
+		if !ok {
+			continue
+		}
+		kilobytes, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse available memory: %w", err)
+		}
+		return kilobytes * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("available memory is not reported")
+}
@@ -1,30 +1,395 @@
 package docker
 
 import (
-	ntfyclient "AutomaticCVEResolver/services/ntfy"
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/links"
+	"AutomaticCVEResolver/services/logging"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/tracing"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// NotificationService is a service that sends notifications using NtfyClient
+// NotificationService sends notifications to the configured channels whose filters accept them
 type NotificationService struct {
-	ntfy ntfyclient.NtfyService
+	channels []notify.Channel
+	tracer   trace.Tracer
+	logger   *slog.Logger
+
+	apiURL string        // External URL of the HTTP API that report links and buttons point to
+	links  *links.Signer // Signs the links, so that they work without an API token
+
+	router *routing.Router
+	labels LabelFunc
+
+	quiet atomic.Pointer[alerting.Schedule] // Suppresses less severe notifications during quiet hours
+	now   func() time.Time
+}
+
+// LabelFunc looks up the labels of a container
+type LabelFunc func(ctx context.Context, containerID string) (map[string]string, error)
+
+// NewNotificationService creates a NotificationService delivering to the given channels
+func NewNotificationService(channels ...notify.Channel) *NotificationService {
+	s := &NotificationService{
+		channels: channels,
+		tracer:   otel.Tracer(tracing.TracerName),
+		logger:   slog.Default(),
+		now:      time.Now,
+	}
+	for _, channel := range channels {
+		if batcher, ok := channel.Notifier.(*notify.Batcher); ok {
+			batcher.SetFailureHandler(s.digestFailed(channel.Name))
+		}
+	}
+	return s
+}
+
+// SetLogger replaces the default logger; loggers carried by the context take precedence
+func (s *NotificationService) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+	for _, channel := range s.channels {
+		if batcher, ok := channel.Notifier.(*notify.Batcher); ok {
+			batcher.SetLogger(logger)
+		}
+	}
+}
+
+// SetTracerProvider makes the service create its spans with the given provider instead of the global one
+func (s *NotificationService) SetTracerProvider(provider trace.TracerProvider) {
+	s.tracer = provider.Tracer(tracing.TracerName)
+}
+
+// SetAPI makes CVE reports link to the run's report, acknowledgement and rescan endpoints on the HTTP API at
+// baseURL. Each link carries a token signed by signer that grants only its action on its run.
+func (s *NotificationService) SetAPI(baseURL string, signer *links.Signer) {
+	s.apiURL = strings.TrimSuffix(baseURL, "/")
+	s.links = signer
+}
+
+// SetRouter makes the service deliver each message to the channels its routing rules pick instead of
+// to every channel. labels is used to look up container labels when rules match on them.
+func (s *NotificationService) SetRouter(router *routing.Router, labels LabelFunc) {
+	s.router = router
+	s.labels = labels
+}
+
+// SetQuietHours makes the service drop notifications the schedule suppresses at the time they are sent.
+// It may be called again while notifications are sent, e.g. after the configuration changed.
+func (s *NotificationService) SetQuietHours(quiet *alerting.Schedule) {
+	s.quiet.Store(quiet)
+}
+
+// SetClock replaces time.Now when checking quiet hours, for tests
+func (s *NotificationService) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// ChannelNames returns the names of the configured channels
+func (s *NotificationService) ChannelNames() []string {
+	names := make([]string, 0, len(s.channels))
+	for _, channel := range s.channels {
+		names = append(names, channel.Name)
+	}
+	return names
+}
+
+// SendNotification sends a plain status message to all channels. It belongs to the run in ctx, if any,
+// so channels batching per run include it in the run's digest.
+func (s *NotificationService) SendNotification(ctx context.Context, message, title string) error {
+	runID := store.RunIDFromContext(ctx)
+	err := s.send(ctx, notify.Message{Title: title, Summary: message, RunID: runID}, nil)
+	if runID != "" {
+		return err
+	}
+	// Status messages outside of a run, e.g. about a failed reload, have no run end to be flushed at
+	return errors.Join(err, s.FlushRun(ctx, ""))
+}
+
+// FlushRun sends the digests of channels batching per run once the run's notifications are complete
+func (s *NotificationService) FlushRun(ctx context.Context, runID string) error {
+	var errs []error
+	for _, channel := range s.channels {
+		if flusher, ok := channel.Notifier.(notify.RunFlusher); ok {
+			if err := flusher.FlushRun(ctx, runID); err != nil {
+				errs = append(errs, fmt.Errorf("failed to send digest to %s: %v", channel.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Flush sends every message still held back by batching channels, e.g. before exiting
+func (s *NotificationService) Flush(ctx context.Context) error {
+	var errs []error
+	for _, channel := range s.channels {
+		if flusher, ok := channel.Notifier.(notify.Flusher); ok {
+			if err := flusher.Flush(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to send digest to %s: %v", channel.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendCVEReport notifies about the CVEs found in one target. The message summarises the findings
+// and carries the full report, which channels send or attach depending on their format.
+// runID, when known, links the notification to the run on the HTTP API.
+func (s *NotificationService) SendCVEReport(ctx context.Context, runID string, target ScanTarget, cveList []tableprinter.CVEInfo, report string) error {
+	msg := notify.Message{
+		Title:    fmt.Sprintf("CVE Scan Results for %s", target),
+		Summary:  notify.Summarize(cveList),
+		Report:   report,
+		Filename: "cve-report-" + fileNameChars.ReplaceAllString(target.Key(), "_") + ".txt",
+		CVEs:     cveList,
+		Severity: notify.WorstSeverity(cveList),
+		RunID:    runID,
+	}
+
+	if s.apiURL != "" && runID != "" {
+		s.linkRun(&msg)
+		msg.RescanURL = s.runURL(runID, "/rescan", links.ActionRescan)
+
+		rescan := map[string]string{"image": target.Image}
+		if target.ContainerID != "" {
+			rescan = map[string]string{"container": target.ContainerID}
+		}
+		rescanBody, err := json.Marshal(rescan)
+		if err != nil {
+			return err
+		}
+		msg.RescanBody = string(rescanBody)
+	}
+	return s.send(ctx, msg, &target)
+}
+
+// SendAlert reminds of the unacknowledged findings of a run, or escalates them. Escalations go to the
+// given channels, reminders through the routing rules like the run's reports.
+func (s *NotificationService) SendAlert(ctx context.Context, run store.Run, escalation bool, channels []string) error {
+	targets := run.AlertTargets()
+	var cveList []tableprinter.CVEInfo
+	for _, key := range targets {
+		cveList = append(cveList, run.CVEs[key]...)
+	}
+
+	prefix := "Unacknowledged"
+	if escalation {
+		prefix = "Escalated"
+	}
+	msg := notify.Message{
+		Title:    fmt.Sprintf("%s: %s CVEs in %s since %s", prefix, run.Alert.Severity, strings.Join(targets, ", "), run.Alert.RaisedAt.Local().Format("2006-01-02 15:04")),
+		Summary:  notify.Summarize(cveList),
+		CVEs:     cveList,
+		Severity: run.Alert.Severity,
+		RunID:    run.ID,
+	}
+	if s.apiURL != "" {
+		s.linkRun(&msg)
+	}
+
+	var err error
+	if channels == nil {
+		err = s.send(ctx, msg, nil)
+	} else {
+		_, err = s.deliver(ctx, s.channelsNamed(channels), msg)
+	}
+	// The run was flushed when its scan finished, channels batching per run would hold the alert until exiting
+	return errors.Join(err, s.FlushRun(ctx, run.ID))
+}
+
+// SendTest sends a test message to the named channels, or to all channels when none are named,
+// bypassing routing rules, severity filters and quiet hours, and sends it right away on batching channels
+func (s *NotificationService) SendTest(ctx context.Context, names []string) error {
+	channels := s.channels
+	if len(names) > 0 {
+		channels = s.channelsNamed(names)
+		for _, name := range names {
+			if !slices.Contains(s.ChannelNames(), name) {
+				return fmt.Errorf("unknown notification channel %q", name)
+			}
+		}
+	}
+
+	msg := notify.Message{
+		Title:   "Test notification",
+		Summary: "This is a test notification from the CVE scanner",
+	}
+	var errs []error
+	for _, channel := range channels {
+		err := channel.Notifier.Notify(ctx, msg)
+		if flusher, ok := channel.Notifier.(notify.Flusher); ok && err == nil {
+			err = flusher.Flush(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to send test notification to %s: %v", channel.Name, err))
+			continue
+		}
+		logging.FromContext(ctx, s.logger).Info("Test notification sent", "channel", channel.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// linkRun points the message's report and acknowledgement links at its run on the HTTP API
+func (s *NotificationService) linkRun(msg *notify.Message) {
+	msg.ReportURL = s.runURL(msg.RunID, "/report?format=html", links.ActionReport)
+	msg.AckURL = s.runURL(msg.RunID, "/ack", links.ActionAck)
+}
+
+// runURL returns the URL of an endpoint of the run, signed for action
+func (s *NotificationService) runURL(runID, endpoint, action string) string {
+	link := s.apiURL + "/api/v1/scans/" + url.PathEscape(runID) + endpoint
+	if s.links == nil {
+		return link
+	}
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+	return link + separator + "token=" + url.QueryEscape(s.links.Sign(runID, action))
 }
 
-// NewNotificationService creates a new NotificationService
-func NewNotificationService(ntfy ntfyclient.NtfyService) *NotificationService {
-	return &NotificationService{
-		ntfy: ntfy,
+// send delivers the message to the channels the router picks for the target, or to every channel
+// without a router, and returns all delivery errors. target is nil for status messages.
+func (s *NotificationService) send(ctx context.Context, msg notify.Message, target *ScanTarget) error {
+	if s.quiet.Load().Suppresses(msg.Severity, s.now()) {
+		logging.FromContext(ctx, s.logger).Info("Suppressed notification during quiet hours", "title", msg.Title, "severity", msg.Severity)
+		return nil
+	}
+	if s.router == nil {
+		_, err := s.deliver(ctx, s.channels, msg)
+		return err
+	}
+
+	subject := routing.Subject{Severity: msg.Severity}
+	if target != nil {
+		subject.Image = target.Image
+		subject.ContainerID = target.ContainerID
+		if target.ContainerID != "" && s.labels != nil && s.router.NeedsLabels() {
+			labels, err := s.labels(ctx, target.ContainerID)
+			if err != nil {
+				// Label rules then simply do not match and the findings take the default route
+				logging.FromContext(ctx, s.logger).Warn("Failed to look up container labels for routing", "container", target.ContainerID, "error", err)
+			}
+			subject.Labels = labels
+		}
+	}
+
+	var errs []error
+	for _, match := range s.router.Route(subject) {
+		routed := msg
+		routed.Topic = match.Topic
+		// Batching channels only send at flush time, their digests fall back on their own
+		routed.Fallback = match.Fallback
+
+		delivered, err := s.deliver(ctx, s.channelsNamed(match.Channels), routed)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if delivered == 0 && err != nil && len(match.Fallback) > 0 {
+			logging.FromContext(ctx, s.logger).Warn("Delivery failed on all channels, using fallback", "rule", match.Rule, "fallback", match.Fallback)
+			if _, err := s.deliver(ctx, s.channelsNamed(match.Fallback), routed); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// digestFailed sends a digest the batching channel failed to send to the fallback channels of the routes
+// its messages took, so that routing fallbacks also cover batched findings
+func (s *NotificationService) digestFailed(channel string) notify.FailureFunc {
+	return func(ctx context.Context, digest notify.Message, err error) error {
+		if len(digest.Fallback) == 0 {
+			return err
+		}
+
+		logging.FromContext(ctx, s.logger).Warn("Digest delivery failed, using fallback", "channel", channel, "fallback", digest.Fallback)
+		fallback := s.channelsNamed(digest.Fallback)
+		digest.Fallback = nil // Never fall back from the fallback
+		if _, fallbackErr := s.deliver(ctx, fallback, digest); fallbackErr != nil {
+			return errors.Join(err, fallbackErr)
+		}
+		return err
 	}
 }
 
-// SendNotification sends a notification using the Ntfy client
-func (s *NotificationService) SendNotification(message, title string) error {
-	// Send the message using NtfyClient's SendMessage method
-	resp, err := s.ntfy.SendMessage(message, title)
-	if err != nil {
-		return fmt.Errorf("failed to send notification: %v", err)
+// deliver sends the message to each accepting channel, carrying on past failures.
+// It returns how many channels received the message and all errors.
+func (s *NotificationService) deliver(ctx context.Context, channels []notify.Channel, msg notify.Message) (int, error) {
+	logger := logging.FromContext(ctx, s.logger)
+
+	delivered := 0
+	var errs []error
+	for _, channel := range channels {
+		if !channel.Accepts(msg) {
+			continue
+		}
+
+		spanCtx, span := s.tracer.Start(ctx, "notification", trace.WithAttributes(
+			attribute.String("notification.channel", channel.Name),
+			attribute.String("notification.title", msg.Title),
+			attribute.String("notification.severity", msg.Severity),
+			attribute.Int("notification.bytes", len(msg.Summary)+len(msg.Report)),
+		))
+		err := channel.Notifier.Notify(spanCtx, msg)
+		if err != nil {
+			recordError(span, err)
+			errs = append(errs, fmt.Errorf("failed to send notification to %s: %v", channel.Name, err))
+		} else {
+			delivered++
+			logger.Info("Notification sent successfully", "channel", channel.Name, "title", msg.Title)
+		}
+		span.End()
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// SuppressedNow reports whether quiet hours would drop a message of the given severity if it were sent now
+func (s *NotificationService) SuppressedNow(severity string) bool {
+	return s.quiet.Load().Suppresses(severity, s.now())
+}
+
+// AcceptingChannels splits the named channels into those whose min_severity lets a message of the given
+// severity through and those that would skip it, the way delivery does
+func (s *NotificationService) AcceptingChannels(names []string, severity string) (accepting, skipping []string) {
+	msg := notify.Message{Severity: strings.ToLower(severity)}
+	for _, channel := range s.channelsNamed(names) {
+		if channel.Accepts(msg) {
+			accepting = append(accepting, channel.Name)
+		} else {
+			skipping = append(skipping, channel.Name)
+		}
 	}
+	return accepting, skipping
+}
 
-	fmt.Printf("Notification sent successfully: %+v\n", resp)
-	return nil
+// channelsNamed returns the configured channels with the given names, in that order
+func (s *NotificationService) channelsNamed(names []string) []notify.Channel {
+	var channels []notify.Channel
+	for _, name := range names {
+		for _, channel := range s.channels {
+			if channel.Name == name {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
 }
+
+// fileNameChars matches the characters not allowed in attachment names, e.g. the slashes of image references
+var fileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
@@ -0,0 +1,26 @@
+package docker
+
+import "time"
+
+// Pipeline stages reported to a StageObserver
+const (
+	StageSyft  = "syft"
+	StageGrype = "grype"
+	StageParse = "parse"
+)
+
+// StageObserver is notified about the duration and outcome of every pipeline stage
+type StageObserver interface {
+	ObserveStage(stage string, duration time.Duration, err error)
+}
+
+// SetStageObserver registers an observer for pipeline stage timings, e.g. a metrics registry
+func (ds *DockerSBOMService) SetStageObserver(observer StageObserver) {
+	ds.observer = observer
+}
+
+func (ds *DockerSBOMService) observeStage(stage string, started time.Time, err error) {
+	if ds.observer != nil {
+		ds.observer.ObserveStage(stage, time.Since(started), err)
+	}
+}
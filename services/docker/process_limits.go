@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// I/O scheduling classes of ProcessLimits.IOClass
+const (
+	IOClassRealtime   = "realtime"
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// ProcessLimits restrict a subprocess so scans do not starve the services running next to them.
+// Zero values leave the corresponding resource unrestricted.
+type ProcessLimits struct {
+	Nice       int     // Niceness, 1 to 19 lower the CPU priority
+	IOClass    string  // I/O scheduling class, the default one when empty
+	IOPriority int     // 0 (highest) to 7 within the best-effort and realtime classes
+	CPUs       float64 // cgroup v2 CPU quota, e.g. 0.5 for half a CPU
+	Memory     int64   // cgroup v2 memory limit in bytes, the process is killed above it
+	MaxOutput  int64   // Bytes of output kept, the process is killed when it writes more
+}
+
+// Override returns the limits with the set fields of other replacing their own
+func (l ProcessLimits) Override(other ProcessLimits) ProcessLimits {
+	if other.Nice != 0 {
+		l.Nice = other.Nice
+	}
+	if other.IOClass != "" {
+		l.IOClass = other.IOClass
+		l.IOPriority = other.IOPriority
+	}
+	if other.CPUs != 0 {
+		l.CPUs = other.CPUs
+	}
+	if other.Memory != 0 {
+		l.Memory = other.Memory
+	}
+	if other.MaxOutput != 0 {
+		l.MaxOutput = other.MaxOutput
+	}
+	return l
+}
+
+// needsCgroup reports whether the limits can only be enforced by a cgroup
+func (l ProcessLimits) needsCgroup() bool {
+	return l.CPUs > 0 || l.Memory > 0
+}
+
+// cappedBuffer keeps the output of a process up to max bytes and cancels the process when it writes more.
+// The buffer is not embedded, its ReadFrom would let io.Copy bypass the limit.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	max      int64 // No limit when zero
+	cancel   context.CancelFunc
+	exceeded bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && int64(b.buf.Len()+len(p)) > b.max {
+		b.exceeded = true
+		b.cancel()
+		return 0, fmt.Errorf("output exceeds %d bytes", b.max)
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
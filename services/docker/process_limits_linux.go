@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// cgroupPeriod is the cpu.max period the CPU quota is expressed in, in microseconds
+const cgroupPeriod = 100000
+
+// cgroupSequence tells apart the cgroups created by this process
+var cgroupSequence atomic.Int64
+
+// ioClasses maps the I/O scheduling classes to their ioprio_set numbers
+var ioClasses = map[string]int{IOClassRealtime: 1, IOClassBestEffort: 2, IOClassIdle: 3}
+
+// prepareProcess makes cmd start in its own process group, so the limits and cancellation reach the processes
+// it spawns, and in a new cgroup under cgroupDir when CPU or memory are limited. The returned function
+// removes the cgroup once the process has exited.
+func prepareProcess(cmd *exec.Cmd, limits ProcessLimits, cgroupDir string) (func(), error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if !limits.needsCgroup() {
+		return func() {}, nil
+	}
+	if cgroupDir == "" {
+		return nil, errors.New("CPU and memory limits need a cgroup directory")
+	}
+
+	dir := filepath.Join(cgroupDir, fmt.Sprintf("%s-%d-%d", filepath.Base(cmd.Path), os.Getpid(), cgroupSequence.Add(1)))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	remove := func() { os.Remove(dir) }
+
+	if limits.CPUs > 0 {
+		quota := max(1000, int(limits.CPUs*cgroupPeriod))
+		if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod)); err != nil {
+			remove()
+			return nil, err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			remove()
+			return nil, err
+		}
+	}
+
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return func() {
+		syscall.Close(fd)
+		remove()
+	}, nil
+}
+
+// reniceProcess applies the niceness and I/O priority to every thread of the started process group
+func reniceProcess(pid int, limits ProcessLimits) error {
+	if limits.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, pid, limits.Nice); err != nil {
+			return fmt.Errorf("failed to set niceness: %w", err)
+		}
+	}
+	if limits.IOClass != "" {
+		class, ok := ioClasses[limits.IOClass]
+		if !ok {
+			return fmt.Errorf("unknown I/O class %q", limits.IOClass)
+		}
+		const whoProcessGroup, classShift = 2, 13
+		priority := class<<classShift | limits.IOPriority
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, whoProcessGroup, uintptr(pid), uintptr(priority)); errno != 0 {
+			return fmt.Errorf("failed to set I/O priority: %w", errno)
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("failed to set %s, is the controller enabled in the cgroup directory: %w", name, err)
+	}
+	return nil
+}
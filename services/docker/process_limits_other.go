@@ -0,0 +1,32 @@
+//go:build !linux
+
+package docker
+
+import (
+	"errors"
+	"log/slog"
+	"os/exec"
+	"sync"
+)
+
+// prepareProcess only supports the output limit outside of Linux
+func prepareProcess(cmd *exec.Cmd, limits ProcessLimits, cgroupDir string) (func(), error) {
+	if limits.needsCgroup() {
+		return nil, errors.New("CPU and memory limits are only supported on Linux")
+	}
+	return func() {}, nil
+}
+
+// priorityWarning logs that the priorities are ignored only once
+var priorityWarning sync.Once
+
+// reniceProcess ignores the process priorities outside of Linux, which are set by default, and warns about it once
+func reniceProcess(pid int, limits ProcessLimits) error {
+	if limits.Nice != 0 || limits.IOClass != "" {
+		priorityWarning.Do(func() {
+			slog.Default().Warn("Process priorities are only supported on Linux, syft and grype run at normal priority",
+				"nice", limits.Nice, "io_class", limits.IOClass)
+		})
+	}
+	return nil
+}
@@ -1,20 +1,114 @@
 package docker
 
 import (
-	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"time"
 )
 
 // RealCommandExecutor is the actual implementation of CommandExecutor that uses exec.Command
-type RealCommandExecutor struct{}
+type RealCommandExecutor struct {
+	Limits    map[string]ProcessLimits // By command name, e.g. syft; other commands run without limits
+	CgroupDir string                   // Delegated cgroup v2 directory the CPU and memory limited commands get a cgroup in
+	Env       []string                 // Added to the environment of every command, e.g. SYFT_REGISTRY_INSECURE_USE_HTTP=true
+}
+
+// Ensure RealCommandExecutor can also stream command output
+var _ StreamingCommandExecutor = &RealCommandExecutor{}
+
+// ExecCommand executes the given command under its limits and returns what it wrote to stdout and stderr
+func (e *RealCommandExecutor) ExecCommand(ctx context.Context, command string, args ...string) (ExecResult, error) {
+	limits, limited := e.Limits[command]
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := e.command(ctx, command, args...)
+	stdout := &cappedBuffer{max: limits.MaxOutput, cancel: cancel}
+	stderr := &cappedBuffer{max: limits.MaxOutput, cancel: cancel}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	started := time.Now()
+	result := func(err error) (ExecResult, error) {
+		result := ExecResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: -1, Duration: time.Since(started)}
+		if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() >= 0 {
+			result.ExitCode = cmd.ProcessState.ExitCode()
+		}
+		if stdout.exceeded || stderr.exceeded {
+			err = fmt.Errorf("stopped, its output exceeded %d bytes", limits.MaxOutput)
+			result.ExitCode = -1
+		}
+		if err == nil {
+			return result, nil
+		}
+		return result, &ExecError{
+			Command:  command,
+			Args:     args,
+			ExitCode: result.ExitCode,
+			Stderr:   result.Stderr,
+			Duration: result.Duration,
+			Err:      err,
+		}
+	}
+
+	if !limited {
+		return result(cmd.Run())
+	}
+
+	cleanup, err := prepareProcess(cmd, limits, e.CgroupDir)
+	if err != nil {
+		return result(fmt.Errorf("failed to limit the process: %w", err))
+	}
+	defer cleanup()
+	if err := cmd.Start(); err != nil {
+		return result(err)
+	}
+	if err := reniceProcess(cmd.Process.Pid, limits); err != nil {
+		cancel()
+		cmd.Wait()
+		return result(fmt.Errorf("failed to limit the process: %w", err))
+	}
+	return result(cmd.Wait())
+}
+
+// StreamCommand starts the given command and returns a reader over its stdout
+func (e *RealCommandExecutor) StreamCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := e.command(ctx, command, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return &commandStream{ReadCloser: stdout, cmd: cmd, cancel: cancel}, nil
+}
 
-// ExecCommand executes the given command and returns its output or an error
-func (e *RealCommandExecutor) ExecCommand(ctx context.Context, command string, args ...string) ([]byte, error) {
+func (e *RealCommandExecutor) command(ctx context.Context, command string, args ...string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, command, args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err := cmd.Run()
-	return out.Bytes(), err
+	if len(e.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Env...)
+	}
+	return cmd
+}
+
+// commandStream kills and reaps the command when the stream is closed
+type commandStream struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+}
+
+func (s *commandStream) Close() error {
+	s.cancel()
+	s.ReadCloser.Close()
+	// The command was killed on purpose, so its exit status carries no information
+	s.cmd.Wait()
+	return nil
 }
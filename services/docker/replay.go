@@ -0,0 +1,257 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exchange is a recorded command invocation together with its result
+type Exchange struct {
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Stdout     string   `json:"stdout"`
+	Stderr     string   `json:"stderr,omitempty"`
+	ExitCode   int      `json:"exit_code"`
+	DurationMS int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"` // Why the command failed, set whenever it did
+}
+
+// Fixture is the content of a file recorded by RecordingExecutor and served by ReplayExecutor
+type Fixture struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+func (e Exchange) commandLine() string {
+	return strings.Join(append([]string{e.Command}, e.Args...), " ")
+}
+
+// RecordingExecutor runs commands with another executor and records every invocation and its result,
+// to be saved as a fixture for ReplayExecutor. Streamed commands are passed through without recording.
+type RecordingExecutor struct {
+	executor CommandExecutor
+	path     string
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecordingExecutor records the commands run by executor into the fixture file at path
+func NewRecordingExecutor(executor CommandExecutor, path string) *RecordingExecutor {
+	return &RecordingExecutor{executor: executor, path: path}
+}
+
+// ExecCommand runs the command and records it
+func (r *RecordingExecutor) ExecCommand(ctx context.Context, command string, args ...string) (ExecResult, error) {
+	result, err := r.executor.ExecCommand(ctx, command, args...)
+
+	exchange := Exchange{
+		Command:    command,
+		Args:       slices.Clone(args),
+		Stdout:     string(result.Stdout),
+		Stderr:     string(result.Stderr),
+		ExitCode:   result.ExitCode,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+		var execErr *ExecError
+		if errors.As(err, &execErr) && execErr.Err != nil {
+			exchange.Error = execErr.Err.Error()
+		}
+	}
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, exchange)
+	r.mu.Unlock()
+	return result, err
+}
+
+// StreamCommand passes streamed commands through to the executor when it supports them
+func (r *RecordingExecutor) StreamCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	streamer, ok := r.executor.(StreamingCommandExecutor)
+	if !ok {
+		return nil, errors.New("command executor does not support streaming")
+	}
+	return streamer.StreamCommand(ctx, command, args...)
+}
+
+// Save writes the invocations recorded so far to the fixture file
+func (r *RecordingExecutor) Save() error {
+	r.mu.Lock()
+	fixture := Fixture{Exchanges: slices.Clone(r.exchanges)}
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode recorded commands: %w", err)
+	}
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("failed to save recorded commands: %w", err)
+		}
+	}
+	// Write to a temporary file first so an interrupted save never leaves a truncated fixture behind
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to save recorded commands: %w", err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// ReplayExecutor serves the results of recorded invocations instead of running commands. Calls are matched
+// by command and arguments in any order, so concurrent scans replay deterministically; a command recorded
+// several times is served in recording order. Calls that were not recorded fail with a diff against the
+// closest recorded invocation.
+type ReplayExecutor struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+}
+
+// NewReplayExecutor serves the given recorded invocations
+func NewReplayExecutor(fixture Fixture) *ReplayExecutor {
+	return &ReplayExecutor{exchanges: fixture.Exchanges, used: make([]bool, len(fixture.Exchanges))}
+}
+
+// LoadReplayExecutor serves the invocations recorded in a fixture file
+func LoadReplayExecutor(path string) (*ReplayExecutor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recorded commands: %w", err)
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse recorded commands %s: %w", path, err)
+	}
+	return NewReplayExecutor(fixture), nil
+}
+
+// ExecCommand returns the recorded result of the command
+func (r *ReplayExecutor) ExecCommand(ctx context.Context, command string, args ...string) (ExecResult, error) {
+	// Like a process killed with its context
+	if err := ctx.Err(); err != nil {
+		return ExecResult{ExitCode: -1}, &ExecError{Command: command, Args: args, ExitCode: -1, Err: err}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	called := Exchange{Command: command, Args: args}
+	recorded := 0
+	for i, exchange := range r.exchanges {
+		if exchange.Command != command || !slices.Equal(exchange.Args, args) {
+			continue
+		}
+		recorded++
+		if r.used[i] {
+			continue
+		}
+		r.used[i] = true
+		return exchange.result()
+	}
+
+	var err error
+	if recorded > 0 {
+		err = fmt.Errorf("replay: %q was recorded %d times and is called once more", called.commandLine(), recorded)
+	} else {
+		err = r.mismatch(called)
+	}
+	return ExecResult{ExitCode: -1}, &ExecError{Command: command, Args: args, ExitCode: -1, Err: err}
+}
+
+// Unused returns the recorded invocations that were not replayed, e.g. to check that a test ran the whole
+// recorded session
+func (r *ReplayExecutor) Unused() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Exchange
+	for i, exchange := range r.exchanges {
+		if !r.used[i] {
+			unused = append(unused, exchange)
+		}
+	}
+	return unused
+}
+
+func (e Exchange) result() (ExecResult, error) {
+	result := ExecResult{
+		Stdout:   []byte(e.Stdout),
+		Stderr:   []byte(e.Stderr),
+		ExitCode: e.ExitCode,
+		Duration: time.Duration(e.DurationMS) * time.Millisecond,
+	}
+	if e.Error == "" {
+		return result, nil
+	}
+	return result, &ExecError{
+		Command:  e.Command,
+		Args:     e.Args,
+		ExitCode: e.ExitCode,
+		Stderr:   result.Stderr,
+		Duration: result.Duration,
+		Err:      errors.New(e.Error),
+	}
+}
+
+// mismatch explains how a call differs from the closest recorded invocation
+func (r *ReplayExecutor) mismatch(called Exchange) error {
+	closest, differences, shared := -1, 0, 0
+	for i, exchange := range r.exchanges {
+		if exchange.Command != called.Command {
+			continue
+		}
+		d, s := compareArgs(exchange.Args, called.Args)
+		if closest < 0 || d < differences || d == differences && s > shared {
+			closest, differences, shared = i, d, s
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "replay: no recorded invocation of %s\n", called.Command)
+	fmt.Fprintf(&b, "  called:   %s\n", called.commandLine())
+	if closest < 0 {
+		fmt.Fprintf(&b, "  %s was never recorded", called.Command)
+		return errors.New(b.String())
+	}
+
+	recorded := r.exchanges[closest]
+	fmt.Fprintf(&b, "  recorded: %s", recorded.commandLine())
+	for i := 0; i < max(len(recorded.Args), len(called.Args)); i++ {
+		switch {
+		case i >= len(called.Args):
+			fmt.Fprintf(&b, "\n  - arg %d: %q", i+1, recorded.Args[i])
+		case i >= len(recorded.Args):
+			fmt.Fprintf(&b, "\n  + arg %d: %q", i+1, called.Args[i])
+		case recorded.Args[i] != called.Args[i]:
+			fmt.Fprintf(&b, "\n  - arg %d: %q\n  + arg %d: %q", i+1, recorded.Args[i], i+1, called.Args[i])
+		}
+	}
+	return errors.New(b.String())
+}
+
+// compareArgs counts the positions at which two argument lists differ and, to break ties, the characters
+// the differing arguments start with in common
+func compareArgs(a, b []string) (differences, shared int) {
+	differences = max(len(a), len(b)) - min(len(a), len(b))
+	for i := 0; i < min(len(a), len(b)); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		differences++
+		prefix := 0
+		for prefix < len(a[i]) && prefix < len(b[i]) && a[i][prefix] == b[i][prefix] {
+			prefix++
+		}
+		shared += prefix
+	}
+	return differences, shared
+}
@@ -0,0 +1,435 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/registry"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Target selectors accepted by ResolveTargets. Several can be combined with commas, e.g. running,dangling,
+// and image targets are then left out when another target already covers their digest.
+const (
+	TargetRunning   = "running"    // All running containers
+	TargetAll       = "all"        // All containers, including stopped ones
+	TargetImages    = "images"     // All local images
+	TargetDangling  = "dangling"   // Local images without a tag
+	TargetFilter    = "filter:"    // Local images matching a reference filter, e.g. filter:nginx:1.*
+	TargetContainer = "container:" // A single container by ID or name, e.g. container:web-1
+	TargetImage     = "image:"     // A single image reference, e.g. image:nginx:1.27
+	TargetRegistry  = "registry:"  // Images read from a registry without pulling them, e.g. registry:registry.local/app:1.4.*
+
+	// Images in files, never loaded into the daemon. The path may be a glob, e.g. docker-archive:build/*.tar
+	TargetDockerArchive = "docker-archive:" // Tarballs written by docker save
+	TargetOCIArchive    = "oci-archive:"    // Tarballs of an OCI image layout
+	TargetOCIDir        = "oci-dir:"        // OCI image layout directories
+)
+
+// fileSources are the selectors of images in files, passed on to syft and grype as sources of the same name
+var fileSources = []string{TargetDockerArchive, TargetOCIArchive, TargetOCIDir}
+
+// ScanTarget is a single container or image to scan
+type ScanTarget struct {
+	ContainerID string // Empty when the image is scanned on its own
+	Image       string
+	Digest      string // ID of the image, empty until it is looked up
+	Stopped     bool   // The container exists but is not running, e.g. exited or paused, so nothing can be run in it
+}
+
+// source is what syft and grype read. Containers are read by the ID of the image they were created from,
+// once it is looked up, as their image's tag may point to a newer image by now.
+func (t ScanTarget) source() string {
+	if t.ContainerID != "" && t.Digest != "" && daemonImage(t.Image) {
+		return t.Digest
+	}
+	return t.Image
+}
+
+// Key identifies the target in scan results: the container ID, or the image for image-only targets
+func (t ScanTarget) Key() string {
+	if t.ContainerID != "" {
+		return t.ContainerID
+	}
+	return t.Image
+}
+
+func (t ScanTarget) String() string {
+	if t.ContainerID != "" {
+		return "container " + t.ContainerID
+	}
+	return "image " + t.Image
+}
+
+// ValidateTarget checks the syntax of a target selector without resolving it
+func ValidateTarget(selector string) error {
+	if selector == "" {
+		return nil
+	}
+	for _, part := range strings.Split(selector, ",") {
+		if err := validateTarget(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTarget(selector string) error {
+	// References are passed on to docker, syft and grype as arguments and must not look like flags
+	if _, ref, found := strings.Cut(selector, ":"); found && strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid reference in target %q", selector)
+	}
+
+	switch {
+	case selector == "":
+		return errors.New("empty target in combined selector")
+	case selector == TargetRunning, selector == TargetAll, selector == TargetImages, selector == TargetDangling:
+		return nil
+	case strings.HasPrefix(selector, TargetFilter):
+		if selector == TargetFilter {
+			return fmt.Errorf("reference filter is missing in target %q", selector)
+		}
+		return nil
+	case strings.HasPrefix(selector, TargetContainer):
+		if selector == TargetContainer {
+			return fmt.Errorf("container is missing in target %q", selector)
+		}
+		return nil
+	case strings.HasPrefix(selector, TargetImage):
+		if selector == TargetImage {
+			return fmt.Errorf("image reference is missing in target %q", selector)
+		}
+		return nil
+	case slices.ContainsFunc(fileSources, func(source string) bool { return strings.HasPrefix(selector, source) }):
+		source, pattern, _ := strings.Cut(selector, ":")
+		if pattern == "" {
+			return fmt.Errorf("path is missing in target %q", selector)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid %s path pattern in target %q: %v", source, selector, err)
+		}
+		return nil
+	case strings.HasPrefix(selector, TargetRegistry):
+		ref, err := registry.ParseReference(strings.TrimPrefix(selector, TargetRegistry))
+		if err != nil {
+			return fmt.Errorf("invalid target %q: %w", selector, err)
+		}
+		if _, err := registry.MatchTags(nil, ref.Tag); err != nil {
+			return fmt.Errorf("invalid target %q: %w", selector, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown scan target %q", selector)
+}
+
+// ResolveTargets turns a target selector into the list of containers or images to scan.
+// An empty selector means all running containers.
+func (ds *DockerSBOMService) ResolveTargets(ctx context.Context, selector string) ([]ScanTarget, error) {
+	if err := ValidateTarget(selector); err != nil {
+		return nil, err
+	}
+	if selector == "" {
+		selector = TargetRunning
+	}
+
+	var targets []ScanTarget
+	for _, part := range strings.Split(selector, ",") {
+		resolved, err := ds.resolveTarget(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, resolved...)
+	}
+	return ds.deduplicate(ctx, targets)
+}
+
+func (ds *DockerSBOMService) resolveTarget(ctx context.Context, selector string) ([]ScanTarget, error) {
+	switch {
+	case selector == TargetRunning:
+		return ds.runningContainerTargets(ctx)
+	case selector == TargetAll:
+		result, err := ds.executor.ExecCommand(ctx, "docker", "ps", "--all", "--format", "{{.ID}} {{.Image}} {{.State}}")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers: %w", err)
+		}
+		// Stopped containers keep the image they were created from, even after their tag moved on
+		targets := containerTargets(strings.Split(strings.TrimSpace(string(result.Stdout)), "\n"))
+		if err := ds.containerDigests(ctx, targets); err != nil {
+			return nil, err
+		}
+		return targets, nil
+	case selector == TargetImages:
+		return ds.imageTargets(ctx)
+	case selector == TargetDangling:
+		return ds.imageTargets(ctx, "dangling=true")
+	case strings.HasPrefix(selector, TargetFilter):
+		return ds.imageTargets(ctx, "reference="+strings.TrimPrefix(selector, TargetFilter))
+	case strings.HasPrefix(selector, TargetContainer):
+		target, err := ds.inspectContainer(ctx, strings.TrimPrefix(selector, TargetContainer))
+		if err != nil {
+			return nil, err
+		}
+		return []ScanTarget{target}, nil
+	case strings.HasPrefix(selector, TargetImage):
+		return []ScanTarget{{Image: strings.TrimPrefix(selector, TargetImage)}}, nil
+	case strings.HasPrefix(selector, TargetRegistry):
+		return ds.registryTargets(ctx, strings.TrimPrefix(selector, TargetRegistry))
+	case slices.ContainsFunc(fileSources, func(source string) bool { return strings.HasPrefix(selector, source) }):
+		source, pattern, _ := strings.Cut(selector, ":")
+		return fileTargets(source, pattern)
+	}
+	return nil, fmt.Errorf("unknown scan target %q", selector)
+}
+
+func (ds *DockerSBOMService) runningContainerTargets(ctx context.Context) ([]ScanTarget, error) {
+	containers, err := ds.ListRunningContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return containerTargets(containers), nil
+}
+
+// containerTargets parses the "ID image" lines printed by docker ps, followed by the container's state
+// when stopped containers are listed too
+func containerTargets(containers []string) []ScanTarget {
+	targets := make([]ScanTarget, 0, len(containers))
+	for _, containerInfo := range containers {
+		containerDetails := strings.Fields(containerInfo)
+		if len(containerDetails) < 2 {
+			continue
+		}
+		targets = append(targets, ScanTarget{
+			ContainerID: containerDetails[0],
+			Image:       containerDetails[1],
+			Stopped:     len(containerDetails) > 2 && containerDetails[2] != "running",
+		})
+	}
+	return targets
+}
+
+// imageTargets lists the local images matching the docker images filters, once per image ID even when the
+// image has several tags. Untagged images are referred to by their ID.
+func (ds *DockerSBOMService) imageTargets(ctx context.Context, filters ...string) ([]ScanTarget, error) {
+	args := []string{"images", "--no-trunc"}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	args = append(args, "--format", "{{.ID}} {{.Repository}}:{{.Tag}}")
+	result, err := ds.executor.ExecCommand(ctx, "docker", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var targets []ScanTarget
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(result.Stdout)), "\n") {
+		details := strings.Fields(line)
+		if len(details) < 2 || seen[details[0]] {
+			continue
+		}
+		seen[details[0]] = true
+		image := details[1]
+		if strings.Contains(image, "<none>") {
+			image = details[0]
+		}
+		targets = append(targets, ScanTarget{Image: image, Digest: details[0]})
+	}
+	return targets, nil
+}
+
+// registryTargets resolves the tags a registry reference selects. syft and grype read the images straight
+// from the registry, with the credentials of docker login, so they are never pulled into the daemon.
+func (ds *DockerSBOMService) registryTargets(ctx context.Context, reference string) ([]ScanTarget, error) {
+	ref, err := registry.ParseReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	images, err := ds.registry.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]ScanTarget, 0, len(images))
+	for _, image := range images {
+		targets = append(targets, ScanTarget{Image: TargetRegistry + image.Reference.String(), Digest: image.Digest})
+	}
+	return targets, nil
+}
+
+// fileTargets expands the path pattern of a file source. The paths are made absolute, so reports name them
+// unambiguously and scans started from elsewhere, e.g. a rescan through the API, find them.
+func fileTargets(source, pattern string) ([]ScanTarget, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s path pattern %q: %v", source, pattern, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no %s matches %s", source, pattern)
+	}
+
+	targets := make([]ScanTarget, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s %s: %w", source, path, err)
+		}
+		// oci-dir is a directory holding index.json, the archives are single files
+		if isDir := source+":" == TargetOCIDir; info.IsDir() != isDir {
+			if isDir {
+				return nil, fmt.Errorf("%s expects a directory, %s is a file", source, path)
+			}
+			return nil, fmt.Errorf("%s expects a file, %s is a directory", source, path)
+		}
+		if absolute, err := filepath.Abs(path); err == nil {
+			path = absolute
+		}
+		targets = append(targets, ScanTarget{Image: source + ":" + path})
+	}
+	return targets, nil
+}
+
+// daemonImage reports whether syft and grype read the image from the docker daemon, rather than from a
+// registry or a file, so its digest can be looked up locally
+func daemonImage(image string) bool {
+	for _, source := range append([]string{TargetRegistry}, fileSources...) {
+		if strings.HasPrefix(image, source) {
+			return false
+		}
+	}
+	return true
+}
+
+// deduplicate drops containers listed twice, e.g. by running and all, and image targets whose digest another
+// target already covers. Containers are all kept, each is reported on its own, but ScanTargets scans those
+// sharing an image once. The digests of containers are only looked up when there is another target to
+// compare them with.
+func (ds *DockerSBOMService) deduplicate(ctx context.Context, targets []ScanTarget) ([]ScanTarget, error) {
+	var containers, images []ScanTarget
+	seenContainers := make(map[string]int)
+	for _, target := range targets {
+		if target.ContainerID == "" {
+			images = append(images, target)
+			continue
+		}
+		i, seen := seenContainers[target.ContainerID]
+		if !seen {
+			seenContainers[target.ContainerID] = len(containers)
+			containers = append(containers, target)
+		} else if containers[i].Digest == "" {
+			// Listed by running without its digest and by all with it
+			containers[i].Digest = target.Digest
+		}
+	}
+	if len(containers)+len(images) <= 1 {
+		return append(containers, images...), nil
+	}
+
+	if err := ds.containerDigests(ctx, containers); err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return containers, nil
+	}
+	covered := make(map[string]bool)
+	for _, target := range containers {
+		covered[target.Digest] = true
+	}
+	seenImages := make(map[string]bool)
+	for _, target := range images {
+		if target.Digest == "" && daemonImage(target.Image) {
+			// Single image references need not be local, the image is then scanned without comparing it
+			if digest, err := ds.ImageDigest(ctx, target.Image); err == nil {
+				target.Digest = digest
+			}
+		}
+		if target.Digest != "" && covered[target.Digest] || seenImages[target.Image] {
+			ds.log(ctx).Debug("Skipping image, it is already a scan target", "image", target.Image, "digest", target.Digest)
+			continue
+		}
+		if target.Digest != "" {
+			covered[target.Digest] = true
+		}
+		seenImages[target.Image] = true
+		containers = append(containers, target)
+	}
+	return containers, nil
+}
+
+// containerDigests sets the digests of the containers' images that are not known yet with a single docker inspect
+func (ds *DockerSBOMService) containerDigests(ctx context.Context, containers []ScanTarget) error {
+	args := []string{"inspect", "--format", "{{.Id}} {{.Image}}"}
+	for _, target := range containers {
+		if target.Digest == "" {
+			args = append(args, target.ContainerID)
+		}
+	}
+	if len(args) == 3 {
+		return nil
+	}
+	result, err := ds.executor.ExecCommand(ctx, "docker", args...)
+	if err != nil {
+		return fmt.Errorf("failed to inspect containers: %w", err)
+	}
+
+	digests := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(result.Stdout)), "\n") {
+		if details := strings.Fields(line); len(details) == 2 {
+			digests[details[0]] = details[1]
+		}
+	}
+	for i, target := range containers {
+		if target.Digest != "" {
+			continue
+		}
+		// docker inspect prints full IDs, docker ps short ones
+		for id, digest := range digests {
+			if strings.HasPrefix(id, target.ContainerID) {
+				containers[i].Digest = digest
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// inspectContainer looks up the short ID and image of a single container
+func (ds *DockerSBOMService) inspectContainer(ctx context.Context, container string) (ScanTarget, error) {
+	if container == "" {
+		return ScanTarget{}, fmt.Errorf("container is missing in target selector")
+	}
+
+	result, err := ds.executor.ExecCommand(ctx, "docker", "inspect", "--format", "{{.Id}} {{.Config.Image}} {{.State.Status}}", container)
+	if err != nil {
+		return ScanTarget{}, fmt.Errorf("failed to inspect container %s: %w", container, err)
+	}
+
+	details := strings.Fields(string(result.Stdout))
+	if len(details) < 2 {
+		return ScanTarget{}, fmt.Errorf("unexpected inspect output for container %s: %q", container, result.Stdout)
+	}
+
+	// Match the short IDs printed by docker ps
+	containerID := details[0]
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	return ScanTarget{ContainerID: containerID, Image: details[1], Stopped: len(details) > 2 && details[2] != "running"}, nil
+}
+
+// ContainerLabels returns the labels of a container, including those docker compose sets
+func (ds *DockerSBOMService) ContainerLabels(ctx context.Context, container string) (map[string]string, error) {
+	result, err := ds.executor.ExecCommand(ctx, "docker", "inspect", "--format", "{{json .Config.Labels}}", container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", container, err)
+	}
+
+	var labels map[string]string
+	if err := json.Unmarshal(result.Stdout, &labels); err != nil {
+		return nil, fmt.Errorf("unexpected labels of container %s: %v", container, err)
+	}
+	return labels, nil
+}
@@ -0,0 +1,37 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/tracing"
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetTracerProvider makes the service create its spans with the given provider instead of the global one
+func (ds *DockerSBOMService) SetTracerProvider(provider trace.TracerProvider) {
+	ds.tracer = provider.Tracer(tracing.TracerName)
+}
+
+// execInSpan runs a command inside a span that records its exit code and output size
+func (ds *DockerSBOMService) execInSpan(ctx context.Context, spanName string, attrs []attribute.KeyValue, command string, args ...string) ([]byte, error) {
+	ctx, span := ds.tracer.Start(ctx, spanName, trace.WithAttributes(attrs...))
+	defer span.End()
+
+	result, err := ds.executor.ExecCommand(ctx, command, args...)
+	span.SetAttributes(
+		attribute.Int("process.exit_code", result.ExitCode),
+		attribute.Int("output.bytes", len(result.Stdout)),
+		attribute.Int("stderr.bytes", len(result.Stderr)),
+	)
+	recordError(span, err)
+	return result.Stdout, err
+}
+
+// recordError marks the span as failed when err is set
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
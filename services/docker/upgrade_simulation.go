@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/version"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Package managers supported by the upgrade simulation
+const (
+	PackageManagerAPK = "apk"
+	PackageManagerAPT = "apt-get"
+)
+
+var (
+	// (1/3) Upgrading libcrypto3 (3.0.8-r0 -> 3.0.13-r0)
+	apkUpgradeLine = regexp.MustCompile(`^\(\d+/\d+\) (?:Upgrading|Replacing) (\S+) \((\S+) -> (\S+)\)`)
+	// Inst libssl3 [3.0.11-1~deb12u1] (3.0.11-1~deb12u2 Debian-Security:12/stable-security [amd64])
+	aptUpgradeLine = regexp.MustCompile(`^Inst (\S+) \[(\S+)\] \((\S+)`)
+)
+
+// PackageUpgrade describes a single package change reported by the package manager
+type PackageUpgrade struct {
+	Package          string
+	CurrentVersion   string
+	CandidateVersion string
+}
+
+// UpgradeSimulation holds the outcome of a simulated in-place upgrade for a container
+type UpgradeSimulation struct {
+	ContainerID    string
+	PackageManager string
+	Upgrades       []PackageUpgrade
+	ClosedCVEs     []tableprinter.CVEInfo // CVEs the upgrade would fix
+	RemainingCVEs  []tableprinter.CVEInfo // CVEs still present after the upgrade
+}
+
+// DetectPackageManager finds out which supported package manager is available inside a container
+func (ds *DockerSBOMService) DetectPackageManager(ctx context.Context, containerID string) (string, error) {
+	result, err := ds.executor.ExecCommand(ctx, "docker", "exec", containerID, "sh", "-c", "command -v apk || command -v apt-get")
+	if err != nil {
+		return "", fmt.Errorf("failed to detect package manager in %s: %w", containerID, err)
+	}
+
+	path := strings.TrimSpace(string(result.Stdout))
+	switch {
+	case strings.HasSuffix(path, "/apk"):
+		return PackageManagerAPK, nil
+	case strings.HasSuffix(path, "/apt-get"):
+		return PackageManagerAPT, nil
+	}
+	return "", fmt.Errorf("no supported package manager found in %s", containerID)
+}
+
+// SimulatePackageUpgrades asks the container's package manager what an in-place upgrade would change
+// and cross-references the result with the CVEs grype found for the same container.
+// Nothing is installed: apk runs with --simulate and apt-get with -s, after refreshing its package lists.
+func (ds *DockerSBOMService) SimulatePackageUpgrades(ctx context.Context, containerID string, cveList []tableprinter.CVEInfo) (*UpgradeSimulation, error) {
+	manager, err := ds.DetectPackageManager(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	switch manager {
+	case PackageManagerAPK:
+		// --no-cache fetches a fresh index without writing it into the container
+		args = []string{"exec", containerID, "apk", "--no-cache", "upgrade", "--simulate"}
+	case PackageManagerAPT:
+		// Slim Debian and Ubuntu images ship without package lists, apt-get would find nothing to upgrade
+		args = []string{"exec", containerID, "sh", "-c", "apt-get update -qq && apt-get -s upgrade"}
+	}
+
+	result, err := ds.executor.ExecCommand(ctx, "docker", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate upgrade in %s: %w", containerID, err)
+	}
+
+	simulation := &UpgradeSimulation{
+		ContainerID:    containerID,
+		PackageManager: manager,
+		Upgrades:       parseUpgradeSimulation(manager, string(result.Stdout)),
+	}
+	simulation.ClosedCVEs, simulation.RemainingCVEs = matchUpgradesToCVEs(simulation.Upgrades, cveList)
+	return simulation, nil
+}
+
+func parseUpgradeSimulation(manager, output string) []PackageUpgrade {
+	pattern := apkUpgradeLine
+	if manager == PackageManagerAPT {
+		pattern = aptUpgradeLine
+	}
+
+	var upgrades []PackageUpgrade
+	for _, line := range strings.Split(output, "\n") {
+		match := pattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		upgrades = append(upgrades, PackageUpgrade{
+			Package:          match[1],
+			CurrentVersion:   match[2],
+			CandidateVersion: match[3],
+		})
+	}
+	return upgrades
+}
+
+// matchUpgradesToCVEs splits the CVE list into the ones a package upgrade would close and the rest.
+// A CVE counts as closed when its package is upgraded to at least the version grype reports as the fix.
+func matchUpgradesToCVEs(upgrades []PackageUpgrade, cveList []tableprinter.CVEInfo) (closed, remaining []tableprinter.CVEInfo) {
+	candidates := make(map[string]string, len(upgrades))
+	for _, upgrade := range upgrades {
+		candidates[upgrade.Package] = upgrade.CandidateVersion
+	}
+
+	for _, cve := range cveList {
+		candidate, ok := candidates[cve.Package]
+		if ok && cve.ResolvedVersion != "" && version.Compare(candidate, cve.ResolvedVersion) >= 0 {
+			closed = append(closed, cve)
+			continue
+		}
+		remaining = append(remaining, cve)
+	}
+	return closed, remaining
+}
@@ -0,0 +1,102 @@
+package ignore
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule ignores a vulnerability that was assessed, e.g. as not exploitable, in every scanned target
+type Rule struct {
+	CVE     string `yaml:"cve"`
+	Package string `yaml:"package"` // Only ignores the CVE in this package when set
+	Until   string `yaml:"until"`   // YYYY-MM-DD, the CVE is reported again from this day on; empty ignores it for good
+	Reason  string `yaml:"reason"`
+}
+
+// List removes the ignored findings from scan results
+type List struct {
+	rules []rule
+	now   func() time.Time
+}
+
+// rule is a Rule with its expiry parsed
+type rule struct {
+	Rule
+	until time.Time
+}
+
+// NewList validates the rules. It returns nil, which ignores nothing, when there are none.
+func NewList(rules []Rule) (*List, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	l := &List{now: time.Now}
+	for i, r := range rules {
+		if r.CVE == "" {
+			return nil, fmt.Errorf("rule %d: cve is required", i+1)
+		}
+		parsed := rule{Rule: r}
+		if r.Until != "" {
+			until, err := time.ParseInLocation("2006-01-02", r.Until, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("%s: until expects YYYY-MM-DD, got %q", r.CVE, r.Until)
+			}
+			parsed.until = until
+		}
+		l.rules = append(l.rules, parsed)
+	}
+	return l, nil
+}
+
+// SetClock replaces time.Now when checking whether rules expired, for tests
+func (l *List) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+// Len returns the number of rules
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.rules)
+}
+
+// Filter returns the results without the ignored findings and how many were ignored. Every target stays
+// in the results, even when all of its findings were ignored, as it was still scanned.
+func (l *List) Filter(cveResults map[string][]tableprinter.CVEInfo) (map[string][]tableprinter.CVEInfo, int) {
+	if l == nil {
+		return cveResults, 0
+	}
+
+	now := l.now()
+	filtered := make(map[string][]tableprinter.CVEInfo, len(cveResults))
+	ignored := 0
+	for target, cveList := range cveResults {
+		var kept []tableprinter.CVEInfo
+		for _, cve := range cveList {
+			if l.ignores(cve, now) {
+				ignored++
+				continue
+			}
+			kept = append(kept, cve)
+		}
+		filtered[target] = kept
+	}
+	return filtered, ignored
+}
+
+// ignores reports whether a rule in effect at now matches the finding
+func (l *List) ignores(cve tableprinter.CVEInfo, now time.Time) bool {
+	for _, r := range l.rules {
+		if !r.until.IsZero() && !now.Before(r.until) {
+			continue
+		}
+		if strings.EqualFold(r.CVE, cve.CVEName) && (r.Package == "" || r.Package == cve.Package) {
+			return true
+		}
+	}
+	return false
+}
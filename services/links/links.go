@@ -0,0 +1,68 @@
+package links
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions a signed link can grant on a scan run
+const (
+	ActionReport = "report" // Read the run's report
+	ActionAck    = "ack"    // Acknowledge the run's findings
+	ActionRescan = "rescan" // Scan one of the run's targets again
+)
+
+// Signer creates and checks the tokens of links in notifications. A token grants a single action on a
+// single run until it expires, so anyone reading the notification, e.g. every subscriber of an ntfy topic,
+// can use its buttons without learning an API token.
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewSigner creates a Signer whose tokens are valid for ttl, signed with secret
+func NewSigner(secret string, ttl time.Duration) *Signer {
+	return &Signer{secret: []byte(secret), ttl: ttl, now: time.Now}
+}
+
+// SetClock replaces time.Now when signing and checking tokens, for tests
+func (s *Signer) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// Sign returns a token granting action on the run, formatted as <expiry unix time>.<signature>
+func (s *Signer) Sign(runID, action string) string {
+	expires := strconv.FormatInt(s.now().Add(s.ttl).Unix(), 10)
+	return expires + "." + s.signature(runID, action, expires)
+}
+
+// Verify checks that token was signed for action on the run and has not expired
+func (s *Signer) Verify(runID, action, token string) error {
+	expires, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("malformed link token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(runID, action, expires))) {
+		return errors.New("invalid link token")
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errors.New("malformed link token")
+	}
+	if s.now().After(time.Unix(unix, 0)) {
+		return errors.New("link token expired")
+	}
+	return nil
+}
+
+func (s *Signer) signature(runID, action, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(runID + "\n" + action + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stageBuckets are the histogram buckets for pipeline stage durations, in seconds.
+// Syft and grype runs range from a second for tiny images to several minutes for large ones.
+var stageBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// Registry collects scan results and pipeline health and exposes them in the Prometheus text format
+type Registry struct {
+	host string
+
+	mu              sync.Mutex
+	vulnerabilities map[string]map[vulnerabilityKey]int // Image -> series -> count
+	scannedAt       map[string]time.Time                // Image -> time of its latest scan
+	maxAge          time.Duration                       // Vulnerability series not refreshed for this long are removed
+	stageDurations  map[string]*histogram               // Stage -> histogram
+	stageFailures   map[string]int                      // Stage -> failures
+	lastSuccess     time.Time
+	dbBuilt         time.Time
+}
+
+type vulnerabilityKey struct {
+	severity string
+	fixable  bool
+}
+
+type histogram struct {
+	counts []uint64 // Cumulative count per bucket
+	sum    float64
+	count  uint64
+}
+
+// NewRegistry creates an empty Registry labelling vulnerability series with the given host
+func NewRegistry(host string) *Registry {
+	return &Registry{
+		host:            host,
+		vulnerabilities: make(map[string]map[vulnerabilityKey]int),
+		scannedAt:       make(map[string]time.Time),
+		stageDurations:  make(map[string]*histogram),
+		stageFailures:   make(map[string]int),
+	}
+}
+
+// SetStages declares the pipeline stages, so that their failure counters are exported as 0 before the first failure
+func (r *Registry) SetStages(stages ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stage := range stages {
+		if _, ok := r.stageFailures[stage]; !ok {
+			r.stageFailures[stage] = 0
+		}
+	}
+}
+
+// SetVulnerabilityMaxAge removes the vulnerability series of images not scanned for maxAge, e.g. because
+// the image was deleted. Zero keeps them forever.
+func (r *Registry) SetVulnerabilityMaxAge(maxAge time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxAge = maxAge
+}
+
+// ObserveStage records the duration of a pipeline stage and counts it as failed when err is set
+func (r *Registry) ObserveStage(stage string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.stageDurations[stage]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(stageBuckets))}
+		r.stageDurations[stage] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range stageBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+
+	if err != nil {
+		r.stageFailures[stage]++
+	}
+}
+
+// SetVulnerabilities replaces the vulnerability counts of an image with the given scan result
+func (r *Registry) SetVulnerabilities(image string, cveList []tableprinter.CVEInfo) {
+	counts := make(map[vulnerabilityKey]int)
+	for _, cve := range cveList {
+		severity := strings.ToLower(cve.Severity)
+		if severity == "" {
+			severity = "unknown"
+		}
+		counts[vulnerabilityKey{severity: severity, fixable: cve.ResolvedVersion != ""}]++
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vulnerabilities[image] = counts
+	r.scannedAt[image] = time.Now()
+}
+
+// SetLastSuccessfulScan records the completion time of the latest successful scan
+func (r *Registry) SetLastSuccessfulScan(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSuccess = t
+}
+
+// SetVulnerabilityDBBuilt records when the vulnerability database was built, exported as its age
+func (r *Registry) SetVulnerabilityDBBuilt(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dbBuilt = t
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxAge > 0 {
+		for image, scanned := range r.scannedAt {
+			if time.Since(scanned) > r.maxAge {
+				delete(r.vulnerabilities, image)
+				delete(r.scannedAt, image)
+			}
+		}
+	}
+
+	var b strings.Builder
+
+	writeHeader(&b, "cve_scanner_vulnerabilities", "gauge", "Vulnerabilities found in the latest scan of each image.")
+	for _, image := range sortedKeys(r.vulnerabilities) {
+		series := r.vulnerabilities[image]
+		keys := make([]vulnerabilityKey, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].severity != keys[j].severity {
+				return keys[i].severity < keys[j].severity
+			}
+			return !keys[i].fixable && keys[j].fixable
+		})
+		for _, key := range keys {
+			fmt.Fprintf(&b, "cve_scanner_vulnerabilities{host=%s,image=%s,severity=%s,fixable=\"%t\"} %d\n",
+				quote(r.host), quote(image), quote(key.severity), key.fixable, series[key])
+		}
+	}
+
+	writeHeader(&b, "cve_scanner_stage_duration_seconds", "histogram", "Duration of pipeline stages.")
+	for _, stage := range sortedKeys(r.stageDurations) {
+		h := r.stageDurations[stage]
+		for i, bound := range stageBuckets {
+			fmt.Fprintf(&b, "cve_scanner_stage_duration_seconds_bucket{stage=%s,le=\"%g\"} %d\n", quote(stage), bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "cve_scanner_stage_duration_seconds_bucket{stage=%s,le=\"+Inf\"} %d\n", quote(stage), h.count)
+		fmt.Fprintf(&b, "cve_scanner_stage_duration_seconds_sum{stage=%s} %g\n", quote(stage), h.sum)
+		fmt.Fprintf(&b, "cve_scanner_stage_duration_seconds_count{stage=%s} %d\n", quote(stage), h.count)
+	}
+
+	writeHeader(&b, "cve_scanner_stage_failures_total", "counter", "Failed runs of pipeline stages.")
+	for _, stage := range sortedKeys(r.stageFailures) {
+		fmt.Fprintf(&b, "cve_scanner_stage_failures_total{stage=%s} %d\n", quote(stage), r.stageFailures[stage])
+	}
+
+	if !r.lastSuccess.IsZero() {
+		writeHeader(&b, "cve_scanner_last_successful_scan_timestamp_seconds", "gauge", "Unix time of the latest successful scan.")
+		fmt.Fprintf(&b, "cve_scanner_last_successful_scan_timestamp_seconds %d\n", r.lastSuccess.Unix())
+	}
+
+	if !r.dbBuilt.IsZero() {
+		writeHeader(&b, "cve_scanner_vulnerability_db_age_seconds", "gauge", "Age of the grype vulnerability database.")
+		fmt.Fprintf(&b, "cve_scanner_vulnerability_db_age_seconds %g\n", time.Since(r.dbBuilt).Seconds())
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// quote escapes a label value as required by the exposition format
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,314 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batching modes of a channel
+const (
+	BatchNone   = ""       // Send every message on its own
+	BatchRun    = "run"    // One digest per scan run
+	BatchWindow = "window" // One digest per time window
+)
+
+// RunFlusher is implemented by notifiers holding messages back until their run has finished
+type RunFlusher interface {
+	FlushRun(ctx context.Context, runID string) error
+}
+
+// Flusher is implemented by notifiers holding messages back, which have to be sent before exiting
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Batcher collects messages and sends them as one digest per run and topic, so a run over dozens of
+// containers results in a single notification per route instead of one per container
+type Batcher struct {
+	inner     Notifier
+	window    time.Duration // Zero batches per run
+	onFailure FailureFunc
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	pending map[batchKey][]Message
+	order   []batchKey // Keys in the order of their first message
+	timer   *time.Timer
+}
+
+// FailureFunc handles a digest that could not be sent, e.g. by sending it to fallback channels.
+// The error it returns replaces the delivery error.
+type FailureFunc func(ctx context.Context, digest Message, err error) error
+
+type batchKey struct {
+	runID string
+	topic string
+}
+
+// NewBatcher wraps a notifier. With a zero window messages are held until FlushRun is called for
+// their run; otherwise everything queued is sent once the window after the first message has passed.
+func NewBatcher(inner Notifier, window time.Duration) *Batcher {
+	return &Batcher{inner: inner, window: window, pending: make(map[batchKey][]Message), logger: slog.Default()}
+}
+
+// SetFailureHandler makes the batcher pass every digest it fails to send to fn
+func (b *Batcher) SetFailureHandler(fn FailureFunc) {
+	b.onFailure = fn
+}
+
+// SetLogger sets the logger reporting digests of windowed batches that could not be sent
+func (b *Batcher) SetLogger(logger *slog.Logger) {
+	b.logger = logger
+}
+
+// Notify queues the message for the next digest. Sending it can only fail later, when the digest is flushed.
+func (b *Batcher) Notify(ctx context.Context, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := batchKey{topic: msg.Topic}
+	if b.window == 0 {
+		key.runID = msg.RunID
+	}
+	if _, ok := b.pending[key]; !ok {
+		b.order = append(b.order, key)
+	}
+	b.pending[key] = append(b.pending[key], msg)
+
+	if b.window > 0 && b.timer == nil {
+		b.timer = time.AfterFunc(b.window, func() {
+			if err := b.Flush(context.Background()); err != nil {
+				b.logger.Error("Failed to send notification digest", "error", err)
+			}
+		})
+	}
+	return nil
+}
+
+// FlushRun sends the digests of a finished run. Windowed batchers ignore it and flush on their own schedule.
+func (b *Batcher) FlushRun(ctx context.Context, runID string) error {
+	if b.window > 0 {
+		return nil
+	}
+	return b.flush(ctx, func(key batchKey) bool { return key.runID == runID })
+}
+
+// Flush sends everything queued now, e.g. on shutdown
+func (b *Batcher) Flush(ctx context.Context) error {
+	return b.flush(ctx, func(batchKey) bool { return true })
+}
+
+func (b *Batcher) flush(ctx context.Context, selected func(batchKey) bool) error {
+	b.mu.Lock()
+	var digests []Message
+	var remaining []batchKey
+	for _, key := range b.order {
+		if !selected(key) {
+			remaining = append(remaining, key)
+			continue
+		}
+		digests = append(digests, Digest(b.pending[key]))
+		delete(b.pending, key)
+	}
+	b.order = remaining
+	if b.timer != nil && len(b.order) == 0 {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	var errs []error
+	for _, digest := range digests {
+		err := b.inner.Notify(ctx, digest)
+		if err != nil && b.onFailure != nil {
+			err = b.onFailure(ctx, digest, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Digest combines messages into one. A single message is returned unchanged.
+func Digest(msgs []Message) Message {
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+
+	digest := Message{Topic: msgs[0].Topic, RunID: msgs[0].RunID}
+	for _, msg := range msgs {
+		for _, channel := range msg.Fallback {
+			if !slices.Contains(digest.Fallback, channel) {
+				digest.Fallback = append(digest.Fallback, channel)
+			}
+		}
+	}
+	var reports, statuses, summaries []string
+	sameRun := true
+	for _, msg := range msgs {
+		if msg.RunID != digest.RunID {
+			sameRun = false
+		}
+		if msg.Report == "" {
+			statuses = append(statuses, msg.Summary)
+			continue
+		}
+
+		firstLine, _, _ := strings.Cut(msg.Summary, "\n")
+		summaries = append(summaries, fmt.Sprintf("• %s: %s", msg.Title, firstLine))
+		reports = append(reports, msg.Report)
+		digest.CVEs = append(digest.CVEs, msg.CVEs...)
+		if digest.ReportURL == "" {
+			digest.ReportURL = msg.ReportURL
+			digest.AckURL = msg.AckURL
+		}
+	}
+	if !sameRun {
+		// Links of one run would not cover the others
+		digest.RunID, digest.ReportURL, digest.AckURL = "", "", ""
+	}
+
+	digest.Severity = WorstSeverity(digest.CVEs)
+	switch {
+	case len(reports) == 0:
+		digest.Title = "Scan Digest"
+	case digest.Severity == "":
+		digest.Title = fmt.Sprintf("CVE Scan Digest: %d targets, no CVEs", len(reports))
+	default:
+		digest.Title = fmt.Sprintf("CVE Scan Digest: %d targets, %d CVEs, worst %s", len(reports), len(digest.CVEs), digest.Severity)
+	}
+	digest.Summary = strings.Join(append(summaries, statuses...), "\n")
+	if len(reports) > 0 {
+		digest.Report = strings.Join(reports, "\n")
+		digest.Filename = "cve-digest.txt"
+		if digest.RunID != "" {
+			digest.Filename = "cve-digest-" + digest.RunID + ".txt"
+		}
+	}
+	return digest
+}
+
+// RateLimiter lets at most a fixed number of messages through per minute and delays the rest,
+// keeping chatty runs below the limits of services like ntfy
+type RateLimiter struct {
+	inner     Notifier
+	perMinute int
+
+	mu   sync.Mutex
+	sent []time.Time // Send times within the last minute, oldest first
+}
+
+// NewRateLimiter wraps a notifier allowing perMinute messages in any minute
+func NewRateLimiter(inner Notifier, perMinute int) *RateLimiter {
+	return &RateLimiter{inner: inner, perMinute: perMinute}
+}
+
+// Notify waits until the message fits in the limit, failing when ctx ends first, then sends it
+func (r *RateLimiter) Notify(ctx context.Context, msg Message) error {
+	for {
+		wait := r.reserve(time.Now())
+		if wait == 0 {
+			return r.inner.Notify(ctx, msg)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("rate limit of %d messages per minute reached: %w", r.perMinute, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve records a send at now and returns zero when the limit allows it, otherwise how long to wait
+func (r *RateLimiter) reserve(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := now.Add(-time.Minute)
+	for len(r.sent) > 0 && !r.sent[0].After(cutoff) {
+		r.sent = r.sent[1:]
+	}
+	if len(r.sent) >= r.perMinute {
+		return r.sent[0].Sub(cutoff)
+	}
+	r.sent = append(r.sent, now)
+	return 0
+}
+
+// Truncator shortens messages exceeding a channel's size limit
+type Truncator struct {
+	inner    Notifier
+	maxBytes int
+	format   string
+}
+
+// NewTruncator wraps a notifier limiting the text it sends in the given format to maxBytes
+func NewTruncator(inner Notifier, maxBytes int, format string) *Truncator {
+	return &Truncator{inner: inner, maxBytes: maxBytes, format: format}
+}
+
+// Notify sends the message truncated to the limit
+func (t *Truncator) Notify(ctx context.Context, msg Message) error {
+	return t.inner.Notify(ctx, Truncate(msg, t.maxBytes, t.format))
+}
+
+// Truncate shortens the text a channel in the given format sends inline, the summary and in the full
+// format also the report, at line boundaries to fit in maxBytes. Shortened text ends with a link to the
+// full report when there is one. In the summary format the report stays complete as channels only attach it.
+func Truncate(msg Message, maxBytes int, format string) Message {
+	inline := len(msg.Summary)
+	if format == FormatFull {
+		inline += len(msg.Report)
+	}
+	if inline <= maxBytes {
+		return msg
+	}
+
+	if len(msg.Summary) > maxBytes {
+		msg.Summary = shorten(msg.Summary, maxBytes, msg.ReportURL)
+	}
+	if format == FormatFull && msg.Report != "" && len(msg.Summary)+len(msg.Report) > maxBytes {
+		msg.Report = shorten(msg.Report, maxBytes-len(msg.Summary), msg.ReportURL)
+	}
+	return msg
+}
+
+// shorten cuts text to at most n bytes, including a note that it was truncated. The note leaves out the
+// link to the full report, and then goes away entirely, when it would leave no room for the text.
+func shorten(text string, n int, reportURL string) string {
+	notes := []string{"\n… truncated"}
+	if reportURL != "" {
+		notes = append([]string{"\n… truncated, see full report: " + reportURL}, notes...)
+	}
+	for _, note := range notes {
+		if kept := truncateLines(text, n-len(note)); kept != "" {
+			return kept + note
+		}
+	}
+	return truncateLines(text, n)
+}
+
+// truncateLines cuts text to at most n bytes, at the last line break when there is one
+func truncateLines(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(text) <= n {
+		return text
+	}
+	text = text[:n]
+	if i := strings.LastIndexByte(text, '\n'); i > 0 {
+		return text[:i]
+	}
+	// Avoid splitting a multi-byte character
+	return strings.ToValidUTF8(text, "")
+}
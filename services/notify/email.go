@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailNotifier sends messages as HTML mail with a plain-text alternative over SMTP
+type EmailNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	render   *Renderer
+	timeout  time.Duration
+}
+
+// NewEmailNotifier creates a notifier for the SMTP server in the configuration
+func NewEmailNotifier(config ChannelConfig, render *Renderer, timeout time.Duration) (*EmailNotifier, error) {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("email needs host, from and to")
+	}
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	return &EmailNotifier{
+		addr:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		host:     config.Host,
+		username: config.Username,
+		password: config.Password,
+		from:     config.From,
+		to:       config.To,
+		render:   render,
+		timeout:  timeout,
+	}, nil
+}
+
+// Notify sends the message to all recipients
+func (n *EmailNotifier) Notify(ctx context.Context, msg Message) error {
+	mail, err := n.compose(msg)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	// PlainAuth refuses to send the password over an unencrypted connection to anything but localhost
+	if n.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.username, n.password, n.host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mail); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// compose builds the MIME message with a plain-text and an HTML part
+func (n *EmailNotifier) compose(msg Message) ([]byte, error) {
+	subject, err := n.render.Title(msg)
+	if err != nil {
+		return nil, err
+	}
+	text, err := n.render.Body(msg)
+	if err != nil {
+		return nil, err
+	}
+	htmlPart, err := n.render.HTML(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", htmlPart},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n")))
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "From: %s\r\n", n.from)
+	fmt.Fprintf(&mail, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&mail, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&mail, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&mail, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&mail, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	mail.Write(parts.Bytes())
+	return mail.Bytes(), nil
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// GotifyNotifier pushes messages to a Gotify server
+type GotifyNotifier struct {
+	url    string
+	token  string
+	render *Renderer
+	client *http.Client
+}
+
+// NewGotifyNotifier creates a notifier for the server, authenticated with an application token
+func NewGotifyNotifier(url, token string, render *Renderer, client *http.Client) (*GotifyNotifier, error) {
+	if url == "" || token == "" {
+		return nil, errors.New("gotify needs url and token")
+	}
+	return &GotifyNotifier{url: strings.TrimSuffix(url, "/"), token: token, render: render, client: client}, nil
+}
+
+// gotifyPriorities follow Gotify's Android client, which makes a sound from 4 and pops up from 8
+var gotifyPriorities = map[string]int{
+	"critical": 10,
+	"high":     8,
+	"medium":   5,
+	"low":      2,
+}
+
+// Notify pushes the message, opening the report when tapped
+func (n *GotifyNotifier) Notify(ctx context.Context, msg Message) error {
+	title, err := n.render.Title(msg)
+	if err != nil {
+		return err
+	}
+	text, err := n.render.Body(msg)
+	if err != nil {
+		return err
+	}
+
+	extras := map[string]any{
+		"client::display": map[string]string{"contentType": "text/markdown"},
+	}
+	if msg.ReportURL != "" {
+		extras["client::notification"] = map[string]any{"click": map[string]string{"url": msg.ReportURL}}
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"title":    title,
+		"message":  text,
+		"priority": gotifyPriorities[msg.Severity],
+		"extras":   extras,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(ctx, http.MethodPost, n.url+"/message", payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Gotify-Key", n.token)
+	return send(n.client, req)
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MatrixNotifier sends messages to a Matrix room through the client-server API
+type MatrixNotifier struct {
+	homeserver string
+	room       string
+	token      string
+	render     *Renderer
+	client     *http.Client
+}
+
+// NewMatrixNotifier creates a notifier for the room, authenticated with the access token of a bot account
+func NewMatrixNotifier(homeserver, room, token string, render *Renderer, client *http.Client) (*MatrixNotifier, error) {
+	if homeserver == "" || room == "" || token == "" {
+		return nil, errors.New("matrix needs url, room and token")
+	}
+	return &MatrixNotifier{homeserver: strings.TrimSuffix(homeserver, "/"), room: room, token: token, render: render, client: client}, nil
+}
+
+// Notify sends the message as text with an HTML rendering for clients that support it
+func (n *MatrixNotifier) Notify(ctx context.Context, msg Message) error {
+	title, err := n.render.Title(msg)
+	if err != nil {
+		return err
+	}
+	text, err := n.render.Body(msg)
+	if err != nil {
+		return err
+	}
+	formatted, err := n.render.HTML(msg)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"msgtype":        "m.text",
+		"body":           title + "\n" + text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The transaction ID makes retries of the same request idempotent
+	txn := make([]byte, 8)
+	if _, err := rand.Read(txn); err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		n.homeserver, url.PathEscape(n.room), hex.EncodeToString(txn))
+
+	req, err := newJSONRequest(ctx, http.MethodPut, endpoint, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	return send(n.client, req)
+}
@@ -0,0 +1,299 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Channel types accepted in the configuration
+const (
+	TypeNtfy    = "ntfy"
+	TypeSlack   = "slack"
+	TypeTeams   = "teams"
+	TypeWebhook = "webhook"
+	TypeEmail   = "email"
+	TypeGotify  = "gotify"
+	TypeMatrix  = "matrix"
+)
+
+// Message formats, choosing how much of the report a channel receives
+const (
+	FormatSummary = "summary" // The summary only, the full report is attached where the channel supports files
+	FormatFull    = "full"    // The summary followed by the full report
+)
+
+// Message is a notification independent of the channel delivering it
+type Message struct {
+	Title    string                 `json:"title"`
+	Summary  string                 `json:"summary"`            // A few lines describing the findings
+	Report   string                 `json:"report,omitempty"`   // The full plain-text report, empty for status messages like "Scan Complete"
+	Filename string                 `json:"filename,omitempty"` // Name under which channels supporting files attach the report
+	CVEs     []tableprinter.CVEInfo `json:"cves,omitempty"`     // The findings the message is about
+	Severity string                 `json:"severity,omitempty"` // Worst severity among the CVEs, empty when there are none
+	RunID    string                 `json:"run_id,omitempty"`
+	Topic    string                 `json:"topic,omitempty"`    // Overrides the topic of ntfy channels, set by routing rules
+	Fallback []string               `json:"fallback,omitempty"` // Channels of the route to try when a batching channel fails to send its digest
+
+	// Links into the HTTP API, empty when it is not exposed
+	ReportURL  string `json:"report_url,omitempty"`
+	AckURL     string `json:"ack_url,omitempty"`
+	RescanURL  string `json:"rescan_url,omitempty"`
+	RescanBody string `json:"rescan_body,omitempty"` // JSON body of the rescan request, e.g. {"container":"abc123"}
+}
+
+// Notifier delivers messages to one kind of chat, mail or push service
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Channel is a configured notifier together with the messages it wants and how it formats them
+type Channel struct {
+	Name        string
+	Notifier    Notifier
+	MinSeverity string // Only findings at least this severe are sent, empty accepts everything
+}
+
+// Accepts reports whether the message passes the channel's severity filter.
+// Channels with a filter only receive messages whose worst finding reaches it.
+func (c Channel) Accepts(msg Message) bool {
+	if c.MinSeverity == "" {
+		return true
+	}
+	return msg.Severity != "" && SeverityRank(msg.Severity) >= SeverityRank(c.MinSeverity)
+}
+
+// ChannelConfig configures one notification channel. Which fields apply depends on the type.
+type ChannelConfig struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`         // ntfy, slack, teams, webhook, email, gotify or matrix
+	MinSeverity    string `yaml:"min_severity"` // e.g. high, empty for all messages
+	Format         string `yaml:"format"`       // summary (default) or full
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+
+	Batch              string `yaml:"batch"`                // run or window to send digests, empty sends every message
+	BatchWindowSeconds int    `yaml:"batch_window_seconds"` // Window of the window mode, defaults to 5 minutes
+	RateLimitPerMinute int    `yaml:"rate_limit_per_minute"`
+	MaxMessageBytes    int    `yaml:"max_message_bytes"` // Longer messages are truncated with a link to the full report
+	MaxAttempts        int    `yaml:"max_attempts"`      // Immediate delivery attempts, defaults to 3; the outbox retries later
+
+	URL      string `yaml:"url"`      // Webhook URL, or the server of ntfy, Gotify and Matrix
+	Topic    string `yaml:"topic"`    // ntfy
+	Username string `yaml:"username"` // ntfy and email
+	Password string `yaml:"password"` // ntfy and email
+	Token    string `yaml:"token"`    // Gotify application token or Matrix access token
+	Secret   string `yaml:"secret"`   // webhook: HMAC-SHA256 signing key
+	Room     string `yaml:"room"`     // matrix: room ID, e.g. !abc:matrix.org
+
+	Host string   `yaml:"host"` // email: SMTP server
+	Port int      `yaml:"port"` // email: defaults to 587
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+}
+
+// NewChannel creates the channel described by the configuration, rendering its messages with the
+// templates or, when nil, the built-in ones. Deliveries are recorded in the outbox, which retries them
+// after failures; a nil outbox only retries immediately.
+func NewChannel(config ChannelConfig, templates *Templates, outbox *Outbox) (Channel, error) {
+	if config.MinSeverity != "" && SeverityRank(config.MinSeverity) == 0 {
+		return Channel{}, fmt.Errorf("channel %s: unknown severity %q", config.Name, config.MinSeverity)
+	}
+	switch config.Format {
+	case "", FormatSummary, FormatFull:
+	default:
+		return Channel{}, fmt.Errorf("channel %s: unknown format %q, expected summary or full", config.Name, config.Format)
+	}
+	switch config.Batch {
+	case BatchNone, BatchRun, BatchWindow:
+	default:
+		return Channel{}, fmt.Errorf("channel %s: unknown batch mode %q, expected run or window", config.Name, config.Batch)
+	}
+
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	name := config.Name
+	if name == "" {
+		name = config.Type
+	}
+	render := templates.For(name, config.Type, config.Format)
+
+	var notifier Notifier
+	var err error
+	switch config.Type {
+	case TypeNtfy:
+		notifier, err = NewNtfyNotifier(config, render, timeout)
+	case TypeSlack:
+		notifier, err = NewSlackNotifier(config.URL, render, client)
+	case TypeTeams:
+		notifier, err = NewTeamsNotifier(config.URL, render, client)
+	case TypeWebhook:
+		notifier, err = NewWebhookNotifier(config.URL, config.Secret, client)
+	case TypeEmail:
+		notifier, err = NewEmailNotifier(config, render, timeout)
+	case TypeGotify:
+		notifier, err = NewGotifyNotifier(config.URL, config.Token, render, client)
+	case TypeMatrix:
+		notifier, err = NewMatrixNotifier(config.URL, config.Room, config.Token, render, client)
+	default:
+		return Channel{}, fmt.Errorf("channel %s: unknown type %q", config.Name, config.Type)
+	}
+	if err != nil {
+		return Channel{}, fmt.Errorf("channel %s: %w", config.Name, err)
+	}
+
+	// Digests are retried, rate limited and truncated like single messages
+	policy := DefaultRetryPolicy
+	if config.MaxAttempts > 0 {
+		policy.Attempts = config.MaxAttempts
+	}
+	notifier = NewRetrier(notifier, policy)
+	if config.RateLimitPerMinute > 0 {
+		notifier = NewRateLimiter(notifier, config.RateLimitPerMinute)
+	}
+	if outbox != nil {
+		notifier = outbox.Wrap(name, notifier)
+	}
+	if config.MaxMessageBytes > 0 {
+		notifier = NewTruncator(notifier, config.MaxMessageBytes, config.Format)
+	}
+	switch config.Batch {
+	case BatchRun:
+		notifier = NewBatcher(notifier, 0)
+	case BatchWindow:
+		window := time.Duration(config.BatchWindowSeconds) * time.Second
+		if window <= 0 {
+			window = 5 * time.Minute
+		}
+		notifier = NewBatcher(notifier, window)
+	}
+
+	return Channel{Name: name, Notifier: notifier, MinSeverity: strings.ToLower(config.MinSeverity)}, nil
+}
+
+// severityRanks orders severities from least to most severe; unknown severities rank 0
+var severityRanks = map[string]int{
+	"negligible": 1,
+	"low":        2,
+	"medium":     3,
+	"high":       4,
+	"critical":   5,
+}
+
+// SeverityRank returns how severe a severity is, higher is worse
+func SeverityRank(severity string) int {
+	return severityRanks[strings.ToLower(severity)]
+}
+
+// WorstSeverity returns the most severe severity in the list in lower case, or an empty string for an empty list
+func WorstSeverity(cveList []tableprinter.CVEInfo) string {
+	worst := ""
+	for _, cve := range cveList {
+		severity := strings.ToLower(cve.Severity)
+		if severity == "" {
+			severity = "unknown"
+		}
+		if worst == "" || SeverityRank(severity) > SeverityRank(worst) {
+			worst = severity
+		}
+	}
+	return worst
+}
+
+// maxListedCVEs bounds the CVEs listed in a summary; the full report has all of them
+const maxListedCVEs = 5
+
+// Summarize describes the findings in a few lines, e.g. "3 CVEs: 1 critical, 2 high" followed by the worst CVEs
+func Summarize(cveList []tableprinter.CVEInfo) string {
+	if len(cveList) == 0 {
+		return "No CVEs found"
+	}
+
+	counts := make(map[string]int)
+	for _, cve := range cveList {
+		severity := strings.ToLower(cve.Severity)
+		if severity == "" {
+			severity = "unknown"
+		}
+		counts[severity]++
+	}
+	var parts []string
+	for _, severity := range []string{"critical", "high", "medium", "low", "negligible", "unknown"} {
+		if counts[severity] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d CVEs: %s", len(cveList), strings.Join(parts, ", "))
+
+	sorted := append([]tableprinter.CVEInfo(nil), cveList...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return SeverityRank(sorted[i].Severity) > SeverityRank(sorted[j].Severity)
+	})
+	for i, cve := range sorted {
+		if i == maxListedCVEs {
+			fmt.Fprintf(&b, "\n… and %d more in the full report", len(sorted)-maxListedCVEs)
+			break
+		}
+		fix := "no fix"
+		if cve.ResolvedVersion != "" {
+			fix = "fixed in " + cve.ResolvedVersion
+		}
+		fmt.Fprintf(&b, "\n%s (%s) %s %s, %s", cve.CVEName, strings.ToLower(cve.Severity), cve.Package, cve.CurrentVersion, fix)
+	}
+	return b.String()
+}
+
+// severityColors are the hex colours chat cards use for each severity
+var severityColors = map[string]string{
+	"critical": "#b00020",
+	"high":     "#e65100",
+	"medium":   "#f9a825",
+	"low":      "#1565c0",
+}
+
+// severityColor returns the card colour for a message's worst severity, green when nothing was found
+func severityColor(severity string) string {
+	if color, ok := severityColors[severity]; ok {
+		return color
+	}
+	if severity == "" {
+		return "#2e7d32"
+	}
+	return "#757575"
+}
+
+// send performs the request and turns non-2xx responses into HTTPErrors including the start of the response body
+func send(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(detail))}
+	}
+	return nil
+}
+
+// newJSONRequest creates a request sending body as JSON
+func newJSONRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
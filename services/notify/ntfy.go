@@ -0,0 +1,85 @@
+package notify
+
+import (
+	ntfyclient "AutomaticCVEResolver/services/ntfy"
+	"context"
+	"errors"
+	"time"
+)
+
+// NtfyNotifier publishes messages to an ntfy topic, with the priority and tags following the worst
+// severity, the full report as an attachment and buttons calling back into the HTTP API
+type NtfyNotifier struct {
+	client ntfyclient.NtfyService
+	render *Renderer
+}
+
+// NewNtfyNotifier creates a notifier for the ntfy server and topic in the configuration
+func NewNtfyNotifier(config ChannelConfig, render *Renderer, timeout time.Duration) (*NtfyNotifier, error) {
+	if config.URL == "" || config.Topic == "" {
+		return nil, errors.New("ntfy needs url and topic")
+	}
+	client, err := ntfyclient.NewNtfyClient(config.URL, config.Topic, config.Username, config.Password, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &NtfyNotifier{client: client, render: render}, nil
+}
+
+// NewNtfyNotifierWithClient creates a notifier publishing through an existing client.
+// Messages are rendered with the built-in templates.
+func NewNtfyNotifierWithClient(client ntfyclient.NtfyService, format string) *NtfyNotifier {
+	return &NtfyNotifier{client: client, render: DefaultTemplates().For(TypeNtfy, TypeNtfy, format)}
+}
+
+// Notify publishes the message
+func (n *NtfyNotifier) Notify(ctx context.Context, msg Message) error {
+	title, err := n.render.Title(msg)
+	if err != nil {
+		return err
+	}
+	text, err := n.render.Body(msg)
+	if err != nil {
+		return err
+	}
+
+	notification := ntfyclient.Notification{
+		Topic:    msg.Topic,
+		Title:    title,
+		Message:  text,
+		ClickURL: msg.ReportURL,
+	}
+	if msg.Report != "" {
+		notification.Attachment = &ntfyclient.Attachment{Filename: msg.Filename, Content: []byte(msg.Report)}
+		notification.Priority = ntfyclient.PriorityForSeverity(msg.Severity)
+		notification.Tags = append(ntfyclient.TagsForSeverity(msg.Severity), "cve")
+	}
+
+	if msg.ReportURL != "" {
+		notification.Actions = append(notification.Actions, ntfyclient.Action{Type: ntfyclient.ActionView, Label: "Open report", URL: msg.ReportURL})
+	}
+	// The links are signed for this run and action only, subscribers of the topic need no API token
+	headers := map[string]string{"Content-Type": "application/json"}
+	if msg.AckURL != "" {
+		notification.Actions = append(notification.Actions, ntfyclient.Action{
+			Type:    ntfyclient.ActionHTTP,
+			Label:   "Acknowledge",
+			URL:     msg.AckURL,
+			Headers: headers,
+			Body:    `{"by":"ntfy"}`,
+			Clear:   true,
+		})
+	}
+	if msg.RescanURL != "" {
+		notification.Actions = append(notification.Actions, ntfyclient.Action{
+			Type:    ntfyclient.ActionHTTP,
+			Label:   "Trigger rescan",
+			URL:     msg.RescanURL,
+			Headers: headers,
+			Body:    msg.RescanBody,
+		})
+	}
+
+	_, err = n.client.Publish(ctx, notification)
+	return err
+}
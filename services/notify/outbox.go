@@ -0,0 +1,328 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"   // Not delivered yet, retried later
+	DeliveryDelivered = "delivered" // Accepted by the channel
+	DeliveryFailed    = "failed"    // Given up: a permanent error, an unknown channel or too old
+)
+
+// Delivery is one message on its way to one channel
+type Delivery struct {
+	ID          string    `json:"id"`
+	Channel     string    `json:"channel"`
+	Message     Message   `json:"message"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"` // When a pending delivery is retried
+}
+
+// Outbox records every delivery and keeps retrying those that failed for a retryable reason until
+// they succeed or get too old. With a directory it persists them, so alerts raised while a service
+// was unreachable are still delivered after a restart.
+type Outbox struct {
+	dir       string
+	maxAge    time.Duration // Pending deliveries older than this are given up
+	retention time.Duration // Finished deliveries are kept this long for status queries
+	backoff   RetryPolicy   // Delay between redeliveries
+	logger    *slog.Logger
+
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+	sending    map[string]bool // Deliveries currently being attempted
+	channels   map[string]Notifier
+}
+
+// NewOutbox creates an outbox persisting deliveries to dir and loads those already saved there.
+// Files that cannot be read are moved aside with a .corrupt suffix and logged, so one damaged file
+// does not stop the scanner from starting. An empty dir keeps deliveries in memory only.
+func NewOutbox(dir string) (*Outbox, error) {
+	o := &Outbox{
+		dir:        dir,
+		maxAge:     24 * time.Hour,
+		retention:  7 * 24 * time.Hour,
+		backoff:    RetryPolicy{InitialDelay: 30 * time.Second, MaxDelay: 30 * time.Minute},
+		deliveries: make(map[string]*Delivery),
+		sending:    make(map[string]bool),
+		channels:   make(map[string]Notifier),
+		logger:     slog.Default(),
+	}
+	if dir == "" {
+		return o, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		delivery, err := readDelivery(file)
+		if err != nil {
+			o.logger.Error("Skipping unreadable delivery", "file", file, "error", err)
+			if err := os.Rename(file, file+".corrupt"); err != nil {
+				o.logger.Error("Failed to move unreadable delivery aside", "file", file, "error", err)
+			}
+			continue
+		}
+		o.deliveries[delivery.ID] = delivery
+	}
+	return o, nil
+}
+
+// readDelivery loads a delivery saved by the outbox
+func readDelivery(file string) (*Delivery, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delivery: %w", err)
+	}
+	var delivery Delivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, fmt.Errorf("failed to parse delivery: %w", err)
+	}
+	if delivery.ID == "" || delivery.Channel == "" {
+		return nil, errors.New("delivery without id or channel")
+	}
+	return &delivery, nil
+}
+
+// SetLogger replaces the default logger used to report redeliveries
+func (o *Outbox) SetLogger(logger *slog.Logger) {
+	o.logger = logger
+}
+
+// SetMaxAge sets how long failed deliveries are retried before they are given up
+func (o *Outbox) SetMaxAge(maxAge time.Duration) {
+	o.maxAge = maxAge
+}
+
+// SetBackoff sets the delay between redeliveries: initial after the first failure, doubling up to max
+func (o *Outbox) SetBackoff(initial, max time.Duration) {
+	o.backoff = RetryPolicy{InitialDelay: initial, MaxDelay: max}
+}
+
+// Wrap returns a notifier recording each message for the named channel in the outbox before passing
+// it to inner. Deliveries to the channel, including those loaded from disk, are retried through inner.
+func (o *Outbox) Wrap(channel string, inner Notifier) Notifier {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.channels[channel] = inner
+	return &outboxNotifier{outbox: o, channel: channel}
+}
+
+type outboxNotifier struct {
+	outbox  *Outbox
+	channel string
+}
+
+// Notify records the message and sends it. When sending fails for a retryable reason the message
+// stays in the outbox for redelivery and the error says so.
+func (n *outboxNotifier) Notify(ctx context.Context, msg Message) error {
+	delivery, err := n.outbox.add(n.channel, msg)
+	if err != nil {
+		return err
+	}
+	delivery, err = n.outbox.attempt(ctx, delivery.ID)
+	if err != nil && delivery.Status == DeliveryPending {
+		return fmt.Errorf("%w; queued for redelivery as %s", err, delivery.ID)
+	}
+	return err
+}
+
+// add records a new pending delivery. It is not due for redelivery until its first attempt failed.
+func (o *Outbox) add(channel string, msg Message) (Delivery, error) {
+	id, err := newDeliveryID()
+	if err != nil {
+		return Delivery{}, err
+	}
+	now := time.Now().UTC()
+	delivery := &Delivery{ID: id, Channel: channel, Message: msg, Status: DeliveryPending, CreatedAt: now, UpdatedAt: now}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.deliveries[id] = delivery
+	o.sending[id] = true
+	return *delivery, o.save(delivery)
+}
+
+// attempt sends a delivery through its channel's notifier and records the outcome
+func (o *Outbox) attempt(ctx context.Context, id string) (Delivery, error) {
+	o.mu.Lock()
+	delivery := *o.deliveries[id]
+	notifier, ok := o.channels[delivery.Channel]
+	o.sending[id] = true
+	o.mu.Unlock()
+
+	var err error
+	if ok {
+		err = notifier.Notify(ctx, delivery.Message)
+	} else {
+		// The channel was removed from the configuration since the message was queued
+		err = Permanent(fmt.Errorf("channel %s is not configured", delivery.Channel))
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.sending, id)
+
+	d := o.deliveries[id]
+	now := time.Now().UTC()
+	d.Attempts++
+	d.UpdatedAt = now
+	d.NextAttempt = time.Time{}
+	switch {
+	case err == nil:
+		d.Status = DeliveryDelivered
+		d.LastError = ""
+	case !Retryable(err):
+		d.Status = DeliveryFailed
+		d.LastError = err.Error()
+	case now.Sub(d.CreatedAt) >= o.maxAge:
+		d.Status = DeliveryFailed
+		d.LastError = fmt.Sprintf("gave up after %d attempts: %v", d.Attempts, err)
+	default:
+		d.LastError = err.Error()
+		d.NextAttempt = now.Add(o.backoff.Backoff(d.Attempts))
+	}
+	if saveErr := o.save(d); saveErr != nil {
+		err = errors.Join(err, saveErr)
+	}
+	return *d, err
+}
+
+// Redeliver retries the pending deliveries that are due, logs and returns them with their new status.
+// It also forgets finished deliveries past their retention.
+func (o *Outbox) Redeliver(ctx context.Context) ([]Delivery, error) {
+	now := time.Now().UTC()
+
+	o.mu.Lock()
+	var due []string
+	for id, d := range o.deliveries {
+		switch {
+		case d.Status == DeliveryPending && !o.sending[id] && !d.NextAttempt.After(now):
+			due = append(due, id)
+		case d.Status != DeliveryPending && now.Sub(d.UpdatedAt) > o.retention:
+			delete(o.deliveries, id)
+			if o.dir != "" {
+				os.Remove(filepath.Join(o.dir, id+".json"))
+			}
+		}
+	}
+	o.mu.Unlock()
+	// IDs start with their creation time, so older alerts go out first
+	sort.Strings(due)
+
+	var results []Delivery
+	var errs []error
+	for _, id := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		d, err := o.attempt(ctx, id)
+		results = append(results, d)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delivery %s to %s: %w", id, d.Channel, err))
+		}
+
+		switch d.Status {
+		case DeliveryDelivered:
+			o.logger.Info("Redelivered notification", "delivery", d.ID, "channel", d.Channel, "title", d.Message.Title, "attempts", d.Attempts)
+		case DeliveryFailed:
+			o.logger.Error("Giving up on notification", "delivery", d.ID, "channel", d.Channel, "title", d.Message.Title, "attempts", d.Attempts, "error", d.LastError)
+		default:
+			o.logger.Warn("Notification still undeliverable", "delivery", d.ID, "channel", d.Channel, "attempts", d.Attempts, "next_attempt", d.NextAttempt, "error", d.LastError)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Run redelivers due messages every interval until ctx is cancelled
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		o.Redeliver(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get returns a copy of the delivery with the given id
+func (o *Outbox) Get(id string) (Delivery, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delivery, ok := o.deliveries[id]
+	if !ok {
+		return Delivery{}, false
+	}
+	return *delivery, true
+}
+
+// List returns all deliveries, newest first
+func (o *Outbox) List() []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	deliveries := make([]Delivery, 0, len(o.deliveries))
+	for _, delivery := range o.deliveries {
+		deliveries = append(deliveries, *delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	return deliveries
+}
+
+func (o *Outbox) save(delivery *Delivery) error {
+	if o.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery %s: %w", delivery.ID, err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated delivery behind
+	path := filepath.Join(o.dir, delivery.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to save delivery %s: %w", delivery.ID, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func newDeliveryID() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate delivery id: %w", err)
+	}
+	// Prefix with the time so IDs sort chronologically
+	return strings.ToLower(time.Now().UTC().Format("20060102T150405.000000")) + "-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// HTTPError is returned for non-2xx responses of chat and push services
+type HTTPError struct {
+	StatusCode int
+	Body       string // Start of the response body, often naming the problem
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected response %d: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatus returns the response status code
+func (e *HTTPError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, e.g. a message the service can never accept
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Retryable reports whether delivering the message again may succeed. Connection problems, timeouts,
+// rate limits and server errors are retryable; other HTTP client errors like a wrong token or an
+// unknown topic, permanent SMTP replies, certificate errors, unknown hosts, unsupported URL schemes,
+// cancellation and any other error, e.g. a message that cannot be encoded, are not.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.As(err, new(permanentError)) {
+		return false
+	}
+
+	// Implemented by the errors of this package and of the ntfy client
+	var status interface{ HTTPStatus() int }
+	if errors.As(err, &status) {
+		code := status.HTTPStatus()
+		return code == http.StatusRequestTimeout || code == http.StatusTooEarly || code == http.StatusTooManyRequests || code >= 500
+	}
+
+	// SMTP replies: 4xx are transient, 5xx permanent
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+
+	// *url.Error implements net.Error for every failed request, whatever the cause, so the cause decides
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	// Certificates are not fixed by trying again
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) || errors.As(err, new(x509.UnknownAuthorityError)) ||
+		errors.As(err, new(x509.HostnameError)) || errors.As(err, new(x509.CertificateInvalidError)) {
+		return false
+	}
+
+	// An unknown host stays unknown, a failing DNS server may recover
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	// Timeouts, failed dials, reads and writes, refused or reset connections and connections closed mid-response
+	var netErr net.Error
+	var opErr *net.OpError
+	return (errors.As(err, &netErr) && netErr.Timeout()) || errors.As(err, &opErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryPolicy configures how often and how patiently a delivery is retried
+type RetryPolicy struct {
+	Attempts     int           // Including the first try; 1 disables retries
+	InitialDelay time.Duration // Delay before the second attempt, doubled for each further one
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy tries three times over a few seconds; longer outages are left to the outbox
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, InitialDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// Backoff returns the delay before the given retry, counting from 1: exponential growth capped at
+// MaxDelay, with jitter spreading it over its upper half so channels failing together do not retry in lockstep
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// Retrier retries failed deliveries with exponential backoff and jitter as long as the error is retryable
+type Retrier struct {
+	inner  Notifier
+	policy RetryPolicy
+}
+
+// NewRetrier wraps a notifier retrying according to the policy
+func NewRetrier(inner Notifier, policy RetryPolicy) *Retrier {
+	return &Retrier{inner: inner, policy: policy}
+}
+
+// Notify sends the message, retrying until it was delivered, the error is permanent, the attempts are
+// used up or ctx ends
+func (r *Retrier) Notify(ctx context.Context, msg Message) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.inner.Notify(ctx, msg)
+		if err == nil || !Retryable(err) || attempt >= r.policy.Attempts {
+			break
+		}
+
+		timer := time.NewTimer(r.policy.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up retrying: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	if err != nil && r.policy.Attempts > 1 && Retryable(err) {
+		return fmt.Errorf("%w (after %d attempts)", err, r.policy.Attempts)
+	}
+	return err
+}
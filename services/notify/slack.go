@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// SlackNotifier posts messages to a Slack incoming webhook
+type SlackNotifier struct {
+	url    string
+	render *Renderer
+	client *http.Client
+}
+
+// NewSlackNotifier creates a notifier for the incoming webhook URL
+func NewSlackNotifier(url string, render *Renderer, client *http.Client) (*SlackNotifier, error) {
+	if url == "" {
+		return nil, errors.New("slack needs the webhook url")
+	}
+	return &SlackNotifier{url: url, render: render, client: client}, nil
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"` // Shown in desktop and push notifications
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color     string `json:"color"`
+	Title     string `json:"title"`
+	TitleLink string `json:"title_link,omitempty"`
+	Text      string `json:"text"`
+}
+
+// Notify posts the message as a colour-coded attachment linking to the report
+func (n *SlackNotifier) Notify(ctx context.Context, msg Message) error {
+	title, err := n.render.Title(msg)
+	if err != nil {
+		return err
+	}
+	text, err := n.render.Body(msg)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(slackPayload{
+		Text: title,
+		Attachments: []slackAttachment{{
+			Color:     severityColor(msg.Severity),
+			Title:     title,
+			TitleLink: msg.ReportURL,
+			Text:      text,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(ctx, http.MethodPost, n.url, payload)
+	if err != nil {
+		return err
+	}
+	return send(n.client, req)
+}
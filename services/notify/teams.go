@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TeamsNotifier posts messages as Adaptive Cards to a Microsoft Teams workflow or incoming webhook
+type TeamsNotifier struct {
+	url    string
+	render *Renderer
+	client *http.Client
+}
+
+// NewTeamsNotifier creates a notifier for the webhook URL
+func NewTeamsNotifier(url string, render *Renderer, client *http.Client) (*TeamsNotifier, error) {
+	if url == "" {
+		return nil, errors.New("teams needs the webhook url")
+	}
+	return &TeamsNotifier{url: url, render: render, client: client}, nil
+}
+
+// teamsColors maps severities to the colour names Adaptive Cards support
+var teamsColors = map[string]string{
+	"":         "good",
+	"critical": "attention",
+	"high":     "attention",
+	"medium":   "warning",
+}
+
+// Notify posts the message as a card with the summary, optionally the report and a link to the full report.
+// The summary template is rendered in both formats; the full format adds the report in a monospace block.
+func (n *TeamsNotifier) Notify(ctx context.Context, msg Message) error {
+	color, ok := teamsColors[msg.Severity]
+	if !ok {
+		color = "default"
+	}
+	title, err := n.render.Title(msg)
+	if err != nil {
+		return err
+	}
+	summary, err := n.render.Text(FormatSummary, msg)
+	if err != nil {
+		return err
+	}
+
+	cardBody := []map[string]any{
+		{"type": "TextBlock", "text": title, "weight": "bolder", "size": "medium", "color": color, "wrap": true},
+	}
+	// Adaptive Card text blocks only break lines on blank lines
+	for _, line := range strings.Split(summary, "\n") {
+		cardBody = append(cardBody, map[string]any{"type": "TextBlock", "text": line, "wrap": true, "spacing": "none"})
+	}
+	if n.render.Full() && msg.Report != "" {
+		cardBody = append(cardBody, map[string]any{"type": "TextBlock", "text": msg.Report, "fontType": "monospace", "wrap": true})
+	}
+
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    cardBody,
+	}
+	if msg.ReportURL != "" {
+		card["actions"] = []map[string]any{{"type": "Action.OpenUrl", "title": "Open report", "url": msg.ReportURL}}
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(ctx, http.MethodPost, n.url, payload)
+	if err != nil {
+		return err
+	}
+	return send(n.client, req)
+}
@@ -0,0 +1,287 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/tableprinter"
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"unicode/utf8"
+)
+
+// Template parts rendered for each message, in addition to the formats summary and full
+const (
+	PartTitle = "title"
+	PartHTML  = "html"
+)
+
+// Names of the report templates
+const (
+	ReportText = "report.text.tmpl" // Attached to notifications, rendered with ReportData
+	ReportHTML = "report.html.tmpl" // Served by the HTTP API, rendered with the scan run
+)
+
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// Templates renders notification and report content. Message templates are named
+// <scope>.<part>.tmpl, where scope is a channel name, a channel type or "default" and part is title,
+// summary, full or html; the most specific existing one is used. Files ending in .html.tmpl are HTML
+// templates escaping their input, all others are text templates. One trailing newline is dropped
+// from every template so files can end in one.
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// TemplateData is what message templates are executed with: the message plus how much of it to show
+type TemplateData struct {
+	Message
+	Format string
+	Full   bool // The channel's format is full, so the report belongs into the message
+}
+
+// ReportData is what the report.text.tmpl template is executed with
+type ReportData struct {
+	Target string
+	CVEs   []tableprinter.CVEInfo
+	Plan   *remediation.Plan // Nil unless a remediation plan was requested
+}
+
+// SeverityCount is an element of the severityCounts template function's result
+type SeverityCount struct {
+	Severity string
+	Count    int
+}
+
+// templateFuncs are the helper functions available to all templates
+var templateFuncs = map[string]any{
+	"severityColor":  severityColor,
+	"upper":          strings.ToUpper,
+	"lower":          strings.ToLower,
+	"join":           func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"lines":          func(text string) []string { return strings.Split(text, "\n") },
+	"truncate":       truncate,
+	"count":          func(cveList []tableprinter.CVEInfo) int { return len(cveList) },
+	"countSeverity":  countSeverity,
+	"severityCounts": severityCounts,
+	"worstSeverity":  WorstSeverity,
+	"summarize":      Summarize,
+	"table": func(cveList []tableprinter.CVEInfo) string {
+		var b strings.Builder
+		tableprinter.WriteCVEResults(&b, cveList)
+		return b.String()
+	},
+}
+
+// defaultTemplates are parsed once; they are never modified afterwards
+var defaultTemplates = sync.OnceValue(func() *Templates {
+	t := &Templates{text: make(map[string]*texttemplate.Template), html: make(map[string]*htmltemplate.Template)}
+	err := fs.WalkDir(builtinTemplates, "templates", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := builtinTemplates.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return t.parse(entry.Name(), string(content))
+	})
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in template: %v", err))
+	}
+	return t
+})
+
+// DefaultTemplates returns the built-in templates
+func DefaultTemplates() *Templates {
+	return defaultTemplates()
+}
+
+// LoadTemplates returns the built-in templates overridden by the *.tmpl files in dir
+func LoadTemplates(dir string) (*Templates, error) {
+	defaults := DefaultTemplates()
+	t := &Templates{text: make(map[string]*texttemplate.Template), html: make(map[string]*htmltemplate.Template)}
+	for name, tmpl := range defaults.text {
+		t.text[name] = tmpl
+	}
+	for name, tmpl := range defaults.html {
+		t.html[name] = tmpl
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to read templates directory: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		if err := t.parse(filepath.Base(file), string(content)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *Templates) parse(name, content string) error {
+	content = strings.TrimSuffix(content, "\n")
+	if strings.HasSuffix(name, ".html.tmpl") {
+		tmpl, err := htmltemplate.New(name).Funcs(templateFuncs).Parse(content)
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %w", name, err)
+		}
+		t.html[name] = tmpl
+		return nil
+	}
+
+	tmpl, err := texttemplate.New(name).Funcs(templateFuncs).Parse(content)
+	if err != nil {
+		return fmt.Errorf("invalid template %s: %w", name, err)
+	}
+	t.text[name] = tmpl
+	return nil
+}
+
+// Report renders the plain-text report of one target. A nil Templates renders the built-in template.
+func (t *Templates) Report(data ReportData) (string, error) {
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	var b bytes.Buffer
+	if err := t.text[ReportText].Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", ReportText, err)
+	}
+	return b.String(), nil
+}
+
+// HTMLReport writes the HTML report of a scan run to w. A nil Templates renders the built-in template.
+func (t *Templates) HTMLReport(w io.Writer, run any) error {
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	return t.html[ReportHTML].Execute(w, run)
+}
+
+// For returns the renderer of a channel, which looks templates up by the channel's name, then its type.
+// A nil Templates renders the built-in templates.
+func (t *Templates) For(name, channelType, format string) *Renderer {
+	if t == nil {
+		t = DefaultTemplates()
+	}
+	if format == "" {
+		format = FormatSummary
+	}
+	return &Renderer{templates: t, scopes: []string{name, channelType, "default"}, format: format}
+}
+
+// Renderer renders the messages of one channel
+type Renderer struct {
+	templates *Templates
+	scopes    []string
+	format    string
+}
+
+// Full reports whether the channel sends the full report inline
+func (r *Renderer) Full() bool {
+	return r.format == FormatFull
+}
+
+// Title renders the message's title
+func (r *Renderer) Title(msg Message) (string, error) {
+	return r.Text(PartTitle, msg)
+}
+
+// Body renders the message text in the channel's format
+func (r *Renderer) Body(msg Message) (string, error) {
+	return r.Text(r.format, msg)
+}
+
+// Text renders the given part of the message with the most specific text template
+func (r *Renderer) Text(part string, msg Message) (string, error) {
+	for _, scope := range r.scopes {
+		name := scope + "." + part + ".tmpl"
+		if tmpl, ok := r.templates.text[name]; ok {
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, r.data(msg)); err != nil {
+				// Retrying cannot fix a broken template
+				return "", Permanent(fmt.Errorf("failed to render %s: %w", name, err))
+			}
+			return b.String(), nil
+		}
+	}
+	return "", Permanent(fmt.Errorf("no %s template", part))
+}
+
+// HTML renders the message with the most specific HTML template
+func (r *Renderer) HTML(msg Message) (string, error) {
+	for _, scope := range r.scopes {
+		name := scope + "." + PartHTML + ".tmpl"
+		if tmpl, ok := r.templates.html[name]; ok {
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, r.data(msg)); err != nil {
+				return "", Permanent(fmt.Errorf("failed to render %s: %w", name, err))
+			}
+			return b.String(), nil
+		}
+	}
+	return "", Permanent(fmt.Errorf("no %s template", PartHTML))
+}
+
+func (r *Renderer) data(msg Message) TemplateData {
+	return TemplateData{Message: msg, Format: r.format, Full: r.Full()}
+}
+
+// truncate shortens text to at most n bytes including a trailing ellipsis, without splitting characters
+func truncate(n int, text string) string {
+	if len(text) <= n {
+		return text
+	}
+	const ellipsis = "…"
+	if n <= len(ellipsis) {
+		return ""
+	}
+	text = text[:n-len(ellipsis)]
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text + ellipsis
+}
+
+// countSeverity returns how many of the CVEs have the severity
+func countSeverity(severity string, cveList []tableprinter.CVEInfo) int {
+	count := 0
+	for _, cve := range cveList {
+		if strings.EqualFold(cve.Severity, severity) {
+			count++
+		}
+	}
+	return count
+}
+
+// severityCounts returns the number of CVEs per severity, worst first, leaving out severities without any
+func severityCounts(cveList []tableprinter.CVEInfo) []SeverityCount {
+	var counts []SeverityCount
+	for _, severity := range []string{"critical", "high", "medium", "low", "negligible", "unknown"} {
+		count := countSeverity(severity, cveList)
+		if severity == "unknown" {
+			count += countSeverity("", cveList)
+		}
+		if count > 0 {
+			counts = append(counts, SeverityCount{Severity: severity, Count: count})
+		}
+	}
+	return counts
+}
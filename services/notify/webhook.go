@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers set on generic webhook requests
+const (
+	SignatureHeader = "X-CVE-Scanner-Signature" // sha256=<hex HMAC of the timestamp, a dot and the body>
+	TimestampHeader = "X-CVE-Scanner-Timestamp" // Unix seconds, signed to prevent replays
+)
+
+// WebhookNotifier posts messages as JSON to any URL, signed with HMAC-SHA256 when a secret is set
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhookNotifier creates a notifier for the URL
+func NewWebhookNotifier(url, secret string, client *http.Client) (*WebhookNotifier, error) {
+	if url == "" {
+		return nil, errors.New("webhook needs a url")
+	}
+	return &WebhookNotifier{url: url, secret: []byte(secret), client: client, now: time.Now}, nil
+}
+
+// WebhookPayload is the JSON body posted by the generic webhook
+type WebhookPayload struct {
+	Title     string                 `json:"title"`
+	Summary   string                 `json:"summary"`
+	Report    string                 `json:"report,omitempty"`
+	Severity  string                 `json:"severity,omitempty"`
+	RunID     string                 `json:"run_id,omitempty"`
+	ReportURL string                 `json:"report_url,omitempty"`
+	CVEs      []tableprinter.CVEInfo `json:"cves,omitempty"`
+}
+
+// Notify posts the message. Receivers verify it by computing the HMAC of "<timestamp>.<body>".
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(WebhookPayload{
+		Title:     msg.Title,
+		Summary:   msg.Summary,
+		Report:    msg.Report,
+		Severity:  msg.Severity,
+		RunID:     msg.RunID,
+		ReportURL: msg.ReportURL,
+		CVEs:      msg.CVEs,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := newJSONRequest(ctx, http.MethodPost, n.url, payload)
+	if err != nil {
+		return err
+	}
+	if len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(n.now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, timestamp, payload))
+	}
+	return send(n.client, req)
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" as sent in the signature header
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
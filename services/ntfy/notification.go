@@ -0,0 +1,164 @@
+package ntfyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AnthonyHewins/gotfy"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Action button types supported by ntfy
+const (
+	ActionView = "view" // Opens a URL
+	ActionHTTP = "http" // Sends an HTTP request in the background
+)
+
+// Notification is a message using the ntfy features beyond a plain title and body
+type Notification struct {
+	Topic      string // Overrides the client's topic when set
+	Title      string
+	Message    string
+	Priority   gotfy.Priority
+	Tags       []string // Tags matching an emoji short code are shown as emojis
+	ClickURL   string   // Opened when the notification is tapped
+	Actions    []Action
+	Attachment *Attachment // Uploaded as a file, the message is then shown alongside it
+}
+
+// Action is a notification button
+type Action struct {
+	Type    string            `json:"action"`
+	Label   string            `json:"label"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"` // HTTP actions only, ntfy defaults to POST
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Clear   bool              `json:"clear,omitempty"` // Dismiss the notification once the action succeeded
+}
+
+// Attachment is a file sent with a notification
+type Attachment struct {
+	Filename string
+	Content  []byte
+}
+
+// Publish sends the notification to its topic, or the configured one when it has none.
+// gotfy only publishes JSON messages, which cannot carry file uploads, so the message is PUT with
+// its options as headers instead: the body is either the attachment or the message text.
+func (nc *NtfyClient) Publish(ctx context.Context, n Notification) (*gotfy.PublishResp, error) {
+	topic := n.Topic
+	if topic == "" {
+		topic = nc.topic
+	}
+	if topic == "" {
+		return nil, errors.New("topic is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nc.timeout)
+	defer cancel()
+
+	body := []byte(n.Message)
+	if n.Attachment != nil {
+		body = n.Attachment.Content
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, nc.server.JoinPath(topic).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Attachment != nil {
+		setHeader(req, "Filename", n.Attachment.Filename)
+		setHeader(req, "Message", n.Message)
+	}
+	setHeader(req, "Title", n.Title)
+	if n.Priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(int(n.Priority)))
+	}
+	setHeader(req, "Tags", strings.Join(n.Tags, ","))
+	setHeader(req, "Click", n.ClickURL)
+	if len(n.Actions) > 0 {
+		actions, err := json.Marshal(n.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode actions: %w", err)
+		}
+		setHeader(req, "Actions", string(actions))
+	}
+
+	resp, err := nc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var pubResp gotfy.PublishResp
+	if err := json.NewDecoder(resp.Body).Decode(&pubResp); err != nil {
+		return nil, err
+	}
+	return &pubResp, nil
+}
+
+// StatusError is returned when the server answers a publish request with a non-2xx status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("non-200 http response code from server: %d", e.StatusCode)
+}
+
+// HTTPStatus returns the response status code, letting callers tell retryable failures from permanent ones
+func (e *StatusError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// setHeader sets a non-empty header, RFC 2047 encoding values that are not plain ASCII on one line
+// (ntfy decodes them), since emojis or line breaks in a message would otherwise corrupt the request
+func setHeader(req *http.Request, name, value string) {
+	if value == "" {
+		return
+	}
+	req.Header.Set(name, mime.BEncoding.Encode("UTF-8", value))
+}
+
+// PriorityForSeverity maps a vulnerability severity to the ntfy priority of its notification
+func PriorityForSeverity(severity string) gotfy.Priority {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return gotfy.Max
+	case "high":
+		return gotfy.High
+	case "medium":
+		return gotfy.Default
+	case "low":
+		return gotfy.Low
+	}
+	return gotfy.Min
+}
+
+// TagsForSeverity returns the tags of a notification whose worst finding has the given severity:
+// an emoji followed by the severity. An empty severity means nothing was found.
+func TagsForSeverity(severity string) []string {
+	severity = strings.ToLower(severity)
+	switch severity {
+	case "":
+		return []string{"white_check_mark"}
+	case "critical":
+		return []string{"rotating_light", severity}
+	case "high":
+		return []string{"warning", severity}
+	case "medium":
+		return []string{"large_orange_diamond", severity}
+	}
+	return []string{"information_source", severity}
+}
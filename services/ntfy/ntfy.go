@@ -46,6 +46,7 @@ func NewNtfyClient(serverURL, topic, username, password string, timeout time.Dur
 type NtfyService interface {
 	SendMessage(message, title string) (*gotfy.PublishResp, error)
 	SendMessageAsync(message, title string, resultChan chan<- *gotfy.PublishResp, errorChan chan<- error)
+	Publish(ctx context.Context, n Notification) (*gotfy.PublishResp, error)
 }
 
 // Ensure NtfyClient implements the NtfyService interface
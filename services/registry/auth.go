@@ -0,0 +1,244 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dockerHubServer is the key of Docker Hub credentials in config.json and credential helpers
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// Credentials authenticate to a registry, with a password or an identity token
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string // OAuth2 refresh token stored by docker login for some registries
+}
+
+// dockerConfig is the part of docker's config.json holding registry credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"` // base64 of username:password
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`  // Helper storing the credentials of every registry
+	CredHelpers map[string]string `json:"credHelpers"` // Helpers by registry, taking precedence over credsStore
+}
+
+// DockerConfigPath returns where docker keeps its config.json
+func DockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// Credentials looks up the credentials of a registry like docker login stores them: with the credential
+// helper configured for it, the credential store, or in config.json itself. The file is read on every call
+// so logins made while serving are picked up. No credentials and no error are returned when none are stored.
+func (c *Client) Credentials(ctx context.Context, registry string) (Credentials, error) {
+	path := c.config.DockerConfig
+	if path == "" {
+		path = DockerConfigPath()
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || path == "" {
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read docker config: %w", err)
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse docker config %s: %v", path, err)
+	}
+
+	server := registry
+	if registry == dockerHub {
+		server = dockerHubServer
+	}
+	if helper := config.CredHelpers[registry]; helper != "" {
+		return credentialHelper(ctx, helper, server)
+	}
+	if config.CredsStore != "" {
+		return credentialHelper(ctx, config.CredsStore, server)
+	}
+	for key, entry := range config.Auths {
+		if key != server && strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://") != registry {
+			continue
+		}
+		credentials := Credentials{Username: entry.Username, Password: entry.Password, IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return Credentials{}, fmt.Errorf("invalid credentials of %s in docker config: %v", key, err)
+			}
+			credentials.Username, credentials.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return credentials, nil
+	}
+	return Credentials{}, nil
+}
+
+// credentialHelper asks a docker-credential-<helper> program for the credentials of a server
+func credentialHelper(ctx context.Context, helper, server string) (Credentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers report missing credentials on stdout and exit with an error
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return Credentials{}, nil
+		}
+		return Credentials{}, fmt.Errorf("credential helper %s failed: %v: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return Credentials{}, fmt.Errorf("unexpected output of credential helper %s: %v", helper, err)
+	}
+	if response.Username == "<token>" {
+		return Credentials{IdentityToken: response.Secret}, nil
+	}
+	return Credentials{Username: response.Username, Password: response.Secret}, nil
+}
+
+// challengeParam matches the key="value" pairs of a WWW-Authenticate header
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize answers an authentication challenge and returns the Authorization header for the retry
+func (c *Client) authorize(ctx context.Context, registry, scope, challenge string) (string, error) {
+	credentials, err := c.Credentials(ctx, registry)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, paramList, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "basic") {
+		if credentials.Username == "" {
+			return "", fmt.Errorf("registry %s needs credentials, log in with docker login", registry)
+		}
+		authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials.Username+":"+credentials.Password))
+		c.cacheAuthorization(registry, scope, authorization, time.Minute)
+		return authorization, nil
+	}
+	if !strings.EqualFold(scheme, "bearer") {
+		return "", fmt.Errorf("registry %s asks for unsupported authentication %q", registry, challenge)
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(paramList, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry %s sent a bearer challenge without realm", registry)
+	}
+	// Cached under the scope the request needs, the registry may have asked for a different one
+	requested := scope
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+
+	value, validFor, err := c.fetchToken(ctx, params["realm"], params["service"], scope, credentials)
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate to %s: %w", registry, err)
+	}
+	c.cacheAuthorization(registry, requested, "Bearer "+value, validFor)
+	return "Bearer " + value, nil
+}
+
+// fetchToken gets a bearer token from the registry's token service, anonymously when there are no credentials
+func (c *Client) fetchToken(ctx context.Context, realm, service, scope string, credentials Credentials) (string, time.Duration, error) {
+	var req *http.Request
+	var err error
+	if credentials.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {credentials.IdentityToken},
+			"service":       {service},
+			"scope":         {scope},
+			"client_id":     {"docker-sbom"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		query := url.Values{"service": {service}, "scope": {scope}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+		if err == nil && credentials.Username != "" {
+			req.SetBasicAuth(credentials.Username, credentials.Password)
+		}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token service returned %s", resp.Status)
+	}
+	var response struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", 0, fmt.Errorf("failed to parse token: %v", err)
+	}
+	value := response.Token
+	if value == "" {
+		value = response.AccessToken
+	}
+	if value == "" {
+		return "", 0, errors.New("token service returned no token")
+	}
+
+	// Tokens are valid for at least 60 seconds when the service does not say otherwise
+	return value, time.Duration(max(response.ExpiresIn, 60)) * time.Second, nil
+}
+
+// cachedAuthorization returns the Authorization header that last worked for the scope while it is valid
+func (c *Client) cachedAuthorization(registry, scope string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.tokens[registry+" "+scope]
+	if !ok || time.Now().After(cached.expires) {
+		return ""
+	}
+	return cached.value
+}
+
+func (c *Client) cacheAuthorization(registry, scope, authorization string, validFor time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Renewed a little early so requests in flight do not fail
+	c.tokens[registry+" "+scope] = token{value: authorization, expires: time.Now().Add(validFor - 10*time.Second)}
+}
@@ -0,0 +1,388 @@
+package registry
+
+import (
+	"AutomaticCVEResolver/services/version"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Docker Hub is named docker.io in references but served from another host
+const (
+	dockerHub     = "docker.io"
+	dockerHubHost = "registry-1.docker.io"
+)
+
+// manifestTypes are the manifests a tag can point to; indexes come first so multi-platform images are
+// identified by the digest of their index, like docker does
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Config controls how registries are reached
+type Config struct {
+	Insecure       []string `yaml:"insecure"`        // Registries reached over plain HTTP, e.g. localhost:5000
+	DockerConfig   string   `yaml:"docker_config"`   // config.json with the credentials, $DOCKER_CONFIG/config.json or ~/.docker/config.json when empty
+	MaxTags        int      `yaml:"max_tags"`        // Tags a selector may match, selectors matching more are refused
+	TimeoutSeconds int      `yaml:"timeout_seconds"` // Per registry request
+}
+
+// Env returns the environment syft and grype need to reach the registries like the client does. Their
+// plain HTTP setting is not per registry, so it applies to every registry once one is insecure.
+func (c Config) Env() []string {
+	var env []string
+	if len(c.Insecure) > 0 {
+		env = append(env, "SYFT_REGISTRY_INSECURE_USE_HTTP=true", "GRYPE_REGISTRY_INSECURE_USE_HTTP=true")
+	}
+	if c.DockerConfig != "" {
+		env = append(env, "DOCKER_CONFIG="+filepath.Dir(c.DockerConfig))
+	}
+	return env
+}
+
+// Reference names an image in a registry. Tag may be a pattern, see MatchTags.
+type Reference struct {
+	Registry   string // Host and optional port, docker.io for Docker Hub
+	Repository string // e.g. library/nginx
+	Tag        string
+	Digest     string // Set instead of the tag for references pinned to a digest
+}
+
+// ParseReference splits a reference like registry.local/app:1.4 into its parts. References without a
+// registry host are on Docker Hub and those without a tag or digest refer to latest.
+func ParseReference(ref string) (Reference, error) {
+	var r Reference
+	name := ref
+	if before, digest, found := strings.Cut(ref, "@"); found {
+		name, r.Digest = before, digest
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, r.Tag = ref[:i], ref[i+1:]
+	}
+	if name == "" || r.Digest == "" && strings.Contains(ref, "@") {
+		return Reference{}, fmt.Errorf("invalid image reference %q", ref)
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	host, repository, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		r.Registry, r.Repository = host, repository
+	} else {
+		r.Registry, r.Repository = dockerHub, name
+		if !strings.Contains(name, "/") {
+			r.Repository = "library/" + name
+		}
+	}
+	if r.Repository == "" || strings.ToLower(r.Repository) != r.Repository {
+		return Reference{}, fmt.Errorf("invalid repository in image reference %q", ref)
+	}
+	return r, nil
+}
+
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Registry + "/" + r.Repository + "@" + r.Digest
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// WithTag returns the reference to another tag of the repository
+func (r Reference) WithTag(tag string) Reference {
+	r.Tag, r.Digest = tag, ""
+	return r
+}
+
+// IsPattern reports whether the tag selects tags by a glob like 1.4.* or a version constraint like >=1.4 <2
+func (r Reference) IsPattern() bool {
+	return r.Digest == "" && (strings.ContainsAny(r.Tag, "*?[") || isConstraint(r.Tag))
+}
+
+// Client lists the tags of repositories and looks up the digests they point to through the registry API
+type Client struct {
+	config Config
+	http   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]token // Authorization headers by registry and scope
+}
+
+type token struct {
+	value   string // The whole Authorization header
+	expires time.Time
+}
+
+// NewClient creates a client reaching registries as configured
+func NewClient(config Config) *Client {
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	return &Client{config: config, http: &http.Client{Timeout: timeout}, tokens: make(map[string]token)}
+}
+
+// Tags lists the tags of the reference's repository
+func (c *Client) Tags(ctx context.Context, ref Reference) ([]string, error) {
+	var tags []string
+	next := "/v2/" + ref.Repository + "/tags/list"
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, ref, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of %s: %w", ref.Repository, err)
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tags of %s: %v", ref.Repository, err)
+		}
+		tags = append(tags, page.Tags...)
+		next = nextPage(resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// Digest returns the digest of the manifest the reference points to
+func (c *Client) Digest(ctx context.Context, ref Reference) (string, error) {
+	target := ref.Tag
+	if ref.Digest != "" {
+		target = ref.Digest
+	}
+	header := http.Header{"Accept": {strings.Join(manifestTypes, ", ")}}
+	resp, err := c.do(ctx, http.MethodHead, ref, "/v2/"+ref.Repository+"/manifests/"+target, header)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", ref, err)
+	}
+	resp.Body.Close()
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry did not return the digest of %s", ref)
+	}
+	return digest, nil
+}
+
+// Image is a tag of a repository and the digest of the manifest it points to
+type Image struct {
+	Reference Reference
+	Digest    string
+}
+
+// Resolve returns the images a reference selects: every tag matching its pattern or the tag it names, each
+// with its digest. Tags pointing to a digest already selected by another tag are left out.
+func (c *Client) Resolve(ctx context.Context, ref Reference) ([]Image, error) {
+	refs := []Reference{ref}
+	if ref.IsPattern() {
+		tags, err := c.Tags(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		matched, err := MatchTags(tags, ref.Tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no tag of %s/%s matches %q", ref.Registry, ref.Repository, ref.Tag)
+		}
+		if c.config.MaxTags > 0 && len(matched) > c.config.MaxTags {
+			return nil, fmt.Errorf("%d tags of %s/%s match %q, more than the %d allowed by registry.max_tags",
+				len(matched), ref.Registry, ref.Repository, ref.Tag, c.config.MaxTags)
+		}
+		refs = refs[:0]
+		for _, tag := range matched {
+			refs = append(refs, ref.WithTag(tag))
+		}
+	}
+
+	var images []Image
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		digest, err := c.Digest(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[digest] {
+			seen[digest] = true
+			images = append(images, Image{Reference: ref, Digest: digest})
+		}
+	}
+	return images, nil
+}
+
+// do sends a request to the registry API, authenticating when the registry asks for it
+func (c *Client) do(ctx context.Context, method string, ref Reference, apiPath string, header http.Header) (*http.Response, error) {
+	scope := "repository:" + ref.Repository + ":pull"
+	authorization := c.cachedAuthorization(ref.Registry, scope)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL(ref.Registry)+apiPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if authorization, err = c.authorize(ctx, ref.Registry, scope, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return nil, fmt.Errorf("registry returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) baseURL(registry string) string {
+	scheme := "https"
+	if slices.Contains(c.config.Insecure, registry) {
+		scheme = "http"
+	}
+	if registry == dockerHub {
+		registry = dockerHubHost
+	}
+	return scheme + "://" + registry
+}
+
+// linkPattern extracts the next page from a Link header like </v2/app/tags/list?last=1.4&n=100>; rel="next"
+var linkPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+func nextPage(link string) string {
+	match := linkPattern.FindStringSubmatch(link)
+	if match == nil {
+		return ""
+	}
+	// Registries return the next page as a path, some as an absolute URL
+	if u, err := url.Parse(match[1]); err == nil && u.IsAbs() {
+		return u.RequestURI()
+	}
+	return match[1]
+}
+
+// MatchTags returns the tags selected by pattern, ordered by version and followed by tags that are none. A pattern is a glob like 1.4.* or
+// a version constraint: space-separated comparisons that must all hold, e.g. ">=1.4.0-rc1 <2". Constraints
+// only select tags that are versions, with or without a leading v; other patterns select the tag they name.
+func MatchTags(tags []string, pattern string) ([]string, error) {
+	var matched []string
+	switch {
+	case isConstraint(pattern):
+		constraints, err := parseConstraint(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			if isVersion(tag) && satisfies(tag, constraints) {
+				matched = append(matched, tag)
+			}
+		}
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %v", pattern, err)
+		}
+		for _, tag := range tags {
+			if ok, _ := path.Match(pattern, tag); ok {
+				matched = append(matched, tag)
+			}
+		}
+	default:
+		if slices.Contains(tags, pattern) {
+			matched = append(matched, pattern)
+		}
+	}
+	// Versions first, so they are kept over tags like latest pointing to the same image
+	slices.SortFunc(matched, func(a, b string) int {
+		switch {
+		case isVersion(a) != isVersion(b) && isVersion(a):
+			return -1
+		case isVersion(a) != isVersion(b):
+			return 1
+		case !isVersion(a):
+			return strings.Compare(a, b)
+		}
+		return version.Compare(strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v"))
+	})
+	return matched, nil
+}
+
+type comparison struct {
+	operator string
+	version  string
+}
+
+// operators are ordered so the longer ones are tried first
+var operators = []string{">=", "<=", "!=", ">", "<", "="}
+
+func isConstraint(pattern string) bool {
+	return strings.ContainsAny(pattern, "<>=!") || strings.Contains(pattern, " ")
+}
+
+func parseConstraint(pattern string) ([]comparison, error) {
+	var constraints []comparison
+	for _, term := range strings.Fields(pattern) {
+		i := slices.IndexFunc(operators, func(operator string) bool { return strings.HasPrefix(term, operator) })
+		if i < 0 || !isVersion(term[len(operators[i]):]) {
+			return nil, fmt.Errorf("invalid version constraint %q, expected terms like >=1.4 or <2", term)
+		}
+		constraints = append(constraints, comparison{operator: operators[i], version: term[len(operators[i]):]})
+	}
+	if len(constraints) == 0 {
+		return nil, errors.New("empty version constraint")
+	}
+	return constraints, nil
+}
+
+func satisfies(tag string, constraints []comparison) bool {
+	for _, constraint := range constraints {
+		c := version.Compare(strings.TrimPrefix(tag, "v"), strings.TrimPrefix(constraint.version, "v"))
+		var ok bool
+		switch constraint.operator {
+		case ">=":
+			ok = c >= 0
+		case "<=":
+			ok = c <= 0
+		case "!=":
+			ok = c != 0
+		case ">":
+			ok = c > 0
+		case "<":
+			ok = c < 0
+		case "=":
+			ok = c == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isVersion reports whether a tag starts like a version, e.g. 1.4, v2 or 1.5.0-rc1
+func isVersion(tag string) bool {
+	tag = strings.TrimPrefix(tag, "v")
+	return tag != "" && tag[0] >= '0' && tag[0] <= '9'
+}
@@ -0,0 +1,314 @@
+package remediation
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"AutomaticCVEResolver/services/version"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Kinds of fix actions the planner can suggest
+const (
+	ActionUpgradePackage = "upgrade-package"
+	ActionBumpBaseImage  = "bump-base-image"
+)
+
+// Supported plan output formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatText  = "text"
+)
+
+// osPackageTypes are the grype artifact types installed by the base image's package manager
+var osPackageTypes = map[string]bool{"apk": true, "deb": true, "rpm": true}
+
+// Releases names the newest stable release of each distribution base images are built on, which base image
+// bumps target. Images on other distributions, or already on these releases, get no bump action.
+type Releases struct {
+	Latest map[string]string `json:"latest"`
+	Source string            `json:"source"` // Where the table comes from, named in the plan output
+}
+
+// DefaultReleases is the built-in table. It goes stale with every new distribution release, which the
+// remediation.latest_releases setting catches up with.
+var DefaultReleases = Releases{
+	Latest: map[string]string{
+		"alpine":      "3.22",
+		"debian":      "13",
+		"ubuntu":      "24.04",
+		"almalinux":   "10",
+		"rockylinux":  "10",
+		"amazonlinux": "2023",
+	},
+	Source: "built-in",
+}
+
+// String lists the releases, e.g. "built-in: alpine 3.22, debian 13"
+func (r Releases) String() string {
+	latest := make([]string, 0, len(r.Latest))
+	for _, name := range slices.Sorted(maps.Keys(r.Latest)) {
+		latest = append(latest, name+" "+r.Latest[name])
+	}
+	return r.Source + ": " + strings.Join(latest, ", ")
+}
+
+// severityWeights expresses how much closing a CVE of a given severity reduces risk
+var severityWeights = map[string]float64{
+	"critical":   10,
+	"high":       5,
+	"medium":     2,
+	"low":        1,
+	"negligible": 0.5,
+}
+
+// Action is a single fix step together with the CVEs it would close
+type Action struct {
+	Kind           string                 `json:"kind"`
+	Target         string                 `json:"target"` // Package name or base distribution
+	FromVersion    string                 `json:"from_version"`
+	ToVersion      string                 `json:"to_version,omitempty"`
+	CVEs           []tableprinter.CVEInfo `json:"cves"`
+	SeverityCounts map[string]int         `json:"severity_counts"`
+	RiskReduction  float64                `json:"risk_reduction"` // Of the CVEs not closed by the actions ranked above
+	Effort         int                    `json:"effort"`
+	Score          float64                `json:"score"` // Risk reduction per unit of effort, used for ranking
+}
+
+// Summary describes the action in one line, e.g. "upgrade openssl 3.0.8→3.0.13 (closes 14 CVEs, 2 critical)"
+func (a Action) Summary() string {
+	var verb string
+	switch a.Kind {
+	case ActionBumpBaseImage:
+		verb = fmt.Sprintf("bump base %s→%s", a.Target, a.ToVersion)
+	default:
+		verb = fmt.Sprintf("upgrade %s %s→%s", a.Target, a.FromVersion, a.ToVersion)
+	}
+
+	closes := fmt.Sprintf("closes %d CVEs", len(a.CVEs))
+	if len(a.CVEs) == 1 {
+		closes = "closes 1 CVE"
+	}
+	if critical := a.SeverityCounts["critical"]; critical > 0 {
+		closes += fmt.Sprintf(", %d critical", critical)
+	}
+	return fmt.Sprintf("%s (%s)", verb, closes)
+}
+
+// Plan is a ranked list of fix actions for one container or image
+type Plan struct {
+	Actions   []Action               `json:"actions"`
+	Unfixable []tableprinter.CVEInfo `json:"unfixable"` // CVEs without a known fixed version
+	Releases  Releases               `json:"releases"`  // The latest distribution releases base image bumps were offered for
+}
+
+// BuildPlan groups CVEs into fix actions and ranks them by risk reduction per effort.
+// Every CVE with a known fix is covered by exactly one package upgrade; when the image is
+// built on an older release of a distribution in releases, a bump to the latest
+// release covering all OS package CVEs is offered as an alternative action. Each CVE
+// only counts towards the highest ranked action closing it.
+func BuildPlan(cveList []tableprinter.CVEInfo, releases Releases) *Plan {
+	plan := &Plan{Releases: releases}
+	groups := make(map[string]*Action)
+	var order []string
+	var osCVEs []tableprinter.CVEInfo
+	distro := ""
+
+	for _, cve := range cveList {
+		if cve.ResolvedVersion == "" {
+			plan.Unfixable = append(plan.Unfixable, cve)
+			continue
+		}
+
+		key := cve.PackageType + "/" + cve.Package + "@" + cve.CurrentVersion
+		action, ok := groups[key]
+		if !ok {
+			action = &Action{
+				Kind:        ActionUpgradePackage,
+				Target:      cve.Package,
+				FromVersion: cve.CurrentVersion,
+			}
+			groups[key] = action
+			order = append(order, key)
+		}
+		action.ToVersion = version.Max(action.ToVersion, cve.ResolvedVersion)
+		// grype lists a CVE once per location of the package
+		if slices.ContainsFunc(action.CVEs, func(listed tableprinter.CVEInfo) bool { return listed.CVEName == cve.CVEName }) {
+			continue
+		}
+		action.CVEs = append(action.CVEs, cve)
+
+		if osPackageTypes[cve.PackageType] && cve.Distro != "" {
+			osCVEs = append(osCVEs, cve)
+			distro = cve.Distro
+		}
+	}
+
+	for _, key := range order {
+		action := groups[key]
+		action.Effort = 1
+		if version.Major(action.ToVersion) > version.Major(action.FromVersion) {
+			// Major version bumps usually need code or configuration changes
+			action.Effort = 3
+		}
+		plan.Actions = append(plan.Actions, countSeverities(*action))
+	}
+
+	// A base image bump only pays off when it replaces several package upgrades
+	if len(osCVEs) > 0 && countPackages(osCVEs) > 1 {
+		if name, release, ok := upgradableRelease(distro, releases.Latest); ok {
+			plan.Actions = append(plan.Actions, countSeverities(Action{
+				Kind:        ActionBumpBaseImage,
+				Target:      name + ":" + release,
+				FromVersion: distro,
+				ToVersion:   releases.Latest[name],
+				CVEs:        osCVEs,
+				Effort:      2,
+			}))
+		}
+	}
+
+	plan.Actions = rank(plan.Actions)
+	return plan
+}
+
+// rank orders the actions greedily by the risk each reduces per effort beyond the actions ranked before it,
+// so a CVE closed both by a package upgrade and by the base image bump is only counted once
+func rank(actions []Action) []Action {
+	closed := make(map[string]bool)
+	ranked := make([]Action, 0, len(actions))
+	for len(actions) > 0 {
+		best, bestRisk := 0, -1.0
+		for i, action := range actions {
+			risk := 0.0
+			for _, cve := range action.CVEs {
+				if !closed[cveKey(cve)] {
+					risk += severityWeights[strings.ToLower(cve.Severity)]
+				}
+			}
+			if bestRisk < 0 || risk/float64(action.Effort) > bestRisk/float64(actions[best].Effort) {
+				best, bestRisk = i, risk
+			}
+		}
+
+		action := actions[best]
+		action.RiskReduction = bestRisk
+		action.Score = bestRisk / float64(action.Effort)
+		for _, cve := range action.CVEs {
+			closed[cveKey(cve)] = true
+		}
+		ranked = append(ranked, action)
+		actions = slices.Delete(actions, best, best+1)
+	}
+	return ranked
+}
+
+// cveKey identifies a finding: the same CVE in two packages needs both to be fixed
+func cveKey(cve tableprinter.CVEInfo) string {
+	return cve.CVEName + " " + cve.Package
+}
+
+// Write renders the plan in the given format
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	case FormatText:
+		_, err := io.WriteString(w, p.String())
+		return err
+	case FormatTable, "":
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.Debug)
+		fmt.Fprintln(writer, "Rank\tAction\tCVEs\tCritical\tHigh\tEffort\tScore")
+		for i, action := range p.Actions {
+			fmt.Fprintf(writer, "%d\t%s\t%d\t%d\t%d\t%d\t%.1f\n",
+				i+1,
+				action.Summary(),
+				len(action.CVEs),
+				action.SeverityCounts["critical"],
+				action.SeverityCounts["high"],
+				action.Effort,
+				action.Score,
+			)
+		}
+		if len(p.Unfixable) > 0 {
+			fmt.Fprintf(writer, "-\tno fix available\t%d\t%d\t%d\t-\t-\n",
+				len(p.Unfixable), countSeverity(p.Unfixable, "critical"), countSeverity(p.Unfixable, "high"))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "Latest distribution releases (%s)\n", p.Releases)
+		return err
+	}
+	return fmt.Errorf("unsupported plan format %q", format)
+}
+
+// String renders the plan as a numbered list, suitable for notifications
+func (p *Plan) String() string {
+	var b strings.Builder
+	for i, action := range p.Actions {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, action.Summary())
+	}
+	if len(p.Unfixable) > 0 {
+		fmt.Fprintf(&b, "%d CVEs have no fix available yet\n", len(p.Unfixable))
+	}
+	if p.bumpsBaseImage() {
+		fmt.Fprintf(&b, "Latest distribution releases (%s)\n", p.Releases)
+	}
+	return b.String()
+}
+
+// bumpsBaseImage reports whether the plan offers a base image bump, which depends on the releases known
+func (p *Plan) bumpsBaseImage() bool {
+	return slices.ContainsFunc(p.Actions, func(action Action) bool { return action.Kind == ActionBumpBaseImage })
+}
+
+// countSeverities fills in the severity counts of the action's CVEs; it is scored by rank
+func countSeverities(action Action) Action {
+	action.SeverityCounts = make(map[string]int)
+	for _, cve := range action.CVEs {
+		action.SeverityCounts[strings.ToLower(cve.Severity)]++
+	}
+	return action
+}
+
+func countPackages(cveList []tableprinter.CVEInfo) int {
+	packages := make(map[string]bool)
+	for _, cve := range cveList {
+		packages[cve.Package] = true
+	}
+	return len(packages)
+}
+
+func countSeverity(cveList []tableprinter.CVEInfo, severity string) int {
+	count := 0
+	for _, cve := range cveList {
+		if strings.EqualFold(cve.Severity, severity) {
+			count++
+		}
+	}
+	return count
+}
+
+// upgradableRelease trims a distribution version down to its release, e.g. alpine:3.18.4 -> alpine 3.18,
+// and reports whether a later release is known to bump to
+func upgradableRelease(distro string, latestReleases map[string]string) (name, release string, ok bool) {
+	name, release, found := strings.Cut(distro, ":")
+	latest, known := latestReleases[name]
+	if !found || !known {
+		return "", "", false
+	}
+	parts := strings.Split(release, ".")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	release = strings.Join(parts, ".")
+	return name, release, version.Compare(release, latest) < 0
+}
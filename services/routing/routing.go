@@ -0,0 +1,279 @@
+package routing
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ComposeProjectLabel is the container label docker compose records the project name in
+const ComposeProjectLabel = "com.docker.compose.project"
+
+// Config is the content of the routing rules file
+type Config struct {
+	Rules   []Rule `yaml:"rules"`
+	Default Route  `yaml:"default"` // Used when no rule matches
+}
+
+// Rule sends the findings matching all of its conditions to a route. Empty conditions match everything.
+type Rule struct {
+	Name            string            `yaml:"name"`
+	Images          []string          `yaml:"images"`           // Globs on the image reference; * also matches slashes
+	Labels          map[string]string `yaml:"labels"`           // Container labels that must all match; * only requires the label
+	ComposeProjects []string          `yaml:"compose_projects"` // Compose project names, also globs
+	MinSeverity     string            `yaml:"min_severity"`     // Worst finding must be at least this severe
+	Route           `yaml:",inline"`
+	Continue        bool `yaml:"continue"` // Also evaluate later rules after this one matched
+}
+
+// Route is where matching findings are sent
+type Route struct {
+	Channels []string `yaml:"channels"`
+	Topic    string   `yaml:"topic"`    // Overrides the topic of ntfy channels
+	Fallback []string `yaml:"fallback"` // Channels tried when delivery to every channel of the route failed
+}
+
+// Subject describes what a notification is about
+type Subject struct {
+	Image       string
+	ContainerID string
+	Labels      map[string]string
+	Severity    string // Worst severity of the findings, empty for status messages
+}
+
+// Match is a route selected for a subject together with the rule that selected it
+type Match struct {
+	Rule string // "default" when no rule matched
+	Route
+}
+
+// compiledRule is a rule with its globs turned into regular expressions
+type compiledRule struct {
+	Rule
+	images   []*regexp.Regexp
+	projects []*regexp.Regexp
+}
+
+type compiledConfig struct {
+	rules      []compiledRule
+	fallback   Route
+	usesLabels bool
+}
+
+// Router selects the routes of notifications. Its rules can be replaced while it is in use.
+type Router struct {
+	channels map[string]bool
+	current  atomic.Pointer[compiledConfig]
+}
+
+// NewRouter creates a router for the given rules. Routes may only name the given channels.
+func NewRouter(config Config, channels []string) (*Router, error) {
+	r := &Router{channels: make(map[string]bool, len(channels))}
+	for _, channel := range channels {
+		r.channels[channel] = true
+	}
+	if err := r.Reload(config); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRouter creates a router from a rules file
+func LoadRouter(path string, channels []string) (*Router, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRouter(config, channels)
+}
+
+// LoadConfig reads a rules file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read routing rules: %w", err)
+	}
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse routing rules: %w", err)
+	}
+	return config, nil
+}
+
+// Reload validates the rules and, when they are valid, replaces the current ones
+func (r *Router) Reload(config Config) error {
+	compiled, err := r.compile(config)
+	if err != nil {
+		return err
+	}
+	r.current.Store(compiled)
+	return nil
+}
+
+// Check validates the rules without replacing the current ones
+func (r *Router) Check(config Config) error {
+	_, err := r.compile(config)
+	return err
+}
+
+func (r *Router) compile(config Config) (*compiledConfig, error) {
+	compiled := &compiledConfig{fallback: config.Default}
+	if err := r.checkRoute("default", config.Default); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.MinSeverity != "" && notify.SeverityRank(rule.MinSeverity) == 0 {
+			return nil, fmt.Errorf("%s: unknown severity %q", rule.Name, rule.MinSeverity)
+		}
+		if err := r.checkRoute(rule.Name, rule.Route); err != nil {
+			return nil, err
+		}
+
+		c := compiledRule{Rule: rule}
+		for _, glob := range rule.Images {
+			c.images = append(c.images, compileGlob(glob))
+		}
+		for _, glob := range rule.ComposeProjects {
+			c.projects = append(c.projects, compileGlob(glob))
+		}
+		if len(rule.Labels) > 0 || len(rule.ComposeProjects) > 0 {
+			compiled.usesLabels = true
+		}
+		compiled.rules = append(compiled.rules, c)
+	}
+	return compiled, nil
+}
+
+func (r *Router) checkRoute(name string, route Route) error {
+	for _, channel := range append(append([]string(nil), route.Channels...), route.Fallback...) {
+		if !r.channels[channel] {
+			return fmt.Errorf("%s: unknown channel %q", name, channel)
+		}
+	}
+	return nil
+}
+
+// NeedsLabels reports whether any rule matches on container labels, which then have to be looked up
+func (r *Router) NeedsLabels() bool {
+	return r.current.Load().usesLabels
+}
+
+// Route returns the routes of the first matching rule, plus those of later matching rules while the
+// matched rules ask to continue. Without any matching rule the default route is returned.
+func (r *Router) Route(subject Subject) []Match {
+	config := r.current.Load()
+
+	var matches []Match
+	for _, rule := range config.rules {
+		if !rule.matches(subject) {
+			continue
+		}
+		matches = append(matches, Match{Rule: rule.Name, Route: rule.Route})
+		if !rule.Continue {
+			break
+		}
+	}
+	if len(matches) == 0 {
+		matches = append(matches, Match{Rule: "default", Route: config.fallback})
+	}
+	return matches
+}
+
+func (rule compiledRule) matches(subject Subject) bool {
+	if rule.MinSeverity != "" && notify.SeverityRank(subject.Severity) < notify.SeverityRank(rule.MinSeverity) {
+		return false
+	}
+	if len(rule.images) > 0 && !matchAny(rule.images, subject.Image) {
+		return false
+	}
+	if len(rule.projects) > 0 {
+		project, ok := subject.Labels[ComposeProjectLabel]
+		if !ok || !matchAny(rule.projects, project) {
+			return false
+		}
+	}
+	for key, want := range rule.Labels {
+		value, ok := subject.Labels[key]
+		if !ok || (want != "*" && value != want) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(globs []*regexp.Regexp, value string) bool {
+	for _, glob := range globs {
+		if glob.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileGlob turns a glob with * (any characters) and ? (one character) into an anchored regular expression
+func compileGlob(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Watch reloads the rules file whenever its modification time changes, until ctx is cancelled.
+// Invalid rules are logged and the previous ones stay in effect.
+func (r *Router) Watch(ctx context.Context, path string, interval time.Duration, logger *slog.Logger) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			logger.Warn("Failed to check routing rules", "path", path, "error", err)
+			continue
+		}
+		if info.ModTime().Equal(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+
+		config, err := LoadConfig(path)
+		if err == nil {
+			err = r.Reload(config)
+		}
+		if err != nil {
+			logger.Error("Ignoring invalid routing rules", "path", path, "error", err)
+			continue
+		}
+		logger.Info("Reloaded routing rules", "path", path, "rules", len(config.Rules))
+	}
+}
@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"AutomaticCVEResolver/services/logging"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Job is a named task that runs on a cron schedule
+type Job struct {
+	Name       string
+	Schedule   *CronSchedule
+	Jitter     time.Duration // Upper bound of a random delay added to every activation
+	RunOnStart bool          // Run once immediately when the scheduler starts
+	Run        func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their schedules until its context is cancelled.
+// Runs of the same job never overlap: activations that fire while the previous
+// run is still in progress are skipped.
+type Scheduler struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	jobs    []Job
+	ctx     context.Context               // Set by Start, runs of all jobs get it
+	loops   map[string]context.CancelFunc // Stop the activation loops of the started jobs
+	running map[string]bool               // Jobs with a run in progress
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates an empty Scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{logger: slog.Default(), loops: make(map[string]context.CancelFunc), running: make(map[string]bool)}
+}
+
+// SetLogger replaces the default logger
+func (s *Scheduler) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// AddJob registers a job with the scheduler before it is started
+func (s *Scheduler) AddJob(job Job) error {
+	if err := validateJob(job); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// ValidateJobs checks jobs meant to replace the current ones without replacing them
+func ValidateJobs(jobs []Job) error {
+	names := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if err := validateJob(job); err != nil {
+			return err
+		}
+		if names[job.Name] {
+			return fmt.Errorf("duplicate job %s", job.Name)
+		}
+		names[job.Name] = true
+	}
+	return nil
+}
+
+func validateJob(job Job) error {
+	if job.Name == "" {
+		return errors.New("job name is not set")
+	}
+	if job.Schedule == nil {
+		return fmt.Errorf("job %s has no schedule", job.Name)
+	}
+	if job.Run == nil {
+		return fmt.Errorf("job %s has no run function", job.Name)
+	}
+	return nil
+}
+
+// Run starts all jobs and blocks until ctx is cancelled and every in-flight run has returned.
+// In-flight runs receive the cancelled context, so they are expected to stop promptly.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	empty := len(s.jobs) == 0
+	s.mu.Unlock()
+	if empty {
+		return errors.New("no jobs scheduled")
+	}
+
+	s.Start(ctx)
+	s.Wait()
+	return nil
+}
+
+// Start starts the jobs without blocking. Jobs may also be added later with Replace.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, job := range s.jobs {
+		s.start(job, job.RunOnStart)
+	}
+}
+
+// Wait blocks until the jobs of a started scheduler have stopped and their in-flight runs returned
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Replace swaps all jobs for the given ones, which are validated first; on error nothing changes.
+// Runs in progress are not interrupted, and a replaced job skips its activations until its run has
+// finished. Jobs do not run on start again when replaced.
+func (s *Scheduler) Replace(jobs []Job) error {
+	if err := ValidateJobs(jobs); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = jobs
+	if s.ctx == nil {
+		return nil
+	}
+	for name, stop := range s.loops {
+		stop()
+		delete(s.loops, name)
+	}
+	for _, job := range jobs {
+		s.start(job, false)
+	}
+	return nil
+}
+
+// start runs the activation loop of a job; s.mu must be held
+func (s *Scheduler) start(job Job, runOnStart bool) {
+	loopCtx, stop := context.WithCancel(s.ctx)
+	s.loops[job.Name] = stop
+	runCtx := s.ctx
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer stop()
+		s.runJob(loopCtx, runCtx, job, runOnStart)
+	}()
+}
+
+// runJob waits for the job's activations until loopCtx is cancelled and runs the job with runCtx,
+// so stopping the loop when the job is replaced does not cancel a run in progress
+func (s *Scheduler) runJob(loopCtx, runCtx context.Context, job Job, runOnStart bool) {
+	if runOnStart {
+		s.execute(runCtx, job)
+	}
+
+	for {
+		now := time.Now()
+		next := job.Schedule.Next(now)
+		if next.IsZero() {
+			s.logger.Warn("Job has no future activations, stopping it", "job", job.Name)
+			return
+		}
+
+		delay := next.Sub(now)
+		if job.Jitter > 0 {
+			delay += rand.N(job.Jitter)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-loopCtx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.execute(runCtx, job)
+	}
+}
+
+// execute runs the job inline, so the next activation is only computed once this run
+// has finished and any activations missed in the meantime are skipped
+func (s *Scheduler) execute(ctx context.Context, job Job) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	logger := s.logger.With("job", job.Name)
+	s.mu.Lock()
+	if s.running[job.Name] {
+		// Only happens right after the job was replaced
+		s.mu.Unlock()
+		logger.Warn("Skipping activation, the previous run is still in progress")
+		return
+	}
+	s.running[job.Name] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.Name)
+		s.mu.Unlock()
+	}()
+
+	ctx = logging.WithLogger(ctx, logger)
+
+	started := time.Now()
+	logger.Info("Starting scheduled job")
+	if err := job.Run(ctx); err != nil {
+		logger.Error("Scheduled job failed", "error", err)
+	}
+	if missed := countActivations(job.Schedule, started, time.Now()); missed > 0 {
+		logger.Warn("Scheduled job overran and skipped activations", "skipped", missed)
+	}
+}
+
+func countActivations(schedule *CronSchedule, from, to time.Time) int {
+	count := 0
+	for t := schedule.Next(from); !t.IsZero() && !t.After(to); t = schedule.Next(t) {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"sort"
+)
+
+// Finding is a CVE of one scanned target
+type Finding struct {
+	Target string `json:"target"`
+	tableprinter.CVEInfo
+}
+
+// RunDiff lists the findings that appeared and disappeared between two runs
+type RunDiff struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	New   []Finding `json:"new"`
+	Fixed []Finding `json:"fixed"`
+}
+
+// Diff compares the findings of run from with those of the later run to. Findings are matched by
+// target, CVE and package, so a CVE still present after a package upgrade counts as neither new nor fixed.
+func Diff(from, to Run) RunDiff {
+	diff := RunDiff{From: from.ID, To: to.ID, New: []Finding{}, Fixed: []Finding{}}
+	before, after := findings(from), findings(to)
+	for key, finding := range after {
+		if _, ok := before[key]; !ok {
+			diff.New = append(diff.New, finding)
+		}
+	}
+	for key, finding := range before {
+		if _, ok := after[key]; !ok {
+			diff.Fixed = append(diff.Fixed, finding)
+		}
+	}
+	sortFindings(diff.New)
+	sortFindings(diff.Fixed)
+	return diff
+}
+
+type findingKey struct {
+	target, cve, pkg string
+}
+
+func findings(run Run) map[findingKey]Finding {
+	byKey := make(map[findingKey]Finding)
+	for target, cveList := range run.CVEs {
+		for _, cve := range cveList {
+			byKey[findingKey{target, cve.CVEName, cve.Package}] = Finding{Target: target, CVEInfo: cve}
+		}
+	}
+	return byKey
+}
+
+func sortFindings(list []Finding) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Target != list[j].Target {
+			return list[i].Target < list[j].Target
+		}
+		if list[i].CVEName != list[j].CVEName {
+			return list[i].CVEName < list[j].CVEName
+		}
+		return list[i].Package < list[j].Package
+	})
+}
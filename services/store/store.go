@@ -0,0 +1,415 @@
+package store
+
+import (
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scan run states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Run is a single scan job together with its results
+type Run struct {
+	ID          string                            `json:"id"`
+	Target      string                            `json:"target"`
+	Trigger     string                            `json:"trigger"` // cli, schedule, event or api
+	Status      string                            `json:"status"`
+	Error       string                            `json:"error,omitempty"`
+	CreatedAt   time.Time                         `json:"created_at"`
+	StartedAt   time.Time                         `json:"started_at,omitempty"`
+	FinishedAt  time.Time                         `json:"finished_at,omitempty"`
+	SBOMs       map[string]string                 `json:"sboms,omitempty"` // Only kept in memory when the store has no directory
+	SBOMTargets []string                          `json:"sbom_targets,omitempty"`
+	CVEs        map[string][]tableprinter.CVEInfo `json:"cves,omitempty"`
+	Cancelled   map[string]string                 `json:"cancelled,omitempty"` // Targets that timed out or were cancelled, with the reason
+
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string    `json:"acknowledged_by,omitempty"`
+	Alert          *Alert    `json:"alert,omitempty"` // Set when the findings raised an alert
+}
+
+// Alert tracks the reminders and escalation of a run whose findings have to be acknowledged
+type Alert struct {
+	Severity       string    `json:"severity"` // Worst severity of the findings
+	RaisedAt       time.Time `json:"raised_at"`
+	LastNotifiedAt time.Time `json:"last_notified_at"`
+	Reminders      int       `json:"reminders,omitempty"`
+	EscalatedAt    time.Time `json:"escalated_at,omitempty"`
+	Targets        []string  `json:"targets,omitempty"`       // Scanned targets whose findings raised the alert and were not scanned again since
+	SupersededBy   string    `json:"superseded_by,omitempty"` // The later run that scanned the last of the targets again
+}
+
+// Active reports whether the run's alert still needs attention
+func (r Run) Active() bool {
+	return r.Alert != nil && r.AcknowledgedAt.IsZero() && r.Alert.SupersededBy == ""
+}
+
+// AlertTargets returns the scanned targets the run's alert is about. Alerts saved before they
+// recorded their targets cover every target of the run.
+func (r Run) AlertTargets() []string {
+	if r.Alert == nil {
+		return nil
+	}
+	if r.Alert.Targets != nil {
+		return r.Alert.Targets
+	}
+	targets := make([]string, 0, len(r.CVEs))
+	for target := range r.CVEs {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+	return targets
+}
+
+// Store keeps scan runs in memory and, when a directory is configured, persists each run as a JSON file.
+// The SBOMs of persisted runs are written to files of their own and only read when asked for.
+type Store struct {
+	dir     string
+	mu      sync.RWMutex
+	runs    map[string]*Run
+	maxRuns int
+	maxAge  time.Duration
+	logger  *slog.Logger
+}
+
+// NewStore creates a Store persisting runs to dir and loads the runs already saved there.
+// Runs still queued or running were interrupted by a crash or restart and are marked as failed.
+// An empty dir keeps runs in memory only.
+func NewStore(dir string) (*Store, error) {
+	s := &Store{dir: dir, runs: make(map[string]*Run), logger: slog.Default()}
+	if dir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scan run %s: %w", file, err)
+		}
+		var run Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to parse scan run %s: %w", file, err)
+		}
+		s.runs[run.ID] = &run
+
+		changed := false
+		if run.Status == StatusQueued || run.Status == StatusRunning {
+			run.Status = StatusFailed
+			run.Error = "interrupted, the scanner stopped before the run finished"
+			run.FinishedAt = time.Now().UTC()
+			changed = true
+		}
+		// Runs saved before SBOMs got files of their own
+		if len(run.SBOMs) > 0 {
+			saved, err := s.saveSBOMs(run.ID, run.SBOMs)
+			if err != nil {
+				return nil, err
+			}
+			s.setSBOMs(&run, run.SBOMs, saved)
+			changed = true
+		}
+		if changed {
+			if err := s.save(&run); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// SetRetention limits the runs kept to the newest maxRuns and those younger than maxAge; zero disables a limit.
+// Runs in progress and runs with an active alert are always kept. Older runs are removed right away.
+func (s *Store) SetRetention(maxRuns int, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxRuns = maxRuns
+	s.maxAge = maxAge
+	return s.prune()
+}
+
+// SetLogger sets the logger reporting runs removed by the retention limits
+func (s *Store) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// Create registers a new queued run for the target and returns a copy of it
+func (s *Store) Create(target, trigger string) (Run, error) {
+	id, err := newID()
+	if err != nil {
+		return Run{}, err
+	}
+
+	run := &Run{
+		ID:        id,
+		Target:    target,
+		Trigger:   trigger,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[id] = run
+	if err := s.save(run); err != nil {
+		return *run, err
+	}
+	return *run, s.prune()
+}
+
+// Update applies fn to the run with the given id and persists the result
+func (s *Store) Update(id string, fn func(run *Run)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return fmt.Errorf("scan run %s not found", id)
+	}
+	fn(run)
+	return s.save(run)
+}
+
+// Get returns a copy of the run with the given id
+func (s *Store) Get(id string) (Run, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return *run, true
+}
+
+// SBOM returns the SBOM of a target of the run, reading it from its file for persisted runs
+func (s *Store) SBOM(id, target string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return "", false, nil
+	}
+	if sbom, ok := run.SBOMs[target]; ok {
+		return sbom, true, nil
+	}
+	if s.dir == "" || !slices.Contains(run.SBOMTargets, target) {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(s.sbomPath(id, target))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read SBOM of %s in scan run %s: %w", target, id, err)
+	}
+	return string(data), true, nil
+}
+
+// List returns all runs, newest first
+func (s *Store) List() []Run {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sorted()
+}
+
+func (s *Store) sorted() []Run {
+	runs := make([]Run, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, *run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	return runs
+}
+
+// Acknowledge records that someone has seen the findings of the run. Only the first acknowledgement is kept.
+func (s *Store) Acknowledge(id, by string) (Run, error) {
+	var acknowledged Run
+	err := s.Update(id, func(run *Run) {
+		if run.AcknowledgedAt.IsZero() {
+			run.AcknowledgedAt = time.Now().UTC()
+			run.AcknowledgedBy = by
+		}
+		acknowledged = *run
+	})
+	return acknowledged, err
+}
+
+// ScanFunc performs the scan behind a run and returns its SBOMs and CVEs keyed by target
+type ScanFunc func() (map[string]string, map[string][]tableprinter.CVEInfo, error)
+
+// Execute marks the run as running, calls scan and records its results and final status
+func (s *Store) Execute(id string, scan ScanFunc) error {
+	err := s.Update(id, func(run *Run) {
+		run.Status = StatusRunning
+		run.StartedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return err
+	}
+
+	sbomResults, cveResults, scanErr := scan()
+
+	// Written before taking the lock, so reading runs does not wait for SBOMs of several MB
+	saved, err := s.saveSBOMs(id, sbomResults)
+	scanErr = errors.Join(scanErr, err)
+
+	err = s.Update(id, func(run *Run) {
+		run.FinishedAt = time.Now().UTC()
+		s.setSBOMs(run, sbomResults, saved)
+		run.CVEs = cveResults
+		run.Status = StatusSucceeded
+		if scanErr != nil {
+			run.Status = StatusFailed
+			run.Error = scanErr.Error()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return scanErr
+}
+
+func (s *Store) save(run *Run) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode scan run %s: %w", run.ID, err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated run behind
+	path := filepath.Join(s.dir, run.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to save scan run %s: %w", run.ID, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveSBOMs writes the SBOMs of a run to files next to it when the store has a directory. It returns the
+// targets whose SBOM was saved, also those saved before an error.
+func (s *Store) saveSBOMs(id string, sboms map[string]string) ([]string, error) {
+	if s.dir == "" || len(sboms) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, id+".sboms"), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to save SBOMs of scan run %s: %w", id, err)
+	}
+
+	var targets []string
+	for target, sbom := range sboms {
+		path := s.sbomPath(id, target)
+		if err := os.WriteFile(path+".tmp", []byte(sbom), 0o640); err != nil {
+			slices.Sort(targets)
+			return targets, fmt.Errorf("failed to save SBOM of %s in scan run %s: %w", target, id, err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			slices.Sort(targets)
+			return targets, err
+		}
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+	return targets, nil
+}
+
+// setSBOMs keeps the SBOMs with the run in memory, or references the files saveSBOMs wrote
+func (s *Store) setSBOMs(run *Run, sboms map[string]string, saved []string) {
+	if s.dir == "" {
+		run.SBOMs, run.SBOMTargets = sboms, nil
+		return
+	}
+	run.SBOMs, run.SBOMTargets = nil, saved
+}
+
+// sbomPath names SBOM files by a hash of the target, which may be an image reference or a file path
+func (s *Store) sbomPath(id, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(s.dir, id+".sboms", hex.EncodeToString(sum[:16])+".json")
+}
+
+// prune removes the runs beyond the retention limits, together with their SBOMs
+func (s *Store) prune() error {
+	if s.maxRuns == 0 && s.maxAge == 0 {
+		return nil
+	}
+
+	var errs []error
+	removed := 0
+	for i, run := range s.sorted() {
+		inProgress := run.Status == StatusQueued || run.Status == StatusRunning
+		expired := (s.maxRuns > 0 && i >= s.maxRuns) || (s.maxAge > 0 && time.Since(run.CreatedAt) > s.maxAge)
+		if !expired || inProgress || run.Active() {
+			continue
+		}
+		delete(s.runs, run.ID)
+		removed++
+		if s.dir == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, run.ID+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove scan run %s: %w", run.ID, err))
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, run.ID+".sboms")); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove SBOMs of scan run %s: %w", run.ID, err))
+		}
+	}
+	if removed > 0 {
+		s.logger.Info("Removed scan runs beyond the retention limits", "runs", removed)
+	}
+	return errors.Join(errs...)
+}
+
+type runIDKey struct{}
+
+// WithRunID returns a copy of ctx carrying the ID of the run the work belongs to
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// RunIDFromContext returns the run ID carried by ctx, or an empty string when there is none
+func RunIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate run id: %w", err)
+	}
+	// Prefix with the time so IDs sort chronologically
+	return strings.ToLower(time.Now().UTC().Format("20060102T150405")) + "-" + hex.EncodeToString(buf), nil
+}
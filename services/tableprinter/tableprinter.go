@@ -2,6 +2,7 @@ package tableprinter
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"text/tabwriter"
 	"time"
@@ -10,6 +11,9 @@ import (
 // CVEInfo holds the details of a detected CVE
 type CVEInfo struct {
 	CVEName         string
+	Package         string
+	PackageType     string
+	Distro          string // Base distribution of the scanned image, e.g. alpine:3.18.4
 	Date            time.Time
 	Severity        string
 	CurrentVersion  string
@@ -19,8 +23,13 @@ type CVEInfo struct {
 
 // PrintCVEResults prints a table of CVE information
 func PrintCVEResults(containerID string, cveList []CVEInfo) {
+	WriteCVEResults(os.Stdout, cveList)
+}
+
+// WriteCVEResults writes a table of CVE information to w
+func WriteCVEResults(w io.Writer, cveList []CVEInfo) {
 	// Create a tab writer for formatted output
-	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight|tabwriter.Debug)
 
 	// Print the header
 	fmt.Fprintln(writer, "CVE Name\tDate\tSeverity\tCurrent Version\tResolved Version\tPath")
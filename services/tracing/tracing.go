@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TracerName identifies the spans created by the scanner, whichever package creates them
+const TracerName = "AutomaticCVEResolver"
+
+// Config controls the export of traces over OTLP/HTTP
+type Config struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"` // host:port of the collector, defaults to localhost:4318
+	URLPath     string            `yaml:"url_path"` // defaults to /v1/traces
+	Insecure    bool              `yaml:"insecure"` // Use plain HTTP instead of HTTPS
+	Headers     map[string]string `yaml:"headers"`  // Extra headers, e.g. for collector authentication
+	SampleRatio float64           `yaml:"sample_ratio"`
+	ServiceName string            `yaml:"service_name"`
+}
+
+// Setup installs a global tracer provider exporting spans as configured and returns a function
+// that flushes pending spans on shutdown. When tracing is disabled nothing is installed and
+// the returned function is a no-op.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if !config.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if config.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+	}
+	if config.URLPath != "" {
+		options = append(options, otlptracehttp.WithURLPath(config.URLPath))
+	}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(config.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(exporter), config)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// NewProvider creates a tracer provider sending spans to the given processor.
+// Tests use it with an in-memory exporter wrapped in a simple span processor.
+func NewProvider(processor sdktrace.SpanProcessor, config Config) *sdktrace.TracerProvider {
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "cve-scanner"
+	}
+
+	// A zero ratio means the option was left out, so sample everything
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+}
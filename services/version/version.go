@@ -0,0 +1,160 @@
+package version
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Compare compares two package version strings segment by segment.
+// It returns -1 if a < b, 0 if a == b and 1 if a > b.
+// Numeric segments are compared as numbers and everything else lexically,
+// which is good enough for apk, deb and semver style versions. A Debian epoch
+// like the 1 of 1:2.3 outranks the rest of the version.
+func Compare(a, b string) int {
+	aEpoch, a := epoch(a)
+	bEpoch, b := epoch(b)
+	if aEpoch != bEpoch {
+		return compareNumbers(aEpoch, bEpoch)
+	}
+
+	as, bs := segments(a), segments(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		// Only a pre-release marker like ~ or rc makes the longer version the lower one, so
+		// 1.0~rc1 < 1.0-rc1 < 1.0 < 1.0.1 but 1.1.1 < 1.1.1w and 3.0.8 < 3.0.8-r0
+		if i >= len(as) {
+			if isPreRelease(bs[i]) {
+				return 1
+			}
+			return -1
+		}
+		if i >= len(bs) {
+			if isPreRelease(as[i]) {
+				return -1
+			}
+			return 1
+		}
+		if c := compareSegment(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Max returns the highest version in the list, or an empty string for an empty list
+func Max(versions ...string) string {
+	highest := ""
+	for _, v := range versions {
+		if highest == "" || Compare(v, highest) > 0 {
+			highest = v
+		}
+	}
+	return highest
+}
+
+// Major returns the leading numeric segment of a version, or -1 if there is none
+func Major(v string) int {
+	for _, s := range segments(v) {
+		if n, err := strconv.Atoi(s); err == nil {
+			return n
+		}
+		return -1
+	}
+	return -1
+}
+
+// preReleases are the words that mark a version as coming before the release it names
+var preReleases = []string{"alpha", "beta", "dev", "pre", "preview", "rc", "snapshot"}
+
+func isPreRelease(segment string) bool {
+	return segment == "~" || slices.Contains(preReleases, strings.ToLower(segment))
+}
+
+// epoch splits a Debian epoch off a version, 0 when it has none
+func epoch(v string) (int, string) {
+	prefix, rest, found := strings.Cut(v, ":")
+	if !found {
+		return 0, v
+	}
+	n, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, v
+	}
+	return n, rest
+}
+
+func compareSegment(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareNumbers(an, bn)
+	case a == "~" || b == "~":
+		// ~ sorts before anything, even the end of the version
+		if a == b {
+			return 0
+		}
+		if a == "~" {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		return 1
+	case bErr == nil:
+		return -1
+	case isPreRelease(a) != isPreRelease(b):
+		if isPreRelease(a) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareNumbers(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// segments splits a version into runs of digits and runs of letters, dropping separators other than ~
+func segments(v string) []string {
+	var parts []string
+	current := []rune{}
+	digit := false
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, string(current))
+			current = current[:0]
+		}
+	}
+	for _, r := range v {
+		switch {
+		case unicode.IsDigit(r):
+			if !digit {
+				flush()
+			}
+			digit = true
+			current = append(current, r)
+		case r == '~':
+			flush()
+			parts = append(parts, "~")
+			digit = false
+		case unicode.IsLetter(r):
+			if digit {
+				flush()
+			}
+			digit = false
+			current = append(current, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return parts
+}
@@ -0,0 +1,239 @@
+package alerting
+
+import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sentAlert is a reminder or escalation passed to the recording sender
+type sentAlert struct {
+	runID      string
+	escalation bool
+	channels   []string
+}
+
+type recordingSender struct {
+	sent []sentAlert
+}
+
+func (r *recordingSender) SendAlert(ctx context.Context, run store.Run, escalation bool, channels []string) error {
+	r.sent = append(r.sent, sentAlert{runID: run.ID, escalation: escalation, channels: channels})
+	return nil
+}
+
+var criticalResults = map[string][]tableprinter.CVEInfo{
+	"web": {{CVEName: "CVE-2024-0001", Severity: "Critical"}, {CVEName: "CVE-2024-0002", Severity: "Low"}},
+}
+
+func TestSchedule(t *testing.T) {
+	schedule, err := alerting.NewSchedule(alerting.QuietHours{Start: "19:00", End: "07:30", Weekends: true, Timezone: "Europe/Berlin"})
+	assert.NoError(t, err)
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	cases := map[time.Time]bool{
+		time.Date(2026, 10, 14, 12, 0, 0, 0, berlin):   false, // Wednesday noon
+		time.Date(2026, 10, 14, 19, 0, 0, 0, berlin):   true,
+		time.Date(2026, 10, 14, 23, 59, 0, 0, berlin):  true,
+		time.Date(2026, 10, 15, 7, 29, 0, 0, berlin):   true,
+		time.Date(2026, 10, 15, 7, 30, 0, 0, berlin):   false,
+		time.Date(2026, 10, 17, 12, 0, 0, 0, berlin):   true,  // Saturday
+		time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC): false, // 12:00 in Berlin
+		time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC): true,  // 20:00 in Berlin
+	}
+	for at, want := range cases {
+		assert.Equal(t, want, schedule.Active(at), at.String())
+	}
+
+	night := time.Date(2026, 10, 14, 22, 0, 0, 0, berlin)
+	assert.False(t, schedule.Suppresses("critical", night))
+	assert.True(t, schedule.Suppresses("high", night))
+	assert.True(t, schedule.Suppresses("", night))
+	assert.False(t, schedule.Suppresses("high", time.Date(2026, 10, 14, 12, 0, 0, 0, berlin)))
+
+	// Without quiet hours nothing is suppressed
+	schedule, err = alerting.NewSchedule(alerting.QuietHours{})
+	assert.NoError(t, err)
+	assert.Nil(t, schedule)
+	assert.False(t, schedule.Suppresses("low", night))
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	for name, config := range map[string]alerting.QuietHours{
+		"clock":    {Start: "7pm", End: "07:00"},
+		"timezone": {Start: "19:00", End: "07:00", Timezone: "Mars/Olympus"},
+		"severity": {Weekends: true, MinSeverity: "urgent"},
+	} {
+		_, err := alerting.NewSchedule(config)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestManager_Lifecycle(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	sender := &recordingSender{}
+	manager, err := alerting.NewManager(alerting.Config{RepeatMinutes: 60, EscalateAfterHours: 4, EscalationChannels: []string{"oncall"}}, st, sender)
+	assert.NoError(t, err)
+
+	start := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	now := start
+	manager.SetClock(func() time.Time { return now })
+
+	run, _ := st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(run.ID, criticalResults))
+	run, _ = st.Get(run.ID)
+	assert.Equal(t, "critical", run.Alert.Severity)
+	assert.True(t, run.Active())
+
+	// The run's own notifications were the first, so nothing is due yet
+	now = start.Add(30 * time.Minute)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Empty(t, sender.sent)
+
+	now = start.Add(61 * time.Minute)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Equal(t, []sentAlert{{runID: run.ID}}, sender.sent)
+	run, _ = st.Get(run.ID)
+	assert.Equal(t, 1, run.Alert.Reminders)
+
+	now = start.Add(4 * time.Hour)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Equal(t, sentAlert{runID: run.ID, escalation: true, channels: []string{"oncall"}}, sender.sent[1])
+
+	// Escalations happen once, reminders go on
+	now = start.Add(5*time.Hour + time.Minute)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Len(t, sender.sent, 3)
+	assert.False(t, sender.sent[2].escalation)
+
+	_, err = st.Acknowledge(run.ID, "ntfy")
+	assert.NoError(t, err)
+	now = start.Add(10 * time.Hour)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Len(t, sender.sent, 3)
+}
+
+func TestManager_RaiseThresholdAndSupersede(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	manager, err := alerting.NewManager(alerting.Config{MinSeverity: "high", RepeatMinutes: 10}, st, &recordingSender{})
+	assert.NoError(t, err)
+
+	low, _ := st.Create("image:nginx", "cli")
+	assert.NoError(t, manager.Raise(low.ID, map[string][]tableprinter.CVEInfo{"nginx": {{Severity: "Medium"}}}))
+	low, _ = st.Get(low.ID)
+	assert.Nil(t, low.Alert)
+
+	first, _ := st.Create("image:nginx", "cli")
+	assert.NoError(t, manager.Raise(first.ID, criticalResults))
+	second, _ := st.Create("image:nginx", "cli")
+	assert.NoError(t, manager.Raise(second.ID, criticalResults))
+
+	first, _ = st.Get(first.ID)
+	assert.Equal(t, second.ID, first.Alert.SupersededBy)
+	assert.False(t, first.Active())
+	second, _ = st.Get(second.ID)
+	assert.True(t, second.Active())
+}
+
+func TestManager_RescanResolvesFixedTargets(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	sender := &recordingSender{}
+	manager, err := alerting.NewManager(alerting.Config{RepeatMinutes: 10}, st, sender)
+	assert.NoError(t, err)
+
+	alerted, _ := st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(alerted.ID, map[string][]tableprinter.CVEInfo{
+		"web": {{Severity: "Critical"}},
+		"db":  {{Severity: "Critical"}},
+		"app": {{Severity: "Low"}},
+	}))
+	alerted, _ = st.Get(alerted.ID)
+	assert.Equal(t, []string{"db", "web"}, alerted.Alert.Targets)
+
+	// A run of another target leaves the alert alone
+	other, _ := st.Create("event", "event")
+	assert.NoError(t, manager.Raise(other.ID, map[string][]tableprinter.CVEInfo{"cache": {{Severity: "Critical"}}}))
+	alerted, _ = st.Get(alerted.ID)
+	assert.Equal(t, []string{"db", "web"}, alerted.Alert.Targets)
+	assert.True(t, alerted.Active())
+
+	// Fixing one target narrows the alert, fixing the other resolves it
+	fixed, _ := st.Create("event", "event")
+	assert.NoError(t, manager.Raise(fixed.ID, map[string][]tableprinter.CVEInfo{"web": nil}))
+	alerted, _ = st.Get(alerted.ID)
+	assert.Equal(t, []string{"db"}, alerted.Alert.Targets)
+	assert.True(t, alerted.Active())
+	fixed, _ = st.Get(fixed.ID)
+	assert.Nil(t, fixed.Alert)
+
+	fixed, _ = st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(fixed.ID, map[string][]tableprinter.CVEInfo{"db": {{Severity: "Medium"}}}))
+	alerted, _ = st.Get(alerted.ID)
+	assert.Equal(t, fixed.ID, alerted.Alert.SupersededBy)
+	assert.False(t, alerted.Active())
+}
+
+func TestManager_QuietHoursDelayReminders(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	sender := &recordingSender{}
+	manager, err := alerting.NewManager(alerting.Config{
+		MinSeverity:   "high",
+		RepeatMinutes: 60,
+		QuietHours:    alerting.QuietHours{Start: "20:00", End: "08:00", Timezone: "UTC"},
+	}, st, sender)
+	assert.NoError(t, err)
+
+	now := time.Date(2026, 10, 14, 19, 0, 0, 0, time.UTC)
+	manager.SetClock(func() time.Time { return now })
+	run, _ := st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(run.ID, map[string][]tableprinter.CVEInfo{"web": {{Severity: "High"}}}))
+
+	now = time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Empty(t, sender.sent)
+
+	now = time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC)
+	assert.NoError(t, manager.Check(context.Background()))
+	assert.Len(t, sender.sent, 1)
+}
+
+func TestNewManager_Invalid(t *testing.T) {
+	_, err := alerting.NewManager(alerting.Config{EscalateAfterHours: 2}, nil, nil)
+	assert.ErrorContains(t, err, "escalation channel")
+
+	_, err = alerting.NewManager(alerting.Config{MinSeverity: "urgent"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestManager_Reconfigure(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	manager, err := alerting.NewManager(alerting.Config{MinSeverity: "critical"}, st, &recordingSender{})
+	assert.NoError(t, err)
+
+	// An invalid policy is rejected and the previous one stays in effect
+	assert.Error(t, manager.Reconfigure(alerting.Config{MinSeverity: "urgent"}))
+	run, _ := st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(run.ID, map[string][]tableprinter.CVEInfo{"web": {{Severity: "High"}}}))
+	run, _ = st.Get(run.ID)
+	assert.Nil(t, run.Alert)
+
+	assert.NoError(t, manager.Reconfigure(alerting.Config{
+		MinSeverity: "high",
+		QuietHours:  alerting.QuietHours{Start: "22:00", End: "06:00", Timezone: "UTC"},
+	}))
+	assert.NotNil(t, manager.QuietHours())
+	run, _ = st.Create("running", "schedule")
+	assert.NoError(t, manager.Raise(run.ID, map[string][]tableprinter.CVEInfo{"web": {{Severity: "High"}}}))
+	run, _ = st.Get(run.ID)
+	assert.NotNil(t, run.Alert)
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"AutomaticCVEResolver/services/api"
+	"AutomaticCVEResolver/services/links"
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "s3cret"
+
+// linkSigner signs the links the test server accepts in place of a bearer token
+var linkSigner = links.NewSigner("link-secret", time.Hour)
+
+func newTestServer(t *testing.T) (*httptest.Server, chan string) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+
+	// Record the requested targets and return canned results
+	requested := make(chan string, 1)
+	scan := func(ctx context.Context, target string) (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+		requested <- target
+		return map[string]string{"registry.local/app:1.4": `{"artifacts": []}`},
+			map[string][]tableprinter.CVEInfo{"registry.local/app:1.4": {{CVEName: "CVE-2024-0001", Severity: "High", CurrentVersion: "1.0", ResolvedVersion: "1.1"}}},
+			nil
+	}
+
+	server, err := api.NewServer(context.Background(), st, scan, []string{testToken})
+	assert.NoError(t, err)
+	server.SetLinks(linkSigner)
+
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer, requested
+}
+
+func doRequest(t *testing.T, method, url, token, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPI_RequiresToken(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	resp := doRequest(t, http.MethodGet, server.URL+"/api/v1/scans", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/api/v1/scans", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/healthz", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err := api.NewServer(context.Background(), nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestAPI_ScanLifecycle(t *testing.T) {
+	server, requested := newTestServer(t)
+
+	resp := doRequest(t, http.MethodPost, server.URL+"/api/v1/scans", testToken, `{"image": "registry.local/app:1.4"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "image:registry.local/app:1.4", <-requested)
+
+	var status api.RunStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.NotEmpty(t, status.ID)
+
+	// Poll until the background scan has finished
+	scanURL := server.URL + "/api/v1/scans/" + status.ID
+	assert.Eventually(t, func() bool {
+		resp := doRequest(t, http.MethodGet, scanURL, testToken, "")
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status.Status == store.StatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, status.SeverityCounts["high"])
+
+	resp = doRequest(t, http.MethodGet, scanURL+"/results", testToken, "")
+	var results map[string][]tableprinter.CVEInfo
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	assert.Equal(t, "CVE-2024-0001", results["registry.local/app:1.4"][0].CVEName)
+
+	resp = doRequest(t, http.MethodGet, scanURL+"/sboms/registry.local/app:1.4", testToken, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, scanURL+"/report?format=plan", testToken, "")
+	report, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(report), "upgrade")
+
+	resp = doRequest(t, http.MethodGet, scanURL+"/report?format=pdf", testToken, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, server.URL+"/api/v1/scans/unknown", testToken, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPI_InvalidTarget(t *testing.T) {
+	server, requested := newTestServer(t)
+
+	resp := doRequest(t, http.MethodPost, server.URL+"/api/v1/scans", testToken, `{"image": "--privileged"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `invalid reference in target \"image:--privileged\"`)
+
+	resp = doRequest(t, http.MethodPost, server.URL+"/api/v1/scans", testToken, `{"target": "running,"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// No run was created or scanned
+	resp = doRequest(t, http.MethodGet, server.URL+"/api/v1/scans", testToken, "")
+	var runs []api.RunStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&runs))
+	assert.Empty(t, runs)
+	assert.Empty(t, requested)
+}
+
+func TestAPI_AcknowledgeAndHTMLReport(t *testing.T) {
+	server, requested := newTestServer(t)
+
+	resp := doRequest(t, http.MethodPost, server.URL+"/api/v1/scans", testToken, "")
+	assert.Equal(t, "running", <-requested)
+	var status api.RunStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	scanURL := server.URL + "/api/v1/scans/" + status.ID
+
+	assert.Eventually(t, func() bool {
+		resp := doRequest(t, http.MethodGet, scanURL, testToken, "")
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status.Status == store.StatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp = doRequest(t, http.MethodGet, scanURL+"/report?format=html", testToken, "")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	report, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(report), "<td>CVE-2024-0001</td>")
+
+	resp = doRequest(t, http.MethodPost, scanURL+"/ack", testToken, `{"by": "ntfy"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "ntfy", status.AcknowledgedBy)
+	assert.False(t, status.AcknowledgedAt.IsZero())
+
+	// Later acknowledgements keep the first one
+	resp = doRequest(t, http.MethodPost, scanURL+"/ack", testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "ntfy", status.AcknowledgedBy)
+
+	resp = doRequest(t, http.MethodPost, server.URL+"/api/v1/scans/unknown/ack", testToken, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPI_SignedLinks(t *testing.T) {
+	server, requested := newTestServer(t)
+
+	resp := doRequest(t, http.MethodPost, server.URL+"/api/v1/scans", testToken, `{"target": "running"}`)
+	<-requested
+	var status api.RunStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	scanURL := server.URL + "/api/v1/scans/" + status.ID
+	assert.Eventually(t, func() bool {
+		resp := doRequest(t, http.MethodGet, scanURL, testToken, "")
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status.Status == store.StatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The report link of a notification opens in a browser without an API token
+	resp = doRequest(t, http.MethodGet, scanURL+"/report?format=html&token="+linkSigner.Sign(status.ID, links.ActionReport), "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Tokens grant only their own action on their own run
+	resp = doRequest(t, http.MethodPost, scanURL+"/ack?token="+linkSigner.Sign(status.ID, links.ActionReport), "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doRequest(t, http.MethodGet, scanURL+"/report?token="+linkSigner.Sign("other-run", links.ActionReport), "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = doRequest(t, http.MethodGet, scanURL+"/results?token="+linkSigner.Sign(status.ID, links.ActionReport), "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPost, scanURL+"/ack?token="+linkSigner.Sign(status.ID, links.ActionAck), "", `{"by": "ntfy"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Rescans are limited to the targets of the run
+	rescanURL := scanURL + "/rescan?token=" + linkSigner.Sign(status.ID, links.ActionRescan)
+	resp = doRequest(t, http.MethodPost, rescanURL, "", `{"image": "registry.local/app:1.4"}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "image:registry.local/app:1.4", <-requested)
+	resp = doRequest(t, http.MethodPost, rescanURL, "", `{"image": "attacker/image"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doRequest(t, http.MethodPost, rescanURL, "", `{"target": "all"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = doRequest(t, http.MethodPost, rescanURL, "", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "running", <-requested)
+}
+
+func TestAPI_Notifications(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	server, err := api.NewServer(context.Background(), st, nil, []string{testToken})
+	assert.NoError(t, err)
+
+	outbox, err := notify.NewOutbox("")
+	assert.NoError(t, err)
+	server.SetOutbox(outbox)
+	outbox.Wrap("ntfy", notifierFunc(func(msg notify.Message) error { return nil })).Notify(context.Background(), notify.Message{Title: "sent", RunID: "run-1"})
+	outbox.Wrap("slack", notifierFunc(func(msg notify.Message) error { return &notify.HTTPError{StatusCode: 503} })).Notify(context.Background(), notify.Message{Title: "queued", RunID: "run-2"})
+
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	var deliveries []api.DeliveryStatus
+	resp := doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/notifications?status=pending", testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, "slack", deliveries[0].Channel)
+	assert.Equal(t, "queued", deliveries[0].Title)
+	assert.Equal(t, "unexpected response 503: ", deliveries[0].LastError)
+	assert.False(t, deliveries[0].NextAttempt.IsZero())
+
+	resp = doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/notifications?run=run-1", testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, notify.DeliveryDelivered, deliveries[0].Status)
+
+	var delivery api.DeliveryStatus
+	resp = doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/notifications/"+deliveries[0].ID, testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&delivery))
+	assert.Equal(t, "sent", delivery.Title)
+
+	resp = doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/notifications/unknown", testToken, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// notifierFunc adapts a function to notify.Notifier
+type notifierFunc func(msg notify.Message) error
+
+func (f notifierFunc) Notify(ctx context.Context, msg notify.Message) error {
+	return f(msg)
+}
+
+func TestAPI_Alerts(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+	server, err := api.NewServer(context.Background(), st, nil, []string{testToken})
+	assert.NoError(t, err)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	run, _ := st.Create("running", "schedule")
+	st.Create("image:nginx", "cli")
+	assert.NoError(t, st.Update(run.ID, func(run *store.Run) {
+		run.Alert = &store.Alert{Severity: "critical", RaisedAt: time.Now()}
+	}))
+
+	var alerts []api.RunStatus
+	resp := doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/alerts", testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&alerts))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, run.ID, alerts[0].ID)
+	assert.Equal(t, "critical", alerts[0].Alert.Severity)
+
+	// Acknowledging ends the alert
+	doRequest(t, http.MethodPost, httpServer.URL+"/api/v1/scans/"+run.ID+"/ack", testToken, `{"by": "ntfy"}`)
+	resp = doRequest(t, http.MethodGet, httpServer.URL+"/api/v1/alerts", testToken, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&alerts))
+	assert.Empty(t, alerts)
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"AutomaticCVEResolver/services/config"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/remediation"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Layers(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+ntfy:
+  server_url: "http://ntfy.local"
+  topic: cve
+  password: from-file
+logging:
+  level: debug
+notifications:
+  channels:
+    - name: slack
+      type: slack
+      url: "https://hooks.slack.com/services/x"
+`)
+
+	cfg, err := config.Load(path, []string{
+		"CVE_NTFY_PASSWORD=from-env",
+		"CVE_NOTIFICATIONS_CHANNELS_0_URL=https://hooks.slack.com/services/y",
+		"CVE_API_TOKENS=a, b",
+		"CVE_EVENTS_ENABLED=true",
+		"HOME=/root",
+	})
+	assert.NoError(t, err)
+
+	// Defaults fill in what the file leaves out
+	assert.Equal(t, 10, cfg.Ntfy.TimeoutSeconds)
+	assert.Equal(t, 5, cfg.Scan.Concurrency)
+	assert.Equal(t, "text", cfg.Logging.Format)
+	// The file overrides defaults and the environment overrides the file
+	assert.Equal(t, "debug", cfg.Logging.Level)
+	assert.Equal(t, "from-env", cfg.Ntfy.Password)
+	assert.Equal(t, "https://hooks.slack.com/services/y", cfg.Notifications.Channels[0].URL)
+	assert.Equal(t, []string{"a", "b"}, cfg.API.Tokens)
+	assert.True(t, cfg.Events.Enabled)
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	secret := writeFile(t, "ntfy_password", "s3cret\n")
+	token := writeFile(t, "token", "api-token")
+	path := writeFile(t, "config.yaml", `
+ntfy:
+  server_url: "http://ntfy.local"
+  topic: cve
+  password_file: `+secret+`
+notifications:
+  routes_file: routes.yaml
+`)
+
+	cfg, err := config.Load(path, []string{"CVE_NOTIFICATIONS_LINK_SECRET_FILE=" + token})
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", cfg.Ntfy.Password)
+	assert.Equal(t, "api-token", cfg.Notifications.LinkSecret)
+	// Settings ending in _file are not mistaken for secret files
+	assert.Equal(t, "routes.yaml", cfg.Notifications.RoutesFile)
+
+	_, err = config.Load(path, []string{"CVE_NTFY_PASSWORD=x", "CVE_NTFY_PASSWORD_FILE=" + secret})
+	assert.ErrorContains(t, err, "set CVE_NTFY_PASSWORD or CVE_NTFY_PASSWORD_FILE, not both")
+
+	_, err = config.Load(path, []string{"CVE_NTFY_TOPIC_FILE=/nonexistent"})
+	assert.ErrorContains(t, err, "CVE_NTFY_TOPIC_FILE: failed to read secret file")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown setting":     "ntfy:\n  pasword: x\n",
+		"unknown secret file": "ntfy:\n  timeout_seconds_file: /tmp/x\n",
+		"value and file":      "ntfy:\n  password: x\n  password_file: /tmp/x\n",
+		"wrong type":          "ntfy:\n  timeout_seconds: soon\n",
+		"unreadable secret":   "ntfy:\n  password_file: /nonexistent\n",
+	} {
+		_, err := config.Load(writeFile(t, "config.yaml", content), nil)
+		assert.Error(t, err, name)
+	}
+
+	_, err := config.Load(writeFile(t, "config.yaml", "ntfy:\n  pasword: x\n"), nil)
+	assert.ErrorContains(t, err, "line 2: unknown setting ntfy.pasword")
+
+	_, err = config.Load("", []string{"CVE_SCAN_CONCURRENCY=many"})
+	assert.ErrorContains(t, err, "invalid CVE_SCAN_CONCURRENCY")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	cfg.Ntfy.ServerURL = "http://ntfy.local"
+	cfg.Ntfy.Topic = "cve"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Ntfy.TimeoutSeconds = 0
+	cfg.Scan.Concurrency = -1
+	cfg.API.Listen = ":8080"
+	cfg.Logging.Level = "loud"
+	cfg.Schedules = []config.ScheduleConfig{{Name: "nightly", Cron: "0 3 * *", Target: "pod:web"}}
+	cfg.Alerting.Enabled = true
+	cfg.Alerting.EscalationChannels = []string{"pager"}
+	cfg.Registry.Insecure = []string{"http://localhost:5000"}
+	cfg.Notifications.APIURL = "https://scanner.example"
+	cfg.Ignore = []ignore.Rule{{CVE: "CVE-2023-44487", Until: "next year"}}
+	cfg.Remediation.LatestReleases["alpine"] = ""
+
+	err := cfg.Validate()
+	for _, problem := range []string{
+		"ntfy.timeout_seconds: must be positive, got 0",
+		"scan.concurrency: must be positive, got -1",
+		"api.tokens: at least one token is required",
+		`logging: invalid log level "loud"`,
+		"schedules[0].cron:",
+		`schedules[0].target: unknown scan target "pod:web"`,
+		`alerting.escalation_channels: unknown channel "pager"`,
+		`registry.insecure[0]: expected a registry host like localhost:5000, got "http://localhost:5000"`,
+		"notifications.link_secret: required when api_url is set",
+		`ignore: CVE-2023-44487: until expects YYYY-MM-DD, got "next year"`,
+		"remediation.latest_releases.alpine: release must not be empty",
+	} {
+		assert.ErrorContains(t, err, problem)
+	}
+
+	// The links must not be signed with an API token
+	cfg = config.Default()
+	cfg.Ntfy.ServerURL = "http://ntfy.local"
+	cfg.Ntfy.Topic = "cve"
+	cfg.API.Tokens = []string{"shared"}
+	cfg.Notifications.LinkSecret = "shared"
+	assert.ErrorContains(t, cfg.Validate(), "notifications.link_secret: must differ from every api.tokens entry")
+}
+
+func TestNotificationChannels(t *testing.T) {
+	cfg := config.Default()
+	cfg.Ntfy.ServerURL = "http://ntfy.local"
+	cfg.Ntfy.Topic = "cve"
+
+	channels := cfg.NotificationChannels()
+	assert.Len(t, channels, 1)
+	assert.Equal(t, "ntfy", channels[0].Name)
+	assert.Equal(t, "cve", channels[0].Topic)
+	assert.Equal(t, 10, channels[0].TimeoutSeconds)
+}
+
+func TestReleases(t *testing.T) {
+	cfg := config.Default()
+	assert.Equal(t, remediation.DefaultReleases, cfg.Releases())
+
+	// Configured releases are merged over the built-in table
+	path := writeFile(t, "config.yaml", `
+remediation:
+  latest_releases:
+    alpine: "3.23"
+`)
+	cfg, err := config.Load(path, nil)
+	assert.NoError(t, err)
+	releases := cfg.Releases()
+	assert.Equal(t, "remediation.latest_releases", releases.Source)
+	assert.Equal(t, "3.23", releases.Latest["alpine"])
+	assert.Equal(t, remediation.DefaultReleases.Latest["debian"], releases.Latest["debian"])
+	assert.Equal(t, "3.22", remediation.DefaultReleases.Latest["alpine"])
+}
+
+func TestScanConfig_Limits(t *testing.T) {
+	scan := config.Default().Scan
+	assert.Equal(t, docker.Limits{SyftWorkers: 5, GrypeWorkers: 5, SyftTimeout: 5 * time.Minute, GrypeTimeout: 5 * time.Minute}, scan.Limits())
+
+	scan.GrypeWorkers = 2
+	scan.SyftTimeoutSeconds = 0
+	limits := scan.Limits()
+	assert.Equal(t, 5, limits.SyftWorkers)
+	assert.Equal(t, 2, limits.GrypeWorkers)
+	assert.Zero(t, limits.SyftTimeout)
+}
+
+func TestScanConfig_ProcessLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Scan.GrypeProcess.MemoryMB = 2048
+	cfg.Scan.GrypeProcess.Nice = 15
+
+	limits := cfg.Scan.ProcessLimits()
+	assert.Equal(t, 10, limits[docker.StageSyft].Nice)
+	assert.Zero(t, limits[docker.StageSyft].Memory)
+	assert.Equal(t, 15, limits[docker.StageGrype].Nice)
+	assert.Equal(t, int64(2048*1024*1024), limits[docker.StageGrype].Memory)
+	assert.Equal(t, docker.IOClassBestEffort, limits[docker.StageGrype].IOClass)
+
+	cfg.Ntfy.ServerURL = "http://ntfy.local"
+	cfg.Ntfy.Topic = "cve"
+	cfg.Scan.SyftProcess.IOClass = "slow"
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, `scan.syft_process.io_class: must be idle, best-effort or realtime, got "slow"`)
+	assert.ErrorContains(t, err, "scan.cgroup_dir: required by the CPU and memory limits of grype")
+}
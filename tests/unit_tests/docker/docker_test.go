@@ -3,6 +3,8 @@ package docker
 import (
 	"AutomaticCVEResolver/services/docker"
 	"context"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -162,3 +164,92 @@ func TestGenerateSBOMAndScanForCVEs_FailCVEScan(t *testing.T) {
 	// Validate CVE scan for redis
 	assert.Contains(t, cveResults["67890"], `"CVE-2021-67890"`)
 }
+
+func TestGenerateSBOMs(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"syft nginx -o json": `{"sbom": "nginx-sbom"}`,
+		},
+		FailCommands: map[string]bool{
+			"syft redis -o json": true,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	ds.SetConcurrency(1)
+
+	sbomResults := ds.GenerateSBOMs(context.Background(), []docker.ScanTarget{
+		{ContainerID: "abc123", Image: "nginx"},
+		{Image: "redis"},
+	})
+	// Failed targets are left out and grype is never run
+	assert.Equal(t, map[string]string{"abc123": `{"sbom": "nginx-sbom"}`}, sbomResults)
+}
+
+// countingExecutor counts the commands run through the mock
+type countingExecutor struct {
+	*MockCommandExecutor
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingExecutor) ExecCommand(ctx context.Context, command string, args ...string) (docker.ExecResult, error) {
+	c.mu.Lock()
+	c.calls[command+" "+strings.Join(args, " ")]++
+	c.mu.Unlock()
+	return c.MockCommandExecutor.ExecCommand(ctx, command, args...)
+}
+
+func TestScanTargets_SharedImage(t *testing.T) {
+	executor := &countingExecutor{
+		MockCommandExecutor: &MockCommandExecutor{
+			CommandOutputs: map[string]string{
+				"syft sha256:aaa -o json":  `{"sbom": "nginx-sbom"}`,
+				"grype sha256:aaa -o json": `{"matches": [{"vulnerability": {"id": "CVE-2024-0001", "severity": "High"}, "artifact": {"name": "openssl", "locations": [{"path": "/lib"}]}}]}`,
+			},
+		},
+		calls: make(map[string]int),
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	// Replicas of one image, a stopped container of it whose tag moved on and another container
+	sbomResults, cveResults, _ := ds.ScanTargets(context.Background(), []docker.ScanTarget{
+		{ContainerID: "web-1", Image: "nginx", Digest: "sha256:aaa"},
+		{ContainerID: "web-2", Image: "nginx", Digest: "sha256:aaa"},
+		{ContainerID: "old", Image: "nginx:1.27", Digest: "sha256:aaa"},
+	})
+	assert.Equal(t, 1, executor.calls["syft sha256:aaa -o json"])
+	assert.Equal(t, 1, executor.calls["grype sha256:aaa -o json"])
+	assert.Len(t, sbomResults, 3)
+	for _, key := range []string{"web-1", "web-2", "old"} {
+		assert.Equal(t, "CVE-2024-0001", cveResults[key][0].CVEName, key)
+	}
+}
+
+func TestScanTargets_MatchWithoutLocations(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"syft nginx -o json":  `{"sbom": "nginx-sbom"}`,
+			"grype nginx -o json": `{"matches": [{"vulnerability": {"id": "CVE-2024-0001", "severity": "High"}, "artifact": {"name": "openssl", "locations": []}}, {"vulnerability": {"id": "CVE-2024-0002", "severity": "Low"}, "artifact": {"name": "zlib"}}]}`,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	_, cveResults, _ := ds.ScanTargets(context.Background(), []docker.ScanTarget{{Image: "nginx"}})
+	assert.Len(t, cveResults["nginx"], 2)
+	for _, cve := range cveResults["nginx"] {
+		assert.Empty(t, cve.Path, cve.CVEName)
+	}
+}
+
+func TestScanForCVEs_ExecError(t *testing.T) {
+	executor := &MockCommandExecutor{
+		FailCommands: map[string]bool{"grype nginx -o json": true},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	_, err := ds.ScanForCVEs(context.Background(), "nginx")
+	var execErr *docker.ExecError
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, 1, execErr.ExitCode)
+	assert.Equal(t, "failed to scan for CVEs: grype exited with code 1: command failed: grype nginx -o json", err.Error())
+}
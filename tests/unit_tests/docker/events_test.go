@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const eventsCommand = "docker events --format {{json .}} --filter type=container --filter type=image --filter event=start --filter event=pull --filter event=tag"
+
+func TestEventWatcher_DebouncesAndSkipsRecentDigests(t *testing.T) {
+	// A compose up starting two containers of the same image plus a pull of another image
+	events := `{"Type":"container","Action":"start","Actor":{"ID":"aaaaaaaaaaaaaaaa","Attributes":{"image":"nginx"}}}
+{"Type":"container","Action":"start","Actor":{"ID":"bbbbbbbbbbbbbbbb","Attributes":{"image":"nginx"}}}
+{"Type":"container","Action":"stop","Actor":{"ID":"cccccccccccccccc","Attributes":{"image":"redis"}}}
+{"Type":"image","Action":"pull","Actor":{"ID":"redis:7"}}
+`
+	executor := &MockCommandExecutor{
+		StreamOutputs: map[string]string{eventsCommand: events},
+		CommandOutputs: map[string]string{
+			"docker image inspect --format {{.Id}} nginx":   "sha256:nginx\n",
+			"docker image inspect --format {{.Id}} redis:7": "sha256:redis\n",
+			"syft sha256:nginx -o json":                     `{"sbom": "nginx-sbom"}`,
+			"syft redis:7 -o json":                          `{"sbom": "redis-sbom"}`,
+			"grype sha256:nginx -o json":                    `{"matches": []}`,
+			"grype redis:7 -o json":                         `{"matches": []}`,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	var batches [][]docker.ScanTarget
+	var scanned []string
+	watcher := docker.NewEventWatcher(ds, 10*time.Millisecond, time.Hour,
+		func(ctx context.Context, targets []docker.ScanTarget, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string) {
+			batches = append(batches, targets)
+			for key := range cveResults {
+				scanned = append(scanned, key)
+			}
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The stream ends after the events above, which flushes the pending batch
+	err := watcher.Watch(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(batches))
+	// Both nginx containers share a digest, so only one of them is scanned
+	assert.Equal(t, 2, len(scanned))
+	assert.Contains(t, scanned, "redis:7")
+	// Targets carry the digests they were deduplicated by, containers are scanned by their image ID
+	for _, target := range batches[0] {
+		assert.NotEmpty(t, target.Digest, target.Key())
+	}
+
+	// Replaying the same events finds every digest already scanned
+	err = watcher.Watch(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(batches))
+}
+
+func TestEventWatcher_MaxDelay(t *testing.T) {
+	// A crash-looping container restarts faster than the debounce period for 300ms
+	events := strings.Repeat(`{"Type":"container","Action":"start","Actor":{"ID":"aaaaaaaaaaaaaaaa","Attributes":{"image":"nginx"}}}`+"\n", 30)
+	executor := &MockCommandExecutor{
+		StreamOutputs:  map[string]string{eventsCommand: events},
+		StreamInterval: 10 * time.Millisecond,
+		CommandOutputs: map[string]string{
+			"docker image inspect --format {{.Id}} nginx": "sha256:nginx\n",
+			"syft sha256:nginx -o json":                   `{"sbom": "nginx-sbom"}`,
+			"grype sha256:nginx -o json":                  `{"matches": []}`,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	var batches int
+	watcher := docker.NewEventWatcher(ds, 50*time.Millisecond, 0,
+		func(ctx context.Context, targets []docker.ScanTarget, sbomResults map[string]string, cveResults map[string][]tableprinter.CVEInfo, cancelled map[string]string) {
+			batches++
+		})
+	watcher.SetMaxDelay(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := watcher.Watch(ctx)
+	assert.Error(t, err)
+	// Without the cap the scan would wait for the stream to end and run once
+	assert.GreaterOrEqual(t, batches, 2)
+}
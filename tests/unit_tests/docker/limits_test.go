@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits_Adapt(t *testing.T) {
+	limits := docker.Limits{SyftWorkers: 8, GrypeWorkers: 4, SyftTimeout: time.Minute}
+	const gib = 1024 * 1024 * 1024
+
+	// Half of the CPUs
+	adapted := limits.Adapt(docker.Host{CPUs: 8}, gib)
+	assert.Equal(t, docker.Limits{SyftWorkers: 4, GrypeWorkers: 4, SyftTimeout: time.Minute}, adapted)
+
+	// Half of the available memory, shared by both stages
+	adapted = limits.Adapt(docker.Host{CPUs: 32, AvailableMemory: 6 * gib}, gib)
+	assert.Equal(t, 2, adapted.SyftWorkers)
+	assert.Equal(t, 1, adapted.GrypeWorkers)
+
+	// Every stage keeps a worker on the smallest hosts
+	adapted = limits.Adapt(docker.Host{CPUs: 1, AvailableMemory: gib / 4}, gib)
+	assert.Equal(t, 1, adapted.SyftWorkers)
+	assert.Equal(t, 1, adapted.GrypeWorkers)
+}
+
+func TestScanTargets_StageTimeout(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"syft nginx -o json":  `{"sbom": "nginx-sbom"}`,
+			"grype nginx -o json": `{"matches": []}`,
+			"syft redis -o json":  `{"sbom": "redis-sbom"}`,
+		},
+		HangCommands: map[string]bool{
+			"grype redis -o json": true,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	ds.SetLimits(docker.Limits{SyftWorkers: 1, GrypeWorkers: 1, GrypeTimeout: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The hung grype only costs its own target
+	sbomResults, cveResults, cancelled := ds.ScanTargets(ctx, []docker.ScanTarget{{Image: "redis"}, {Image: "nginx"}})
+	assert.Len(t, sbomResults, 2)
+	assert.Contains(t, cveResults, "nginx")
+	assert.NotContains(t, cveResults, "redis")
+	assert.Equal(t, map[string]string{"redis": "grype timed out after 50ms"}, cancelled)
+}
+
+func TestScanTargets_Cancelled(t *testing.T) {
+	executor := &MockCommandExecutor{
+		HangCommands: map[string]bool{
+			"syft nginx -o json": true,
+			"syft redis -o json": true,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	ds.SetConcurrency(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Targets still waiting for a worker are reported too
+	_, cveResults, cancelled := ds.ScanTargets(ctx, []docker.ScanTarget{{Image: "nginx"}, {Image: "redis"}})
+	assert.Empty(t, cveResults)
+	assert.Equal(t, map[string]string{
+		"nginx": "syft cancelled, the scan run timed out",
+		"redis": "syft cancelled, the scan run timed out",
+	}, cancelled)
+}
@@ -1,25 +1,60 @@
 package docker
 
 import (
+	"AutomaticCVEResolver/services/docker"
 	"context"
 	"errors"
+	"io"
 	"strings"
+	"time"
 )
 
 // MockCommandExecutor simulates command execution for testing
 type MockCommandExecutor struct {
 	CommandOutputs map[string]string // Command -> Output
 	FailCommands   map[string]bool   // Command -> ShouldFail
+	StreamOutputs  map[string]string // Command -> Streamed output
+	StreamInterval time.Duration     // Pause between the streamed lines, all lines are available at once when zero
+	HangCommands   map[string]bool   // Command -> Blocks until the context is done, like a hung process
 }
 
 // ExecCommand simulates executing a command by returning predefined output or error
-func (m *MockCommandExecutor) ExecCommand(ctx context.Context, command string, args ...string) ([]byte, error) {
+func (m *MockCommandExecutor) ExecCommand(ctx context.Context, command string, args ...string) (docker.ExecResult, error) {
 	fullCommand := command + " " + strings.Join(args, " ")
+	if m.HangCommands[fullCommand] {
+		<-ctx.Done()
+		return docker.ExecResult{ExitCode: -1}, &docker.ExecError{Command: command, Args: args, ExitCode: -1, Err: ctx.Err()}
+	}
 	if m.FailCommands[fullCommand] {
-		return nil, errors.New("command failed: " + fullCommand)
+		stderr := []byte("command failed: " + fullCommand)
+		return docker.ExecResult{Stderr: stderr, ExitCode: 1},
+			&docker.ExecError{Command: command, Args: args, ExitCode: 1, Stderr: stderr, Err: errors.New("exit status 1")}
 	}
 	if output, exists := m.CommandOutputs[fullCommand]; exists {
-		return []byte(output), nil
+		return docker.ExecResult{Stdout: []byte(output)}, nil
+	}
+	return docker.ExecResult{ExitCode: -1}, &docker.ExecError{Command: command, Args: args, ExitCode: -1, Err: errors.New("unknown command: " + fullCommand)}
+}
+
+// StreamCommand simulates a streaming command by returning its predefined output as a reader
+func (m *MockCommandExecutor) StreamCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	fullCommand := command + " " + strings.Join(args, " ")
+	output, exists := m.StreamOutputs[fullCommand]
+	if !exists {
+		return nil, errors.New("unknown command: " + fullCommand)
+	}
+	if m.StreamInterval == 0 {
+		return io.NopCloser(strings.NewReader(output)), nil
 	}
-	return nil, errors.New("unknown command: " + fullCommand)
+	reader, writer := io.Pipe()
+	go func() {
+		defer writer.Close()
+		for _, line := range strings.SplitAfter(output, "\n") {
+			time.Sleep(m.StreamInterval)
+			if _, err := io.WriteString(writer, line); err != nil {
+				return
+			}
+		}
+	}()
+	return reader, nil
 }
@@ -0,0 +1,329 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/alerting"
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/links"
+	"AutomaticCVEResolver/services/notify"
+	ntfyclient "AutomaticCVEResolver/services/ntfy"
+	"AutomaticCVEResolver/services/routing"
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AnthonyHewins/gotfy"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingNtfy captures published notifications
+type recordingNtfy struct {
+	published []ntfyclient.Notification
+}
+
+func (r *recordingNtfy) SendMessage(message, title string) (*gotfy.PublishResp, error) {
+	return &gotfy.PublishResp{ID: "1"}, nil
+}
+
+func (r *recordingNtfy) SendMessageAsync(message, title string, resultChan chan<- *gotfy.PublishResp, errorChan chan<- error) {
+}
+
+func (r *recordingNtfy) Publish(ctx context.Context, n ntfyclient.Notification) (*gotfy.PublishResp, error) {
+	r.published = append(r.published, n)
+	return &gotfy.PublishResp{ID: "1"}, nil
+}
+
+var reportCVEs = []tableprinter.CVEInfo{
+	{CVEName: "CVE-2024-0002", Severity: "Medium", Package: "zlib", CurrentVersion: "1.2.13"},
+	{CVEName: "CVE-2024-0001", Severity: "Critical", Package: "openssl", CurrentVersion: "3.0.8", ResolvedVersion: "3.0.13"},
+}
+
+func TestSendCVEReport(t *testing.T) {
+	ntfy := &recordingNtfy{}
+	service := docker.NewNotificationService(notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(ntfy, notify.FormatSummary)})
+	signer := links.NewSigner("link-secret", time.Hour)
+	service.SetAPI("https://scanner.example/", signer)
+
+	target := docker.ScanTarget{ContainerID: "abc123", Image: "nginx:1.27"}
+	err := service.SendCVEReport(context.Background(), "run-1", target, reportCVEs, "full report")
+	assert.NoError(t, err)
+	assert.Len(t, ntfy.published, 1)
+
+	n := ntfy.published[0]
+	assert.Equal(t, "CVE Scan Results for container abc123", n.Title)
+	assert.Equal(t, gotfy.Max, n.Priority)
+	assert.Equal(t, []string{"rotating_light", "critical", "cve"}, n.Tags)
+	assert.Equal(t, "2 CVEs: 1 critical, 1 medium\nCVE-2024-0001 (critical) openssl 3.0.8, fixed in 3.0.13\nCVE-2024-0002 (medium) zlib 1.2.13, no fix", n.Message)
+	assert.Equal(t, "cve-report-abc123.txt", n.Attachment.Filename)
+	assert.Equal(t, "full report", string(n.Attachment.Content))
+
+	// Every link carries a token for its own action on the run instead of an API token
+	report, err := url.Parse(n.ClickURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/v1/scans/run-1/report", report.Path)
+	assert.Equal(t, "html", report.Query().Get("format"))
+	assert.NoError(t, signer.Verify("run-1", links.ActionReport, report.Query().Get("token")))
+	assert.Len(t, n.Actions, 3)
+	assert.Equal(t, "Open report", n.Actions[0].Label)
+	ack, _ := url.Parse(n.Actions[1].URL)
+	assert.Equal(t, "/api/v1/scans/run-1/ack", ack.Path)
+	assert.NoError(t, signer.Verify("run-1", links.ActionAck, ack.Query().Get("token")))
+	assert.Error(t, signer.Verify("run-1", links.ActionRescan, ack.Query().Get("token")))
+	assert.Empty(t, n.Actions[1].Headers["Authorization"])
+	rescan, _ := url.Parse(n.Actions[2].URL)
+	assert.Equal(t, "/api/v1/scans/run-1/rescan", rescan.Path)
+	assert.NoError(t, signer.Verify("run-1", links.ActionRescan, rescan.Query().Get("token")))
+	assert.Equal(t, `{"container":"abc123"}`, n.Actions[2].Body)
+}
+
+func TestSendCVEReport_WithoutAPI(t *testing.T) {
+	ntfy := &recordingNtfy{}
+	service := docker.NewNotificationService(notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(ntfy, notify.FormatSummary)})
+
+	target := docker.ScanTarget{Image: "registry.local/app:1.4"}
+	err := service.SendCVEReport(context.Background(), "run-1", target, nil, "CVE Report")
+	assert.NoError(t, err)
+
+	n := ntfy.published[0]
+	assert.Equal(t, "No CVEs found", n.Message)
+	assert.Equal(t, gotfy.Min, n.Priority)
+	assert.Equal(t, "cve-report-registry.local_app_1.4.txt", n.Attachment.Filename)
+	assert.Empty(t, n.ClickURL)
+	assert.Empty(t, n.Actions)
+}
+
+// failingNotifier fails every message
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	return errors.New("unreachable")
+}
+
+func TestSendCVEReport_ChannelFilters(t *testing.T) {
+	critical := &recordingNtfy{}
+	everything := &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "failing", Notifier: failingNotifier{}},
+		notify.Channel{Name: "critical", Notifier: notify.NewNtfyNotifierWithClient(critical, ""), MinSeverity: "critical"},
+		notify.Channel{Name: "everything", Notifier: notify.NewNtfyNotifierWithClient(everything, "")},
+	)
+
+	// A failing channel does not stop delivery to the others
+	target := docker.ScanTarget{ContainerID: "abc123"}
+	err := service.SendCVEReport(context.Background(), "", target, reportCVEs[:1], "report")
+	assert.ErrorContains(t, err, "failing")
+	assert.Len(t, critical.published, 0)
+	assert.Len(t, everything.published, 1)
+
+	service.SendCVEReport(context.Background(), "", target, reportCVEs, "report")
+	assert.Len(t, critical.published, 1)
+
+	// Status messages only go to channels without a severity filter
+	service.SendNotification(context.Background(), "done", "Scan Complete")
+	assert.Len(t, critical.published, 1)
+	assert.Len(t, everything.published, 3)
+	assert.Equal(t, "done", everything.published[2].Message)
+}
+
+func TestSendCVEReport_Routing(t *testing.T) {
+	payments := &recordingNtfy{}
+	fallback := &recordingNtfy{}
+	other := &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "payments", Notifier: failingNotifier{}},
+		notify.Channel{Name: "mail", Notifier: notify.NewNtfyNotifierWithClient(fallback, "")},
+		notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(other, "")},
+	)
+
+	router, err := routing.NewRouter(routing.Config{
+		Rules: []routing.Rule{{
+			Labels: map[string]string{"team": "payments"},
+			Route:  routing.Route{Channels: []string{"payments"}, Fallback: []string{"mail"}, Topic: "payments"},
+		}},
+		Default: routing.Route{Channels: []string{"ntfy"}},
+	}, service.ChannelNames())
+	assert.NoError(t, err)
+
+	var looked []string
+	service.SetRouter(router, func(ctx context.Context, containerID string) (map[string]string, error) {
+		looked = append(looked, containerID)
+		if containerID == "pay-1" {
+			return map[string]string{"team": "payments"}, nil
+		}
+		return nil, nil
+	})
+
+	// The failed primary channel falls back to mail, keeping the routed topic
+	err = service.SendCVEReport(context.Background(), "", docker.ScanTarget{ContainerID: "pay-1", Image: "pay:1"}, reportCVEs, "report")
+	assert.Error(t, err)
+	assert.Len(t, fallback.published, 1)
+	assert.Equal(t, "payments", fallback.published[0].Topic)
+	assert.Len(t, payments.published, 0)
+	assert.Len(t, other.published, 0)
+
+	assert.NoError(t, service.SendCVEReport(context.Background(), "", docker.ScanTarget{ContainerID: "web-1", Image: "web:1"}, reportCVEs, "report"))
+	assert.Len(t, other.published, 1)
+	assert.Equal(t, []string{"pay-1", "web-1"}, looked)
+
+	// Status messages take the default route
+	assert.NoError(t, service.SendNotification(context.Background(), "done", "Scan Complete"))
+	assert.Len(t, other.published, 2)
+}
+
+func TestSendCVEReport_BatchedFallback(t *testing.T) {
+	fallback := &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "payments", Notifier: notify.NewBatcher(failingNotifier{}, 0)},
+		notify.Channel{Name: "mail", Notifier: notify.NewNtfyNotifierWithClient(fallback, "")},
+	)
+	router, err := routing.NewRouter(routing.Config{
+		Default: routing.Route{Channels: []string{"payments"}, Fallback: []string{"mail"}},
+	}, service.ChannelNames())
+	assert.NoError(t, err)
+	service.SetRouter(router, nil)
+
+	// Queuing cannot fail, the fallback is used once the digest fails to send
+	ctx := context.Background()
+	assert.NoError(t, service.SendCVEReport(ctx, "run-1", docker.ScanTarget{ContainerID: "a"}, reportCVEs, "report a"))
+	assert.NoError(t, service.SendCVEReport(ctx, "run-1", docker.ScanTarget{ContainerID: "b"}, reportCVEs, "report b"))
+	assert.Len(t, fallback.published, 0)
+
+	err = service.FlushRun(ctx, "run-1")
+	assert.ErrorContains(t, err, "failed to send digest to payments: unreachable")
+	assert.Len(t, fallback.published, 1)
+	assert.Equal(t, "CVE Scan Digest: 2 targets, 4 CVEs, worst critical", fallback.published[0].Title)
+}
+
+func TestFlushRun(t *testing.T) {
+	ntfy := &recordingNtfy{}
+	service := docker.NewNotificationService(notify.Channel{Name: "ntfy", Notifier: notify.NewBatcher(notify.NewNtfyNotifierWithClient(ntfy, ""), 0)})
+
+	ctx := store.WithRunID(context.Background(), "run-1")
+	assert.NoError(t, service.SendCVEReport(ctx, "run-1", docker.ScanTarget{ContainerID: "a"}, reportCVEs, "report a"))
+	assert.NoError(t, service.SendCVEReport(ctx, "run-1", docker.ScanTarget{ContainerID: "b"}, reportCVEs, "report b"))
+	assert.NoError(t, service.SendNotification(ctx, "done", "Scan Complete"))
+	assert.Len(t, ntfy.published, 0)
+
+	assert.NoError(t, service.FlushRun(ctx, "run-1"))
+	assert.Len(t, ntfy.published, 1)
+	assert.Equal(t, "CVE Scan Digest: 2 targets, 4 CVEs, worst critical", ntfy.published[0].Title)
+	assert.Equal(t, "report a\nreport b", string(ntfy.published[0].Attachment.Content))
+
+	// Status messages outside of a run are not held back
+	assert.NoError(t, service.SendNotification(context.Background(), "the previous configuration stays in effect", "Configuration reload failed"))
+	assert.Len(t, ntfy.published, 2)
+}
+
+func TestQuietHours(t *testing.T) {
+	ntfy := &recordingNtfy{}
+	service := docker.NewNotificationService(notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(ntfy, "")})
+	quiet, err := alerting.NewSchedule(alerting.QuietHours{Start: "20:00", End: "08:00", Timezone: "UTC"})
+	assert.NoError(t, err)
+	service.SetQuietHours(quiet)
+	service.SetClock(func() time.Time { return time.Date(2026, 10, 14, 23, 0, 0, 0, time.UTC) })
+
+	// Only critical findings get through at night
+	mediumOnly := reportCVEs[:1]
+	assert.NoError(t, service.SendCVEReport(context.Background(), "run-1", docker.ScanTarget{ContainerID: "a"}, mediumOnly, "report a"))
+	assert.NoError(t, service.SendNotification(context.Background(), "done", "Scan Complete"))
+	assert.Len(t, ntfy.published, 0)
+
+	assert.NoError(t, service.SendCVEReport(context.Background(), "run-1", docker.ScanTarget{ContainerID: "b"}, reportCVEs, "report b"))
+	assert.Len(t, ntfy.published, 1)
+
+	// The route dry run asks the same questions
+	assert.True(t, service.SuppressedNow("medium"))
+	assert.False(t, service.SuppressedNow("critical"))
+}
+
+func TestAcceptingChannels(t *testing.T) {
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "pager", Notifier: notify.NewNtfyNotifierWithClient(&recordingNtfy{}, ""), MinSeverity: "critical"},
+		notify.Channel{Name: "chat", Notifier: notify.NewNtfyNotifierWithClient(&recordingNtfy{}, ""), MinSeverity: "medium"},
+		notify.Channel{Name: "log", Notifier: notify.NewNtfyNotifierWithClient(&recordingNtfy{}, "")},
+	)
+
+	accepting, skipping := service.AcceptingChannels([]string{"pager", "chat", "log"}, "High")
+	assert.Equal(t, []string{"chat", "log"}, accepting)
+	assert.Equal(t, []string{"pager"}, skipping)
+
+	// Status messages have no severity and only reach channels without a minimum
+	accepting, skipping = service.AcceptingChannels([]string{"pager", "log"}, "")
+	assert.Equal(t, []string{"log"}, accepting)
+	assert.Equal(t, []string{"pager"}, skipping)
+}
+
+func TestSendAlert(t *testing.T) {
+	primary := &recordingNtfy{}
+	oncall := &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(primary, "")},
+		notify.Channel{Name: "oncall", Notifier: notify.NewNtfyNotifierWithClient(oncall, "")},
+	)
+	service.SetAPI("https://scanner.example", links.NewSigner("link-secret", time.Hour))
+
+	run := store.Run{
+		ID:     "run-1",
+		Target: "running",
+		CVEs:   map[string][]tableprinter.CVEInfo{"web": reportCVEs, "db": nil},
+		Alert:  &store.Alert{Severity: "critical", RaisedAt: time.Date(2026, 10, 14, 9, 0, 0, 0, time.Local), Targets: []string{"web"}},
+	}
+
+	assert.NoError(t, service.SendAlert(context.Background(), run, false, nil))
+	assert.Len(t, primary.published, 1)
+	assert.Len(t, oncall.published, 1)
+	reminder := primary.published[0]
+	assert.Equal(t, "Unacknowledged: critical CVEs in web since 2026-10-14 09:00", reminder.Title)
+	assert.True(t, strings.HasPrefix(reminder.Actions[1].URL, "https://scanner.example/api/v1/scans/run-1/ack?token="))
+
+	assert.NoError(t, service.SendAlert(context.Background(), run, true, []string{"oncall"}))
+	assert.Len(t, primary.published, 1)
+	assert.Len(t, oncall.published, 2)
+	assert.Equal(t, "Escalated: critical CVEs in web since 2026-10-14 09:00", oncall.published[1].Title)
+}
+
+func TestSendAlert_RunBatched(t *testing.T) {
+	primary := &recordingNtfy{}
+	oncall := &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "ntfy", Notifier: notify.NewBatcher(notify.NewNtfyNotifierWithClient(primary, ""), 0)},
+		notify.Channel{Name: "oncall", Notifier: notify.NewBatcher(notify.NewNtfyNotifierWithClient(oncall, ""), 0)},
+	)
+	run := store.Run{
+		ID:    "run-1",
+		CVEs:  map[string][]tableprinter.CVEInfo{"web": reportCVEs},
+		Alert: &store.Alert{Severity: "critical", RaisedAt: time.Date(2026, 10, 14, 9, 0, 0, 0, time.Local), Targets: []string{"web"}},
+	}
+
+	// The run's digest went out when its scan finished, alerts are sent right away
+	assert.NoError(t, service.SendAlert(context.Background(), run, false, nil))
+	assert.Len(t, primary.published, 1)
+	assert.Len(t, oncall.published, 1)
+	assert.NoError(t, service.SendAlert(context.Background(), run, true, []string{"oncall"}))
+	assert.Len(t, oncall.published, 2)
+	assert.Contains(t, oncall.published[1].Title, "Escalated")
+}
+
+func TestSendTest(t *testing.T) {
+	ntfy, other := &recordingNtfy{}, &recordingNtfy{}
+	service := docker.NewNotificationService(
+		notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(ntfy, notify.FormatSummary), MinSeverity: "critical"},
+		notify.Channel{Name: "other", Notifier: notify.NewNtfyNotifierWithClient(other, notify.FormatSummary)},
+	)
+
+	// Severity filters do not hold the test message back
+	assert.NoError(t, service.SendTest(context.Background(), []string{"ntfy"}))
+	assert.Len(t, ntfy.published, 1)
+	assert.Equal(t, "Test notification", ntfy.published[0].Title)
+	assert.Empty(t, other.published)
+
+	assert.NoError(t, service.SendTest(context.Background(), nil))
+	assert.Len(t, ntfy.published, 2)
+	assert.Len(t, other.published, 1)
+
+	assert.ErrorContains(t, service.SendTest(context.Background(), []string{"pager"}), `unknown notification channel "pager"`)
+}
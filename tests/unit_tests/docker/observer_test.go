@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingObserver collects the stages reported by the pipeline
+type recordingObserver struct {
+	mu       sync.Mutex
+	stages   []string
+	failures []string
+}
+
+func (o *recordingObserver) ObserveStage(stage string, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stages = append(o.stages, stage)
+	if err != nil {
+		o.failures = append(o.failures, stage)
+	}
+}
+
+func TestScanTargets_ReportsStages(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"syft nginx -o json":  `{"sbom": "nginx-sbom"}`,
+			"grype nginx -o json": `{"matches": []}`,
+			"syft redis -o json":  `{"sbom": "redis-sbom"}`,
+		},
+		FailCommands: map[string]bool{
+			"grype redis -o json": true,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	observer := &recordingObserver{}
+	ds.SetStageObserver(observer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ds.ScanTargets(ctx, []docker.ScanTarget{{Image: "nginx"}, {Image: "redis"}})
+
+	assert.ElementsMatch(t, []string{docker.StageSyft, docker.StageSyft, docker.StageGrype, docker.StageGrype, docker.StageParse}, observer.stages)
+	assert.Equal(t, []string{docker.StageGrype}, observer.failures)
+}
+
+func TestVulnerabilityDBBuilt(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"grype db status -o json": `{"schemaVersion": "v6.0.2", "built": "2024-11-01T04:23:11Z", "valid": true}`,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	built, err := ds.VulnerabilityDBBuilt(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.November, 1, 4, 23, 11, 0, time.UTC), built)
+}
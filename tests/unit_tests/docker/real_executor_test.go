@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessLimits_Override(t *testing.T) {
+	global := docker.ProcessLimits{Nice: 10, IOClass: docker.IOClassBestEffort, IOPriority: 7, MaxOutput: 1024}
+	stage := docker.ProcessLimits{IOClass: docker.IOClassIdle, Memory: 2048}
+
+	assert.Equal(t, docker.ProcessLimits{Nice: 10, IOClass: docker.IOClassIdle, Memory: 2048, MaxOutput: 1024}, global.Override(stage))
+	assert.Equal(t, global, global.Override(docker.ProcessLimits{}))
+}
+
+func TestRealCommandExecutor_SeparatesOutput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs a POSIX shell")
+	}
+	executor := &docker.RealCommandExecutor{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Warnings on stderr do not end up in the output callers parse
+	result, err := executor.ExecCommand(ctx, "sh", "-c", `echo '{"matches": []}'; echo 'warning: db is stale' >&2`)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"matches\": []}\n", string(result.Stdout))
+	assert.Equal(t, "warning: db is stale\n", string(result.Stderr))
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Positive(t, result.Duration)
+
+	_, err = executor.ExecCommand(ctx, "sh", "-c", "echo 'no such image: nginx' >&2; exit 3")
+	var execErr *docker.ExecError
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, 3, execErr.ExitCode)
+	assert.Equal(t, "sh exited with code 3: no such image: nginx", err.Error())
+
+	_, err = executor.ExecCommand(ctx, "no-such-command-for-sure")
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, -1, execErr.ExitCode)
+}
+
+func TestRealCommandExecutor_OutputLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process limits need Linux")
+	}
+	executor := &docker.RealCommandExecutor{Limits: map[string]docker.ProcessLimits{"sh": {MaxOutput: 1000}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A process writing without end is stopped instead of filling the memory
+	result, err := executor.ExecCommand(ctx, "sh", "-c", "yes")
+	assert.ErrorContains(t, err, "sh failed: stopped, its output exceeded 1000 bytes")
+	assert.LessOrEqual(t, len(result.Stdout), 1000)
+
+	result, err = executor.ExecCommand(ctx, "sh", "-c", "echo fits")
+	assert.NoError(t, err)
+	assert.Equal(t, "fits\n", string(result.Stdout))
+}
+
+func TestRealCommandExecutor_Nice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process limits need Linux")
+	}
+	executor := &docker.RealCommandExecutor{Limits: map[string]docker.ProcessLimits{
+		"sh": {Nice: 19, IOClass: docker.IOClassIdle},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The priority applies to the processes the command spawns as well
+	result, err := executor.ExecCommand(ctx, "sh", "-c", "sleep 0.2; nice")
+	assert.NoError(t, err)
+	assert.Equal(t, "19", strings.TrimSpace(string(result.Stdout)))
+
+	_, err = executor.ExecCommand(ctx, "sh", "-c", "true")
+	assert.NoError(t, err)
+
+	// CPU and memory limits need a cgroup to enforce them
+	executor.Limits["sh"] = docker.ProcessLimits{Memory: 1 << 30}
+	_, err = executor.ExecCommand(ctx, "sh", "-c", "true")
+	assert.ErrorContains(t, err, "need a cgroup directory")
+}
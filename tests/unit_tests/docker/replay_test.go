@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayExecutor_Session(t *testing.T) {
+	replay, err := docker.LoadReplayExecutor(filepath.Join("testdata", "running_session.json"))
+	assert.NoError(t, err)
+	ds := docker.NewDockerSBOMService(replay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The whole pipeline runs offline against the recorded syft and grype session
+	sbomResults, cveResults, err := ds.GenerateSBOMAndScanForCVEs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, sbomResults, 2)
+	assert.Len(t, cveResults, 1)
+	cves := cveResults["3f2a9c1d0b7e"]
+	assert.Len(t, cves, 1)
+	assert.Equal(t, "CVE-2024-0001", cves[0].CVEName)
+	assert.Equal(t, "3.0.9", cves[0].ResolvedVersion)
+	assert.Empty(t, replay.Unused())
+}
+
+func TestRecordingExecutor_RoundTrip(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{"syft nginx -o json": `{"sbom": "nginx-sbom"}`},
+		FailCommands:   map[string]bool{"grype nginx -o json": true},
+	}
+	path := filepath.Join(t.TempDir(), "fixtures", "session.json")
+	recorder := docker.NewRecordingExecutor(executor, path)
+
+	ctx := context.Background()
+	_, err := recorder.ExecCommand(ctx, "syft", "nginx", "-o", "json")
+	assert.NoError(t, err)
+	_, recordedErr := recorder.ExecCommand(ctx, "grype", "nginx", "-o", "json")
+	assert.Error(t, recordedErr)
+	assert.NoError(t, recorder.Save())
+
+	replay, err := docker.LoadReplayExecutor(path)
+	assert.NoError(t, err)
+
+	// Calls are served in any order, failures included
+	_, err = replay.ExecCommand(ctx, "grype", "nginx", "-o", "json")
+	var execErr *docker.ExecError
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, 1, execErr.ExitCode)
+	assert.Equal(t, recordedErr.Error(), err.Error())
+	assert.Len(t, replay.Unused(), 1)
+
+	result, err := replay.ExecCommand(ctx, "syft", "nginx", "-o", "json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"sbom": "nginx-sbom"}`, string(result.Stdout))
+	assert.Empty(t, replay.Unused())
+
+	// Each recorded call is served once
+	_, err = replay.ExecCommand(ctx, "syft", "nginx", "-o", "json")
+	assert.ErrorContains(t, err, `"syft nginx -o json" was recorded 1 times and is called once more`)
+}
+
+func TestReplayExecutor_Mismatch(t *testing.T) {
+	replay := docker.NewReplayExecutor(docker.Fixture{Exchanges: []docker.Exchange{
+		{Command: "grype", Args: []string{"redis:7", "-o", "json"}},
+		{Command: "grype", Args: []string{"nginx:1.27", "-o", "json"}},
+	}})
+
+	_, err := replay.ExecCommand(context.Background(), "grype", "nginx:1.25", "-o", "json", "--only-fixed")
+	assert.EqualError(t, err, `grype failed: replay: no recorded invocation of grype
+  called:   grype nginx:1.25 -o json --only-fixed
+  recorded: grype nginx:1.27 -o json
+  - arg 1: "nginx:1.27"
+  + arg 1: "nginx:1.25"
+  + arg 4: "--only-fixed"`)
+
+	_, err = replay.ExecCommand(context.Background(), "syft", "nginx:1.27")
+	assert.ErrorContains(t, err, "syft was never recorded")
+}
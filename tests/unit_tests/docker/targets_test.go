@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/registry"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTargets(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"docker ps --format {{.ID}} {{.Image}}":                                     "12345 nginx\n67890 redis",
+			"docker inspect --format {{.Id}} {{.Image}} 12345 67890":                    "12345aaaa sha256:aaa\n67890bbbb sha256:bbb",
+			"docker inspect --format {{.Id}} {{.Config.Image}} {{.State.Status}} web-1": "0123456789abcdef0123 nginx:1.27 running\n",
+			"docker inspect --format {{.Id}} {{.Config.Image}} {{.State.Status}} job-1": "fedcba9876543210fedc alpine:3.20 exited\n",
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The digests tell which containers share an image
+	targets, err := ds.ResolveTargets(ctx, docker.TargetRunning)
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{{ContainerID: "12345", Image: "nginx", Digest: "sha256:aaa"}, {ContainerID: "67890", Image: "redis", Digest: "sha256:bbb"}}, targets)
+
+	targets, err = ds.ResolveTargets(ctx, "container:web-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{{ContainerID: "0123456789ab", Image: "nginx:1.27"}}, targets)
+
+	targets, err = ds.ResolveTargets(ctx, "container:job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{{ContainerID: "fedcba987654", Image: "alpine:3.20", Stopped: true}}, targets)
+
+	targets, err = ds.ResolveTargets(ctx, "image:alpine:3.20")
+	assert.NoError(t, err)
+	assert.Equal(t, "alpine:3.20", targets[0].Key())
+
+	_, err = ds.ResolveTargets(ctx, "volume:data")
+	assert.Error(t, err)
+}
+
+func TestResolveTargets_Sets(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"docker ps --format {{.ID}} {{.Image}}":                                                         "12345 nginx",
+			"docker ps --all --format {{.ID}} {{.Image}} {{.State}}":                                        "12345 nginx running\n67890 redis exited",
+			"docker images --no-trunc --format {{.ID}} {{.Repository}}:{{.Tag}}":                            "sha256:aaa nginx:latest\nsha256:bbb redis:7\nsha256:bbb redis:latest\nsha256:ccc <none>:<none>",
+			"docker images --no-trunc --filter dangling=true --format {{.ID}} {{.Repository}}:{{.Tag}}":     "sha256:ccc <none>:<none>",
+			"docker images --no-trunc --filter reference=redis:* --format {{.ID}} {{.Repository}}:{{.Tag}}": "sha256:bbb redis:7\nsha256:bbb redis:latest",
+			"docker inspect --format {{.Id}} {{.Image}} 12345 67890":                                        "12345aaaa sha256:aaa\n67890bbbb sha256:bbb",
+			"docker inspect --format {{.Id}} {{.Image}} 12345":                                              "12345aaaa sha256:aaa",
+			"docker image inspect --format {{.Id}} nginx:latest":                                            "sha256:aaa\n",
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	ctx := context.Background()
+
+	// Stopped containers are scanned by the ID of the image they were created from
+	targets, err := ds.ResolveTargets(ctx, docker.TargetAll)
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{{ContainerID: "12345", Image: "nginx", Digest: "sha256:aaa"}, {ContainerID: "67890", Image: "redis", Digest: "sha256:bbb", Stopped: true}}, targets)
+
+	// Images with several tags are scanned once, untagged ones by their ID
+	targets, err = ds.ResolveTargets(ctx, docker.TargetImages)
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{
+		{Image: "nginx:latest", Digest: "sha256:aaa"},
+		{Image: "redis:7", Digest: "sha256:bbb"},
+		{Image: "sha256:ccc", Digest: "sha256:ccc"},
+	}, targets)
+
+	targets, err = ds.ResolveTargets(ctx, "filter:redis:*")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{{Image: "redis:7", Digest: "sha256:bbb"}}, targets)
+
+	// Images used by containers are left out, containers listed twice are kept once
+	targets, err = ds.ResolveTargets(ctx, "running,all,images")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{
+		{ContainerID: "12345", Image: "nginx", Digest: "sha256:aaa"},
+		{ContainerID: "67890", Image: "redis", Digest: "sha256:bbb", Stopped: true},
+		{Image: "sha256:ccc", Digest: "sha256:ccc"},
+	}, targets)
+
+	// Single image references are compared by the digest of the local image
+	targets, err = ds.ResolveTargets(ctx, "running,image:nginx:latest,dangling")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{
+		{ContainerID: "12345", Image: "nginx", Digest: "sha256:aaa"},
+		{Image: "sha256:ccc", Digest: "sha256:ccc"},
+	}, targets)
+
+	for _, selector := range []string{"running,", "filter:", "images,volume:data", "filter:--all"} {
+		assert.Error(t, docker.ValidateTarget(selector), selector)
+	}
+}
+
+func TestResolveTargets_Registry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/app/tags/list":
+			fmt.Fprint(w, `{"name": "app", "tags": ["1.4.0", "1.5.0-rc1", "1.5.0-rc2", "latest"]}`)
+		case "/v2/app/manifests/1.5.0-rc1":
+			w.Header().Set("Docker-Content-Digest", "sha256:rc1")
+		case "/v2/app/manifests/1.5.0-rc2", "/v2/app/manifests/latest":
+			w.Header().Set("Docker-Content-Digest", "sha256:rc2")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ds := docker.NewDockerSBOMService(&MockCommandExecutor{})
+	ds.SetRegistry(registry.NewClient(registry.Config{Insecure: []string{host}}))
+
+	// Images are passed to syft and grype as registry sources, so they are not pulled
+	targets, err := ds.ResolveTargets(context.Background(), "registry:"+host+"/app:>1.4.0,registry:"+host+"/app:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{
+		{Image: "registry:" + host + "/app:1.5.0-rc1", Digest: "sha256:rc1"},
+		{Image: "registry:" + host + "/app:1.5.0-rc2", Digest: "sha256:rc2"},
+	}, targets)
+
+	_, err = ds.ResolveTargets(context.Background(), "registry:"+host+"/app:2.0.0")
+	assert.Error(t, err)
+	assert.Error(t, docker.ValidateTarget("registry:registry.local/app:>=x"))
+}
+
+func TestResolveTargets_Files(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.tar", "worker.tar"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("tarball"), 0o600))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "oci"), 0o700))
+	app, worker, oci := filepath.Join(dir, "app.tar"), filepath.Join(dir, "worker.tar"), filepath.Join(dir, "oci")
+
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"syft docker-archive:" + app + " -o json":  `{"artifacts": []}`,
+			"grype docker-archive:" + app + " -o json": `{"matches": [{"vulnerability": {"id": "CVE-2024-0001", "severity": "High"}, "artifact": {"name": "openssl", "version": "3.0.1"}}]}`,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+	ctx := context.Background()
+
+	// Globs select every file, each listed once even when selected twice
+	targets, err := ds.ResolveTargets(ctx, "docker-archive:"+filepath.Join(dir, "*.tar")+",docker-archive:"+app+",oci-dir:"+oci)
+	assert.NoError(t, err)
+	assert.Equal(t, []docker.ScanTarget{
+		{Image: "docker-archive:" + app},
+		{Image: "docker-archive:" + worker},
+		{Image: "oci-dir:" + oci},
+	}, targets)
+
+	for _, selector := range []string{"oci-dir:" + app, "oci-archive:" + oci, "docker-archive:" + filepath.Join(dir, "*.tgz")} {
+		_, err = ds.ResolveTargets(ctx, selector)
+		assert.Error(t, err, selector)
+	}
+	assert.Error(t, docker.ValidateTarget("oci-archive:"))
+
+	// The files are passed to syft and grype as sources and reported like images
+	_, cveResults, _ := ds.ScanTargets(ctx, targets[:1])
+	assert.Len(t, cveResults["docker-archive:"+app], 1)
+	assert.Equal(t, "CVE-2024-0001", cveResults["docker-archive:"+app][0].CVEName)
+}
+
+func TestContainerLabels(t *testing.T) {
+	mockExecutor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"docker inspect --format {{json .Config.Labels}} web-1": `{"com.docker.compose.project":"shop","team":"payments"}` + "\n",
+		},
+	}
+	ds := docker.NewDockerSBOMService(mockExecutor)
+
+	labels, err := ds.ContainerLabels(context.Background(), "web-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", labels["team"])
+	assert.Equal(t, "shop", labels["com.docker.compose.project"])
+
+	_, err = ds.ContainerLabels(context.Background(), "missing")
+	assert.Error(t, err)
+}
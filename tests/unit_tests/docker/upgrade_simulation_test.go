@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulatePackageUpgrades_APK(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"docker exec 12345 sh -c command -v apk || command -v apt-get": "/sbin/apk\n",
+			"docker exec 12345 apk --no-cache upgrade --simulate": "fetch https://dl-cdn.alpinelinux.org/alpine/v3.18/main/x86_64/APKINDEX.tar.gz\n" +
+				"(1/2) Upgrading libcrypto3 (3.0.8-r0 -> 3.0.13-r0)\n" +
+				"(2/2) Upgrading busybox (1.36.1-r0 -> 1.36.1-r2)\n" +
+				"OK: 7 MiB in 15 packages\n",
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cveList := []tableprinter.CVEInfo{
+		{CVEName: "CVE-2023-0001", Package: "libcrypto3", CurrentVersion: "3.0.8-r0", ResolvedVersion: "3.0.12-r0"},
+		{CVEName: "CVE-2023-0002", Package: "busybox", CurrentVersion: "1.36.1-r0", ResolvedVersion: "1.36.1-r5"},
+		{CVEName: "CVE-2023-0003", Package: "zlib", CurrentVersion: "1.2.13-r0", ResolvedVersion: "1.2.13-r1"},
+		{CVEName: "CVE-2023-0004", Package: "libcrypto3", CurrentVersion: "3.0.8-r0"},
+	}
+
+	simulation, err := ds.SimulatePackageUpgrades(ctx, "12345", cveList)
+	assert.NoError(t, err)
+	assert.Equal(t, docker.PackageManagerAPK, simulation.PackageManager)
+	assert.Equal(t, 2, len(simulation.Upgrades))
+	assert.Equal(t, "3.0.13-r0", simulation.Upgrades[0].CandidateVersion)
+
+	// Only the libcrypto3 CVE with a known fix is closed by the upgrade
+	assert.Equal(t, 1, len(simulation.ClosedCVEs))
+	assert.Equal(t, "CVE-2023-0001", simulation.ClosedCVEs[0].CVEName)
+	assert.Equal(t, 3, len(simulation.RemainingCVEs))
+}
+
+func TestSimulatePackageUpgrades_APT(t *testing.T) {
+	executor := &MockCommandExecutor{
+		CommandOutputs: map[string]string{
+			"docker exec 67890 sh -c command -v apk || command -v apt-get": "/usr/bin/apt-get\n",
+			"docker exec 67890 sh -c apt-get update -qq && apt-get -s upgrade": "Reading package lists...\n" +
+				"Inst libssl3 [3.0.11-1~deb12u1] (3.0.11-1~deb12u2 Debian-Security:12/stable-security [amd64])\n" +
+				"Conf libssl3 (3.0.11-1~deb12u2 Debian-Security:12/stable-security [amd64])\n",
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cveList := []tableprinter.CVEInfo{
+		{CVEName: "CVE-2024-0727", Package: "libssl3", CurrentVersion: "3.0.11-1~deb12u1", ResolvedVersion: "3.0.11-1~deb12u2"},
+	}
+
+	simulation, err := ds.SimulatePackageUpgrades(ctx, "67890", cveList)
+	assert.NoError(t, err)
+	assert.Equal(t, docker.PackageManagerAPT, simulation.PackageManager)
+	assert.Equal(t, 1, len(simulation.ClosedCVEs))
+	assert.Empty(t, simulation.RemainingCVEs)
+}
+
+func TestSimulatePackageUpgrades_NoPackageManager(t *testing.T) {
+	executor := &MockCommandExecutor{
+		FailCommands: map[string]bool{
+			"docker exec 12345 sh -c command -v apk || command -v apt-get": true,
+		},
+	}
+	ds := docker.NewDockerSBOMService(executor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := ds.SimulatePackageUpgrades(ctx, "12345", nil)
+	assert.Error(t, err)
+}
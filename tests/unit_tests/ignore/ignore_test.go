@@ -0,0 +1,49 @@
+package ignore
+
+import (
+	"AutomaticCVEResolver/services/ignore"
+	"AutomaticCVEResolver/services/tableprinter"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList_Filter(t *testing.T) {
+	list, err := ignore.NewList([]ignore.Rule{
+		{CVE: "CVE-2023-44487", Package: "golang.org/x/net", Reason: "HTTP/2 is not exposed"},
+		{CVE: "cve-2024-0001"},
+		{CVE: "CVE-2024-0002", Until: "2026-10-01"},
+	})
+	assert.NoError(t, err)
+	list.SetClock(func() time.Time { return time.Date(2026, 10, 14, 9, 0, 0, 0, time.Local) })
+
+	filtered, ignored := list.Filter(map[string][]tableprinter.CVEInfo{
+		"web": {
+			{CVEName: "CVE-2023-44487", Package: "golang.org/x/net"},
+			{CVEName: "CVE-2023-44487", Package: "nghttp2"},
+			{CVEName: "CVE-2024-0002"},
+		},
+		"db": {{CVEName: "CVE-2024-0001"}},
+	})
+	assert.Equal(t, 2, ignored)
+	assert.Equal(t, map[string][]tableprinter.CVEInfo{
+		"web": {{CVEName: "CVE-2023-44487", Package: "nghttp2"}, {CVEName: "CVE-2024-0002"}},
+		"db":  nil,
+	}, filtered)
+}
+
+func TestNewList(t *testing.T) {
+	list, err := ignore.NewList(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, list)
+	results := map[string][]tableprinter.CVEInfo{"web": {{CVEName: "CVE-2024-0001"}}}
+	filtered, ignored := list.Filter(results)
+	assert.Equal(t, results, filtered)
+	assert.Zero(t, ignored)
+
+	_, err = ignore.NewList([]ignore.Rule{{Package: "openssl"}})
+	assert.EqualError(t, err, "rule 1: cve is required")
+	_, err = ignore.NewList([]ignore.Rule{{CVE: "CVE-2024-0001", Until: "31.12.2026"}})
+	assert.EqualError(t, err, `CVE-2024-0001: until expects YYYY-MM-DD, got "31.12.2026"`)
+}
@@ -0,0 +1,32 @@
+package links
+
+import (
+	"AutomaticCVEResolver/services/links"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	signer := links.NewSigner("link-secret", time.Hour)
+	signer.SetClock(func() time.Time { return now })
+
+	token := signer.Sign("run-1", links.ActionAck)
+	assert.NoError(t, signer.Verify("run-1", links.ActionAck, token))
+
+	// A token grants its action on its run only
+	assert.Error(t, signer.Verify("run-2", links.ActionAck, token))
+	assert.Error(t, signer.Verify("run-1", links.ActionRescan, token))
+	assert.Error(t, links.NewSigner("other-secret", time.Hour).Verify("run-1", links.ActionAck, token))
+	assert.Error(t, signer.Verify("run-1", links.ActionAck, "malformed"))
+
+	// Moving the expiry invalidates the signature
+	_, signature, _ := strings.Cut(token, ".")
+	assert.Error(t, signer.Verify("run-1", links.ActionAck, "9999999999."+signature))
+
+	now = now.Add(2 * time.Hour)
+	assert.ErrorContains(t, signer.Verify("run-1", links.ActionAck, token), "expired")
+}
@@ -0,0 +1,64 @@
+package logging_test
+
+import (
+	"AutomaticCVEResolver/services/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatJSON, "info")
+	assert.NoError(t, err)
+
+	logger.With("run_id", "abc").Info("Scan finished", "cves", 3)
+	logger.Debug("Hidden")
+
+	var entry map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "Scan finished", entry["msg"])
+	assert.Equal(t, "abc", entry["run_id"])
+	assert.Equal(t, float64(3), entry["cves"])
+	assert.NotContains(t, buf.String(), "Hidden")
+}
+
+func TestNewTextDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "", "")
+	assert.NoError(t, err)
+
+	logger.Info("Starting scan", "target", "running")
+	assert.True(t, strings.Contains(buf.String(), `msg="Starting scan" target=running`), buf.String())
+}
+
+func TestNewDebugLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatText, "debug")
+	assert.NoError(t, err)
+
+	logger.Debug("Visible")
+	assert.Contains(t, buf.String(), "Visible")
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := logging.New(&bytes.Buffer{}, "xml", "info")
+	assert.Error(t, err)
+
+	_, err = logging.New(&bytes.Buffer{}, logging.FormatText, "verbose")
+	assert.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := slog.Default()
+	assert.Same(t, fallback, logging.FromContext(context.Background(), fallback))
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	ctx := logging.WithLogger(context.Background(), logger)
+	assert.Same(t, logger, logging.FromContext(ctx, fallback))
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"AutomaticCVEResolver/services/metrics"
+	"AutomaticCVEResolver/services/tableprinter"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Exposition(t *testing.T) {
+	registry := metrics.NewRegistry("host-1")
+	registry.SetStages("syft", "grype", "parse")
+
+	registry.SetVulnerabilities("nginx:1.27", []tableprinter.CVEInfo{
+		{CVEName: "CVE-2024-0001", Severity: "Critical", ResolvedVersion: "3.0.13"},
+		{CVEName: "CVE-2024-0002", Severity: "Critical"},
+		{CVEName: "CVE-2024-0003", Severity: "High", ResolvedVersion: "1.1"},
+	})
+	registry.ObserveStage("syft", 3*time.Second, nil)
+	registry.ObserveStage("grype", 45*time.Second, errors.New("grype failed"))
+	registry.SetLastSuccessfulScan(time.Unix(1700000000, 0))
+	registry.SetVulnerabilityDBBuilt(time.Now().Add(-2 * time.Hour))
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	output := string(body)
+
+	assert.Contains(t, output, `cve_scanner_vulnerabilities{host="host-1",image="nginx:1.27",severity="critical",fixable="false"} 1`)
+	assert.Contains(t, output, `cve_scanner_vulnerabilities{host="host-1",image="nginx:1.27",severity="critical",fixable="true"} 1`)
+	assert.Contains(t, output, `cve_scanner_vulnerabilities{host="host-1",image="nginx:1.27",severity="high",fixable="true"} 1`)
+	assert.Contains(t, output, `cve_scanner_stage_duration_seconds_bucket{stage="syft",le="2.5"} 0`)
+	assert.Contains(t, output, `cve_scanner_stage_duration_seconds_bucket{stage="syft",le="5"} 1`)
+	assert.Contains(t, output, `cve_scanner_stage_duration_seconds_count{stage="grype"} 1`)
+	assert.Contains(t, output, `cve_scanner_stage_failures_total{stage="grype"} 1`)
+	assert.Contains(t, output, `cve_scanner_last_successful_scan_timestamp_seconds 1700000000`)
+	assert.Contains(t, output, `cve_scanner_stage_failures_total{stage="syft"} 0`)
+	assert.Contains(t, output, `cve_scanner_stage_failures_total{stage="parse"} 0`)
+
+	age, ok := sampleValue(output, "cve_scanner_vulnerability_db_age_seconds")
+	assert.True(t, ok)
+	assert.InDelta(t, 7200, age, 60)
+}
+
+func TestRegistry_ExpiresVulnerabilities(t *testing.T) {
+	registry := metrics.NewRegistry("host-1")
+	registry.SetVulnerabilities("removed:1", []tableprinter.CVEInfo{{Severity: "High"}})
+	registry.SetVulnerabilityMaxAge(20 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	registry.SetVulnerabilities("kept:1", []tableprinter.CVEInfo{{Severity: "High"}})
+
+	recorder := httptest.NewRecorder()
+	registry.WriteTo(recorder)
+	assert.NotContains(t, recorder.Body.String(), `image="removed:1"`)
+	assert.Contains(t, recorder.Body.String(), `image="kept:1"`)
+}
+
+// sampleValue returns the value of the sample with the given name and labels in the exposition
+func sampleValue(output, series string) (float64, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			return parsed, err == nil
+		}
+	}
+	return 0, false
+}
+
+func TestRegistry_ReplacesImageCounts(t *testing.T) {
+	registry := metrics.NewRegistry("host-1")
+	registry.SetVulnerabilities("redis:7", []tableprinter.CVEInfo{{Severity: "High"}})
+	registry.SetVulnerabilities("redis:7", []tableprinter.CVEInfo{{Severity: "Low"}})
+
+	recorder := httptest.NewRecorder()
+	registry.WriteTo(recorder)
+	assert.NotContains(t, recorder.Body.String(), `severity="high"`)
+	assert.Contains(t, recorder.Body.String(), `severity="low"`)
+}
@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder captures delivered messages
+type recorder struct {
+	mu   sync.Mutex
+	msgs []notify.Message
+}
+
+func (r *recorder) Notify(ctx context.Context, msg notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.msgs)
+}
+
+func report(runID, target, severity string) notify.Message {
+	cves := []tableprinter.CVEInfo{{CVEName: "CVE-" + target, Severity: severity}}
+	return notify.Message{
+		Title:     "CVE Scan Results for container " + target,
+		Summary:   notify.Summarize(cves),
+		Report:    "CVE Report for " + target,
+		CVEs:      cves,
+		Severity:  notify.WorstSeverity(cves),
+		RunID:     runID,
+		ReportURL: "https://scanner.example/api/v1/scans/" + runID + "/report?format=html",
+	}
+}
+
+func TestBatcher_PerRun(t *testing.T) {
+	inner := &recorder{}
+	batcher := notify.NewBatcher(inner, 0)
+	ctx := context.Background()
+
+	batcher.Notify(ctx, report("run-1", "web", "High"))
+	batcher.Notify(ctx, report("run-2", "db", "Low"))
+	batcher.Notify(ctx, report("run-1", "cache", "Critical"))
+	batcher.Notify(ctx, notify.Message{Title: "Scan Complete", Summary: "SBOM and CVE scanning completed for 2 targets", RunID: "run-1"})
+	assert.Equal(t, 0, inner.count())
+
+	assert.NoError(t, batcher.FlushRun(ctx, "run-1"))
+	assert.Equal(t, 1, inner.count())
+
+	digest := inner.msgs[0]
+	assert.Equal(t, "CVE Scan Digest: 2 targets, 2 CVEs, worst critical", digest.Title)
+	assert.Equal(t, "critical", digest.Severity)
+	assert.Equal(t, "• CVE Scan Results for container web: 1 CVEs: 1 high\n"+
+		"• CVE Scan Results for container cache: 1 CVEs: 1 critical\n"+
+		"SBOM and CVE scanning completed for 2 targets", digest.Summary)
+	assert.Equal(t, "CVE Report for web\nCVE Report for cache", digest.Report)
+	assert.Equal(t, "cve-digest-run-1.txt", digest.Filename)
+	assert.Equal(t, "https://scanner.example/api/v1/scans/run-1/report?format=html", digest.ReportURL)
+
+	// The other run is still held back until flushed
+	assert.NoError(t, batcher.Flush(ctx))
+	assert.Equal(t, 2, inner.count())
+	assert.Equal(t, "CVE Scan Results for container db", inner.msgs[1].Title)
+}
+
+func TestBatcher_Window(t *testing.T) {
+	inner := &recorder{}
+	batcher := notify.NewBatcher(inner, 50*time.Millisecond)
+	ctx := context.Background()
+
+	batcher.Notify(ctx, report("run-1", "web", "High"))
+	batcher.Notify(ctx, report("run-2", "db", "Low"))
+	batcher.Notify(ctx, notify.Message{Topic: "oncall", Summary: "other route"})
+
+	// Windowed batchers ignore the end of runs
+	assert.NoError(t, batcher.FlushRun(ctx, "run-1"))
+	assert.Equal(t, 0, inner.count())
+
+	assert.Eventually(t, func() bool { return inner.count() == 2 }, 5*time.Second, 10*time.Millisecond)
+	inner.mu.Lock()
+	defer inner.mu.Unlock()
+	// Runs are mixed, so the digest cannot link to one of them
+	assert.Equal(t, "CVE Scan Digest: 2 targets, 2 CVEs, worst high", inner.msgs[0].Title)
+	assert.Empty(t, inner.msgs[0].ReportURL)
+	assert.Equal(t, "oncall", inner.msgs[1].Topic)
+}
+
+func TestRateLimiter(t *testing.T) {
+	inner := &recorder{}
+	limiter := notify.NewRateLimiter(inner, 2)
+
+	assert.NoError(t, limiter.Notify(context.Background(), notify.Message{}))
+	assert.NoError(t, limiter.Notify(context.Background(), notify.Message{}))
+
+	// The third message has to wait for most of a minute, longer than the caller is willing to
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := limiter.Notify(ctx, notify.Message{})
+	assert.ErrorContains(t, err, "rate limit of 2 messages per minute reached")
+	assert.Equal(t, 2, inner.count())
+}
+
+func TestTruncate(t *testing.T) {
+	msg := notify.Message{
+		Summary:   strings.Repeat("CVE-2024-0001 (high) openssl 3.0.8, no fix\n", 20),
+		Report:    strings.Repeat("row\n", 100),
+		ReportURL: "https://scanner.example/r",
+	}
+
+	truncated := notify.Truncate(msg, 300, notify.FormatSummary)
+	assert.LessOrEqual(t, len(truncated.Summary), 300)
+	assert.True(t, strings.HasSuffix(truncated.Summary, "\n… truncated, see full report: https://scanner.example/r"), truncated.Summary)
+	// The report is only attached in the summary format and stays complete
+	assert.Equal(t, msg.Report, truncated.Report)
+
+	truncated = notify.Truncate(notify.Message{Summary: "short", Report: msg.Report}, 100, notify.FormatFull)
+	assert.Equal(t, "short", truncated.Summary)
+	assert.LessOrEqual(t, len(truncated.Summary)+len(truncated.Report), 100)
+	assert.True(t, strings.HasPrefix(truncated.Report, "row\nrow\n"))
+
+	assert.Equal(t, msg, notify.Truncate(msg, 10000, notify.FormatFull))
+
+	// A limit too small for the link, or for any note, still holds
+	msg.ReportURL = "https://scanner.example/api/v1/scans/20261018t203511-2ad44493f3545347/report?token=" + strings.Repeat("x", 64)
+	for _, maxBytes := range []int{60, 30, 10} {
+		for _, format := range []string{notify.FormatSummary, notify.FormatFull} {
+			truncated = notify.Truncate(msg, maxBytes, format)
+			inline := len(truncated.Summary)
+			if format == notify.FormatFull {
+				inline += len(truncated.Report)
+			}
+			assert.LessOrEqual(t, inline, maxBytes, "%s format limited to %d bytes", format, maxBytes)
+			assert.NotContains(t, truncated.Summary, msg.ReportURL)
+		}
+	}
+	truncated = notify.Truncate(msg, 60, notify.FormatSummary)
+	assert.Equal(t, "CVE-2024-0001 (high) openssl 3.0.8, no fix\n… truncated", truncated.Summary)
+}
+
+func TestNewChannel_Batching(t *testing.T) {
+	channel, err := notify.NewChannel(notify.ChannelConfig{Type: notify.TypeSlack, URL: "http://slack", Batch: notify.BatchRun, RateLimitPerMinute: 5, MaxMessageBytes: 3000}, nil, nil)
+	assert.NoError(t, err)
+	_, ok := channel.Notifier.(notify.RunFlusher)
+	assert.True(t, ok)
+
+	_, err = notify.NewChannel(notify.ChannelConfig{Type: notify.TypeSlack, URL: "http://slack", Batch: "hourly"}, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,268 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/tableprinter"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testMessage = notify.Message{
+	Title:     "CVE Scan Results for container abc123",
+	Summary:   "1 CVEs: 1 critical\nCVE-2024-0001 (critical) openssl 3.0.8, fixed in 3.0.13",
+	Report:    "CVE Report for abc123:\nCVE-2024-0001  Critical  3.0.8  3.0.13",
+	Filename:  "cve-report-abc123.txt",
+	CVEs:      []tableprinter.CVEInfo{{CVEName: "CVE-2024-0001", Severity: "Critical", Package: "openssl", CurrentVersion: "3.0.8", ResolvedVersion: "3.0.13"}},
+	Severity:  "critical",
+	RunID:     "run-1",
+	ReportURL: "https://scanner.example/api/v1/scans/run-1/report?format=html",
+}
+
+// captured is a request received by the test server
+type captured struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newCapturingServer(t *testing.T) (*httptest.Server, <-chan captured) {
+	requests := make(chan captured, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- captured{method: r.Method, path: r.URL.Path, header: r.Header, body: body}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newChannel(t *testing.T, config notify.ChannelConfig) notify.Channel {
+	channel, err := notify.NewChannel(config, nil, nil)
+	assert.NoError(t, err)
+	return channel
+}
+
+func TestSlack(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeSlack, URL: server.URL, Format: notify.FormatFull})
+	assert.Equal(t, "slack", channel.Name)
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	request := <-requests
+
+	var payload struct {
+		Text        string `json:"text"`
+		Attachments []struct {
+			Color     string `json:"color"`
+			TitleLink string `json:"title_link"`
+			Text      string `json:"text"`
+		} `json:"attachments"`
+	}
+	assert.NoError(t, json.Unmarshal(request.body, &payload))
+	assert.Equal(t, testMessage.Title, payload.Text)
+	assert.Equal(t, "#b00020", payload.Attachments[0].Color)
+	assert.Equal(t, testMessage.ReportURL, payload.Attachments[0].TitleLink)
+	assert.Contains(t, payload.Attachments[0].Text, "```\nCVE Report for abc123")
+}
+
+func TestTeams(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeTeams, URL: server.URL})
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	request := <-requests
+	assert.Contains(t, string(request.body), `"contentType":"application/vnd.microsoft.card.adaptive"`)
+	assert.Contains(t, string(request.body), `"color":"attention"`)
+	assert.Contains(t, string(request.body), `"type":"Action.OpenUrl"`)
+	// The summary format leaves the report out
+	assert.NotContains(t, string(request.body), "CVE Report for abc123")
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeWebhook, URL: server.URL, Secret: "hmac-key"})
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	request := <-requests
+
+	timestamp := request.header.Get(notify.TimestampHeader)
+	_, err := strconv.ParseInt(timestamp, 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256="+notify.Sign([]byte("hmac-key"), timestamp, request.body), request.header.Get(notify.SignatureHeader))
+
+	var payload notify.WebhookPayload
+	assert.NoError(t, json.Unmarshal(request.body, &payload))
+	assert.Equal(t, "run-1", payload.RunID)
+	assert.Equal(t, "CVE-2024-0001", payload.CVEs[0].CVEName)
+}
+
+func TestGotify(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeGotify, URL: server.URL + "/", Token: "app-token"})
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	request := <-requests
+	assert.Equal(t, "/message", request.path)
+	assert.Equal(t, "app-token", request.header.Get("X-Gotify-Key"))
+
+	var payload struct {
+		Priority int `json:"priority"`
+	}
+	assert.NoError(t, json.Unmarshal(request.body, &payload))
+	assert.Equal(t, 10, payload.Priority)
+}
+
+func TestMatrix(t *testing.T) {
+	server, requests := newCapturingServer(t)
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeMatrix, URL: server.URL, Room: "!room:example.org", Token: "bot-token"})
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	request := <-requests
+	assert.Equal(t, http.MethodPut, request.method)
+	assert.True(t, strings.HasPrefix(request.path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/"), request.path)
+	assert.Equal(t, "Bearer bot-token", request.header.Get("Authorization"))
+
+	var payload map[string]string
+	assert.NoError(t, json.Unmarshal(request.body, &payload))
+	assert.Equal(t, "org.matrix.custom.html", payload["format"])
+	assert.Contains(t, payload["formatted_body"], `<a href="https://scanner.example/api/v1/scans/run-1/report?format=html">`)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	channel := newChannel(t, notify.ChannelConfig{Type: notify.TypeSlack, URL: server.URL})
+	err := channel.Notifier.Notify(context.Background(), testMessage)
+	assert.ErrorContains(t, err, "403: invalid_token")
+}
+
+// fakeSMTP accepts one mail without authentication and returns its recipients and data
+func fakeSMTP(t *testing.T) (string, <-chan []string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	recipients := make(chan []string, 1)
+	data := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+
+		var to []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM"):
+				reply("250 OK")
+			case strings.HasPrefix(command, "RCPT TO"):
+				to = append(to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 Go ahead")
+				var body strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					body.WriteString(line)
+				}
+				recipients <- to
+				data <- body.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), recipients, data
+}
+
+func TestEmail(t *testing.T) {
+	addr, recipients, data := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+
+	channel := newChannel(t, notify.ChannelConfig{
+		Type:   notify.TypeEmail,
+		Host:   host,
+		Port:   portNumber,
+		From:   "scanner@example.com",
+		To:     []string{"security@example.com", "ops@example.com"},
+		Format: notify.FormatFull,
+	})
+
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+	assert.Equal(t, []string{"security@example.com", "ops@example.com"}, <-recipients)
+
+	mail := <-data
+	assert.Contains(t, mail, "Subject: CVE Scan Results for container abc123")
+	assert.Contains(t, mail, "Content-Type: multipart/alternative")
+	assert.Contains(t, mail, "Content-Type: text/plain; charset=utf-8")
+	assert.Contains(t, mail, "<td>CVE-2024-0001</td>")
+	assert.Contains(t, mail, `<h2 style="color: #b00020">`)
+}
+
+func TestNewChannel_Invalid(t *testing.T) {
+	for name, config := range map[string]notify.ChannelConfig{
+		"unknown type":     {Type: "pager"},
+		"missing url":      {Type: notify.TypeSlack},
+		"missing token":    {Type: notify.TypeGotify, URL: "http://gotify"},
+		"missing room":     {Type: notify.TypeMatrix, URL: "http://matrix", Token: "t"},
+		"missing to":       {Type: notify.TypeEmail, Host: "smtp", From: "a@b"},
+		"unknown severity": {Type: notify.TypeSlack, URL: "http://slack", MinSeverity: "urgent"},
+		"unknown format":   {Type: notify.TypeSlack, URL: "http://slack", Format: "pdf"},
+	} {
+		_, err := notify.NewChannel(config, nil, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestChannelAccepts(t *testing.T) {
+	channel := notify.Channel{MinSeverity: "high"}
+	assert.True(t, channel.Accepts(notify.Message{Severity: "critical"}))
+	assert.True(t, channel.Accepts(notify.Message{Severity: "high"}))
+	assert.False(t, channel.Accepts(notify.Message{Severity: "medium"}))
+	assert.False(t, channel.Accepts(notify.Message{Title: "Scan Complete"}))
+	assert.True(t, notify.Channel{}.Accepts(notify.Message{Title: "Scan Complete"}))
+}
+
+func TestSummarize(t *testing.T) {
+	cves := []tableprinter.CVEInfo{
+		{CVEName: "CVE-1", Severity: "Low", Package: "a", CurrentVersion: "1"},
+		{CVEName: "CVE-2", Severity: "High", Package: "b", CurrentVersion: "2", ResolvedVersion: "3"},
+	}
+	assert.Equal(t, "2 CVEs: 1 high, 1 low\nCVE-2 (high) b 2, fixed in 3\nCVE-1 (low) a 1, no fix", notify.Summarize(cves))
+	assert.Equal(t, "high", notify.WorstSeverity(cves))
+	assert.Equal(t, "No CVEs found", notify.Summarize(nil))
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	ntfyclient "AutomaticCVEResolver/services/ntfy"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flaky fails with the queued errors before succeeding
+type flaky struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (f *flaky) Notify(ctx context.Context, msg notify.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+var quickRetries = notify.RetryPolicy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryable(t *testing.T) {
+	cases := map[error]bool{
+		&notify.HTTPError{StatusCode: 503}:                                  true,
+		&notify.HTTPError{StatusCode: 429}:                                  true,
+		&notify.HTTPError{StatusCode: 401}:                                  false,
+		&notify.HTTPError{StatusCode: 404}:                                  false,
+		fmt.Errorf("publish: %w", &ntfyclient.StatusError{StatusCode: 502}): true,
+		&ntfyclient.StatusError{StatusCode: 403}:                            false,
+		&textproto.Error{Code: 421, Msg: "try again later"}:                 true,
+		&textproto.Error{Code: 550, Msg: "mailbox unavailable"}:             false,
+		&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}:     true,
+		fmt.Errorf("post: %w", io.ErrUnexpectedEOF):                         true,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}:                                       true,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}:                                         true,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: &net.DNSError{Name: "hooks.example.com", IsTimeout: true}}:                                             true,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: context.DeadlineExceeded}:                                                                              true,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: x509.UnknownAuthorityError{}}:                                                                          false,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: &tls.CertificateVerificationError{Err: x509.CertificateInvalidError{Reason: x509.Expired}}}:            false,
+		&url.Error{Op: "Post", URL: "https://hooks.example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Name: "hooks.example.com", IsNotFound: true}}}: false,
+		&url.Error{Op: "Post", URL: "htps://hooks.example.com", Err: errors.New(`unsupported protocol scheme "htps"`)}:                                                       false,
+		errors.New("failed to encode actions"):           false,
+		notify.Permanent(errors.New("topic is not set")): false,
+		context.Canceled:         false,
+		context.DeadlineExceeded: true,
+	}
+	for err, want := range cases {
+		assert.Equal(t, want, notify.Retryable(err), err.Error())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := notify.RetryPolicy{InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+	for retry, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		delay := policy.Backoff(retry)
+		assert.GreaterOrEqual(t, delay, max/2, retry)
+		assert.LessOrEqual(t, delay, max, retry)
+	}
+}
+
+func TestRetrier(t *testing.T) {
+	inner := &flaky{errs: []error{&notify.HTTPError{StatusCode: 503}, syscall.ECONNRESET}}
+	assert.NoError(t, notify.NewRetrier(inner, quickRetries).Notify(context.Background(), notify.Message{}))
+	assert.Equal(t, 3, inner.calls)
+
+	// Permanent errors are returned right away
+	inner = &flaky{errs: []error{&notify.HTTPError{StatusCode: 401, Body: "unauthorized"}}}
+	err := notify.NewRetrier(inner, quickRetries).Notify(context.Background(), notify.Message{})
+	assert.EqualError(t, err, "unexpected response 401: unauthorized")
+	assert.Equal(t, 1, inner.calls)
+
+	inner = &flaky{errs: []error{&notify.HTTPError{StatusCode: 500}, &notify.HTTPError{StatusCode: 502}, &notify.HTTPError{StatusCode: 503}}}
+	err = notify.NewRetrier(inner, quickRetries).Notify(context.Background(), notify.Message{})
+	assert.EqualError(t, err, "unexpected response 503:  (after 3 attempts)")
+	assert.Equal(t, 3, inner.calls)
+}
+
+func TestOutbox_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	outbox.SetBackoff(time.Millisecond, time.Millisecond)
+
+	down := &flaky{errs: []error{&notify.HTTPError{StatusCode: 502}}}
+	err = outbox.Wrap("ntfy", down).Notify(context.Background(), notify.Message{Title: "CVE Scan Results", RunID: "run-1"})
+	assert.ErrorContains(t, err, "queued for redelivery")
+
+	deliveries := outbox.List()
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, notify.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, "unexpected response 502: ", deliveries[0].LastError)
+
+	// After a restart the pending alert goes out once the server is reachable again
+	restarted, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	up := &flaky{}
+	restarted.Wrap("ntfy", up)
+	time.Sleep(5 * time.Millisecond)
+
+	redelivered, err := restarted.Redeliver(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, redelivered, 1)
+	assert.Equal(t, 1, up.calls)
+
+	delivery, ok := restarted.Get(deliveries[0].ID)
+	assert.True(t, ok)
+	assert.Equal(t, notify.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, "CVE Scan Results", delivery.Message.Title)
+	assert.Equal(t, "run-1", delivery.Message.RunID)
+
+	// Delivered messages are not sent again
+	redelivered, err = restarted.Redeliver(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, redelivered)
+	assert.Equal(t, 1, up.calls)
+}
+
+func TestOutbox_GivesUp(t *testing.T) {
+	outbox, err := notify.NewOutbox("")
+	assert.NoError(t, err)
+
+	err = outbox.Wrap("slack", &flaky{errs: []error{&notify.HTTPError{StatusCode: 404, Body: "no_service"}}}).Notify(context.Background(), notify.Message{})
+	assert.EqualError(t, err, "unexpected response 404: no_service")
+	assert.Equal(t, notify.DeliveryFailed, outbox.List()[0].Status)
+
+	// Without a max age every retryable failure is final
+	outbox.SetMaxAge(0)
+	outbox.Wrap("slack", &flaky{errs: []error{&notify.HTTPError{StatusCode: 503}}}).Notify(context.Background(), notify.Message{})
+	assert.Equal(t, notify.DeliveryFailed, outbox.List()[0].Status)
+	assert.Equal(t, "gave up after 1 attempts: unexpected response 503: ", outbox.List()[0].LastError)
+}
+
+func TestOutbox_RemovedChannel(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	outbox.SetBackoff(time.Millisecond, time.Millisecond)
+	outbox.Wrap("teams", &flaky{errs: []error{syscall.ECONNREFUSED}}).Notify(context.Background(), notify.Message{})
+
+	restarted, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	redelivered, err := restarted.Redeliver(context.Background())
+	assert.ErrorContains(t, err, "channel teams is not configured")
+	assert.Equal(t, notify.DeliveryFailed, redelivered[0].Status)
+}
+
+func TestOutbox_QuarantinesCorruptDelivery(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	outbox.SetBackoff(time.Hour, time.Hour)
+	outbox.Wrap("slack", &flaky{errs: []error{syscall.ECONNREFUSED}}).Notify(context.Background(), notify.Message{})
+	corrupt := filepath.Join(dir, "broken.json")
+	assert.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0o600))
+
+	restarted, err := notify.NewOutbox(dir)
+	assert.NoError(t, err)
+	assert.Len(t, restarted.List(), 1)
+	assert.NoFileExists(t, corrupt)
+	assert.FileExists(t, corrupt+".corrupt")
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"AutomaticCVEResolver/services/notify"
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/tableprinter"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	return dir
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	render := notify.DefaultTemplates().For("ops", notify.TypeGotify, notify.FormatFull)
+
+	title, err := render.Title(testMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, testMessage.Title, title)
+
+	body, err := render.Body(testMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, testMessage.Summary+"\n\n```\n"+testMessage.Report+"\n```", body)
+
+	// Status messages have no report to append
+	body, err = render.Body(notify.Message{Summary: "SBOM and CVE scanning completed for 2 targets"})
+	assert.NoError(t, err)
+	assert.Equal(t, "SBOM and CVE scanning completed for 2 targets", body)
+}
+
+func TestReportTemplate(t *testing.T) {
+	cves := []tableprinter.CVEInfo{{CVEName: "CVE-2024-0001", Severity: "High", Package: "openssl", CurrentVersion: "3.0.8", ResolvedVersion: "3.0.13"}}
+
+	var table strings.Builder
+	tableprinter.WriteCVEResults(&table, cves)
+	report, err := notify.DefaultTemplates().Report(notify.ReportData{Target: "web", CVEs: cves})
+	assert.NoError(t, err)
+	assert.Equal(t, "CVE Report for web:\n"+table.String(), report)
+
+	plan := remediation.BuildPlan(cves, remediation.DefaultReleases)
+	report, err = notify.DefaultTemplates().Report(notify.ReportData{Target: "web", CVEs: cves, Plan: plan})
+	assert.NoError(t, err)
+	assert.Equal(t, "CVE Report for web:\n"+table.String()+"\nRemediation plan:\n"+plan.String(), report)
+}
+
+func TestLoadTemplates_Overrides(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		// For every Slack channel
+		"slack.title.tmpl": "[{{upper .Severity}}] {{.Title}}\n",
+		// Only for the channel named security, taking precedence over the type
+		"security.summary.tmpl": "{{range severityCounts .CVEs}}{{.Count}} {{.Severity}} {{end}}of {{count .CVEs}}: {{truncate 12 .Summary}}\n",
+		"report.text.tmpl":      "{{.Target}}: {{countSeverity \"critical\" .CVEs}} critical\n",
+	})
+	templates, err := notify.LoadTemplates(dir)
+	assert.NoError(t, err)
+
+	server, requests := newCapturingServer(t)
+	channel, err := notify.NewChannel(notify.ChannelConfig{Name: "security", Type: notify.TypeSlack, URL: server.URL}, templates, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, channel.Notifier.Notify(context.Background(), testMessage))
+
+	var payload struct {
+		Text        string `json:"text"`
+		Attachments []struct {
+			Text string `json:"text"`
+		} `json:"attachments"`
+	}
+	assert.NoError(t, json.Unmarshal((<-requests).body, &payload))
+	assert.Equal(t, "[CRITICAL] CVE Scan Results for container abc123", payload.Text)
+	assert.Equal(t, "1 critical of 1: 1 CVEs: 1…", payload.Attachments[0].Text)
+
+	// Other channels of the type keep the default summary
+	render := templates.For("team", notify.TypeSlack, notify.FormatSummary)
+	body, err := render.Body(testMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, testMessage.Summary, body)
+
+	report, err := templates.Report(notify.ReportData{Target: "web", CVEs: testMessage.CVEs})
+	assert.NoError(t, err)
+	assert.Equal(t, "web: 1 critical", report)
+}
+
+func TestHTMLTemplateEscapes(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"default.html.tmpl": `<b style="color: {{severityColor .Severity}}">{{.Title}}</b>`,
+	})
+	templates, err := notify.LoadTemplates(dir)
+	assert.NoError(t, err)
+
+	html, err := templates.For("matrix", notify.TypeMatrix, "").HTML(notify.Message{Title: "<script>alert(1)</script>", Severity: "high"})
+	assert.NoError(t, err)
+	assert.Equal(t, `<b style="color: #e65100">&lt;script&gt;alert(1)&lt;/script&gt;</b>`, html)
+}
+
+func TestLoadTemplates_Invalid(t *testing.T) {
+	_, err := notify.LoadTemplates(writeTemplates(t, map[string]string{"slack.full.tmpl": "{{.Summary"}))
+	assert.ErrorContains(t, err, "invalid template slack.full.tmpl")
+
+	_, err = notify.LoadTemplates(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	// Errors while rendering are permanent, retrying cannot fix them
+	templates, err := notify.LoadTemplates(writeTemplates(t, map[string]string{"default.title.tmpl": "{{.Unknown}}"}))
+	assert.NoError(t, err)
+	_, err = templates.For("ntfy", notify.TypeNtfy, "").Title(testMessage)
+	assert.Error(t, err)
+	assert.False(t, notify.Retryable(err))
+}
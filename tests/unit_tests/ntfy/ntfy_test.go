@@ -1,7 +1,10 @@
 package ntfyclient
 
 import (
+	"context"
 	"github.com/AnthonyHewins/gotfy"
+	"io"
+	"mime"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -160,3 +163,72 @@ func TestSendMessageAsync_Error(t *testing.T) {
 		assert.Error(t, err)
 	}
 }
+
+// Test Publish sends the message options as headers and the attachment as the body
+func TestPublish_Attachment(t *testing.T) {
+	var request *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"id": "5678", "topic": "matt_test"}`))
+	}))
+	defer server.Close()
+
+	ntfy, err := ntfyclient.NewNtfyClient(server.URL, "matt_test", "matt", "secret", 5*time.Second)
+	assert.NoError(t, err)
+
+	resp, err := ntfy.Publish(context.Background(), ntfyclient.Notification{
+		Title:    "CVE Scan Results",
+		Message:  "2 CVEs: 1 critical, 1 high\nCVE-2024-0001 (critical)",
+		Priority: gotfy.Max,
+		Tags:     []string{"rotating_light", "critical"},
+		ClickURL: "https://scanner.example/report",
+		Actions: []ntfyclient.Action{
+			{Type: ntfyclient.ActionView, Label: "Open report", URL: "https://scanner.example/report"},
+		},
+		Attachment: &ntfyclient.Attachment{Filename: "report.txt", Content: []byte("full report")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "5678", resp.ID)
+
+	assert.Equal(t, http.MethodPut, request.Method)
+	assert.Equal(t, "/matt_test", request.URL.Path)
+	assert.Equal(t, "full report", string(body))
+	assert.Equal(t, "report.txt", request.Header.Get("Filename"))
+	assert.Equal(t, "CVE Scan Results", request.Header.Get("Title"))
+	assert.Equal(t, "5", request.Header.Get("Priority"))
+	assert.Equal(t, "rotating_light,critical", request.Header.Get("Tags"))
+	assert.Equal(t, "https://scanner.example/report", request.Header.Get("Click"))
+	assert.Equal(t, `[{"action":"view","label":"Open report","url":"https://scanner.example/report"}]`, request.Header.Get("Actions"))
+
+	// The multi-line message is RFC 2047 encoded
+	message, err := new(mime.WordDecoder).DecodeHeader(request.Header.Get("Message"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2 CVEs: 1 critical, 1 high\nCVE-2024-0001 (critical)", message)
+}
+
+// Test Publish without an attachment sends the message as the body
+func TestPublish_MessageBody(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"id": "5678"}`))
+	}))
+	defer server.Close()
+
+	ntfy, err := ntfyclient.NewNtfyClient(server.URL, "matt_test", "matt", "secret", 5*time.Second)
+	assert.NoError(t, err)
+
+	_, err = ntfy.Publish(context.Background(), ntfyclient.Notification{Title: "Scan Complete", Message: "All done"})
+	assert.NoError(t, err)
+	assert.Equal(t, "All done", string(body))
+}
+
+func TestPriorityAndTagsForSeverity(t *testing.T) {
+	assert.Equal(t, gotfy.Max, ntfyclient.PriorityForSeverity("Critical"))
+	assert.Equal(t, gotfy.High, ntfyclient.PriorityForSeverity("High"))
+	assert.Equal(t, gotfy.Min, ntfyclient.PriorityForSeverity("Negligible"))
+	assert.Equal(t, []string{"warning", "high"}, ntfyclient.TagsForSeverity("High"))
+	assert.Equal(t, []string{"white_check_mark"}, ntfyclient.TagsForSeverity(""))
+}
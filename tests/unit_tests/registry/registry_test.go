@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"AutomaticCVEResolver/services/registry"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry serves the tags of registry.local/app like registry:2 behind a token service
+type fakeRegistry struct {
+	server      *httptest.Server
+	tags        []string
+	digests     map[string]string
+	tokenLogins []string // Username of every token request, empty for anonymous ones
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		tags: []string{"1.3.0", "1.4.0", "1.4.1", "1.5.0-rc1", "1.5.0", "2.0.0", "latest", "v1.4.2"},
+		digests: map[string]string{
+			"1.3.0": "sha256:130", "1.4.0": "sha256:140", "1.4.1": "sha256:141", "1.5.0-rc1": "sha256:150rc1",
+			"1.5.0": "sha256:150", "2.0.0": "sha256:200", "latest": "sha256:200", "v1.4.2": "sha256:142",
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		if username != "" && password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.tokenLogins = append(r.tokenLogins, username)
+		assert.Equal(t, "repository:app:pull", req.URL.Query().Get("scope"))
+		json.NewEncoder(w).Encode(map[string]any{"token": "token-" + username, "expires_in": 300})
+	})
+	mux.HandleFunc("/v2/app/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token-ci" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.local",scope="repository:app:pull"`, r.server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.URL.Path == "/v2/app/tags/list":
+			// Two tags per page, like a registry paginating with ?n=2
+			start := 0
+			if last := req.URL.Query().Get("last"); last != "" {
+				for i, tag := range r.tags {
+					if tag == last {
+						start = i + 1
+					}
+				}
+			}
+			end := min(start+2, len(r.tags))
+			if end < len(r.tags) {
+				w.Header().Set("Link", fmt.Sprintf(`</v2/app/tags/list?n=2&last=%s>; rel="next"`, url.QueryEscape(r.tags[end-1])))
+			}
+			json.NewEncoder(w).Encode(map[string]any{"name": "app", "tags": r.tags[start:end]})
+		case strings.HasPrefix(req.URL.Path, "/v2/app/manifests/"):
+			digest, ok := r.digests[strings.TrimPrefix(req.URL.Path, "/v2/app/manifests/")]
+			if !ok || req.Method != http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	r.server = httptest.NewServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// writeDockerConfig stores the credentials of host like docker login does without a credential helper
+func writeDockerConfig(t *testing.T, host, username, password string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	data := fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, host, auth)
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func TestParseReference(t *testing.T) {
+	cases := []struct {
+		ref      string
+		expected registry.Reference
+	}{
+		{"registry.local/app:1.4", registry.Reference{Registry: "registry.local", Repository: "app", Tag: "1.4"}},
+		{"localhost:5000/team/app", registry.Reference{Registry: "localhost:5000", Repository: "team/app", Tag: "latest"}},
+		{"nginx:1.27", registry.Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.27"}},
+		{"grafana/grafana:>=11 <12", registry.Reference{Registry: "docker.io", Repository: "grafana/grafana", Tag: ">=11 <12"}},
+		{"registry.local/app@sha256:abc", registry.Reference{Registry: "registry.local", Repository: "app", Digest: "sha256:abc"}},
+	}
+	for _, c := range cases {
+		ref, err := registry.ParseReference(c.ref)
+		assert.NoError(t, err, c.ref)
+		assert.Equal(t, c.expected, ref, c.ref)
+	}
+
+	for _, ref := range []string{"", "registry.local/App:1", "registry.local/app@"} {
+		_, err := registry.ParseReference(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestMatchTags(t *testing.T) {
+	tags := []string{"1.10.0", "1.4.0", "1.4.1", "1.5.0-rc1", "1.5.0", "2.0.0", "latest", "v1.4.2"}
+
+	matched, err := registry.MatchTags(tags, "1.4.*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.4.0", "1.4.1"}, matched)
+
+	// Constraints compare versions, not strings, and leave out tags that are no versions
+	matched, err = registry.MatchTags(tags, ">=1.4.1 <2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.4.1", "v1.4.2", "1.5.0-rc1", "1.5.0", "1.10.0"}, matched)
+
+	matched, err = registry.MatchTags(tags, "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, matched)
+
+	_, err = registry.MatchTags(tags, ">=one")
+	assert.Error(t, err)
+	_, err = registry.MatchTags(tags, "1.[")
+	assert.Error(t, err)
+}
+
+func TestClient_Resolve(t *testing.T) {
+	fake := newFakeRegistry(t)
+	client := registry.NewClient(registry.Config{
+		Insecure:     []string{fake.host()},
+		DockerConfig: writeDockerConfig(t, fake.host(), "ci", "secret"),
+	})
+	ctx := context.Background()
+
+	ref, err := registry.ParseReference(fake.host() + "/app:>=1.4 <2")
+	assert.NoError(t, err)
+	images, err := client.Resolve(ctx, ref)
+	assert.NoError(t, err)
+	var resolved []string
+	for _, image := range images {
+		resolved = append(resolved, image.Reference.Tag+"="+image.Digest)
+	}
+	assert.Equal(t, []string{"1.4.0=sha256:140", "1.4.1=sha256:141", "v1.4.2=sha256:142", "1.5.0-rc1=sha256:150rc1", "1.5.0=sha256:150"}, resolved)
+	// The token is reused for the following requests
+	assert.Equal(t, []string{"ci"}, fake.tokenLogins)
+
+	// Tags pointing to the same manifest are resolved once
+	ref, _ = registry.ParseReference(fake.host() + "/app:[2l]*")
+	images, err = client.Resolve(ctx, ref)
+	assert.NoError(t, err)
+	assert.Len(t, images, 1)
+	assert.Equal(t, fake.host()+"/app:2.0.0", images[0].Reference.String())
+
+	ref, _ = registry.ParseReference(fake.host() + "/app:3.*")
+	_, err = client.Resolve(ctx, ref)
+	assert.ErrorContains(t, err, "no tag")
+
+	ref, _ = registry.ParseReference(fake.host() + "/app:1.9.9")
+	_, err = client.Resolve(ctx, ref)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestClient_ResolveLimitsAndAuth(t *testing.T) {
+	fake := newFakeRegistry(t)
+	ref, _ := registry.ParseReference(fake.host() + "/app:*")
+
+	client := registry.NewClient(registry.Config{
+		Insecure:     []string{fake.host()},
+		DockerConfig: writeDockerConfig(t, fake.host(), "ci", "secret"),
+		MaxTags:      3,
+	})
+	_, err := client.Resolve(context.Background(), ref)
+	assert.ErrorContains(t, err, "max_tags")
+
+	// Anonymous tokens do not grant access to the repository
+	fake.tokenLogins = nil
+	client = registry.NewClient(registry.Config{
+		Insecure:     []string{fake.host()},
+		DockerConfig: filepath.Join(t.TempDir(), "missing.json"),
+	})
+	_, err = client.Resolve(context.Background(), ref)
+	assert.ErrorContains(t, err, "401")
+	assert.Equal(t, []string{""}, fake.tokenLogins)
+}
+
+// TestClient_LocalRegistry runs against a real registry, e.g. started with
+// docker run -d -p 5000:5000 registry:2 and an image pushed as localhost:5000/<repository>:<tag>.
+func TestClient_LocalRegistry(t *testing.T) {
+	reference := os.Getenv("REGISTRY_TEST_REFERENCE") // e.g. localhost:5000/alpine:3.*
+	if reference == "" {
+		t.Skip("set REGISTRY_TEST_REFERENCE to test against a local registry")
+	}
+	ref, err := registry.ParseReference(reference)
+	assert.NoError(t, err)
+
+	client := registry.NewClient(registry.Config{Insecure: []string{ref.Registry}, TimeoutSeconds: 10})
+	images, err := client.Resolve(context.Background(), ref)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, images)
+	for _, image := range images {
+		assert.True(t, strings.HasPrefix(image.Digest, "sha256:"), image.Digest)
+	}
+}
@@ -0,0 +1,121 @@
+package remediation
+
+import (
+	"AutomaticCVEResolver/services/remediation"
+	"AutomaticCVEResolver/services/tableprinter"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleCVEs() []tableprinter.CVEInfo {
+	return []tableprinter.CVEInfo{
+		{CVEName: "CVE-2024-0001", Package: "openssl", PackageType: "apk", Distro: "alpine:3.18.4", Severity: "Critical", CurrentVersion: "3.0.8", ResolvedVersion: "3.0.12"},
+		{CVEName: "CVE-2024-0002", Package: "openssl", PackageType: "apk", Distro: "alpine:3.18.4", Severity: "High", CurrentVersion: "3.0.8", ResolvedVersion: "3.0.13"},
+		{CVEName: "CVE-2024-0003", Package: "busybox", PackageType: "apk", Distro: "alpine:3.18.4", Severity: "Medium", CurrentVersion: "1.36.1", ResolvedVersion: "1.36.2"},
+		{CVEName: "CVE-2024-0004", Package: "golang.org/x/net", PackageType: "go-module", Severity: "High", CurrentVersion: "0.7.0", ResolvedVersion: "1.0.0"},
+		{CVEName: "CVE-2024-0005", Package: "zlib", PackageType: "apk", Distro: "alpine:3.18.4", Severity: "Low", CurrentVersion: "1.2.13"},
+	}
+}
+
+func TestBuildPlan_GroupsAndRanks(t *testing.T) {
+	plan := remediation.BuildPlan(sampleCVEs(), remediation.DefaultReleases)
+
+	// openssl, busybox, x/net and one base image bump
+	assert.Equal(t, 4, len(plan.Actions))
+	assert.Equal(t, 1, len(plan.Unfixable))
+
+	// The openssl upgrade closes the critical CVE with the least effort and ranks first
+	top := plan.Actions[0]
+	assert.Equal(t, remediation.ActionUpgradePackage, top.Kind)
+	assert.Equal(t, "openssl", top.Target)
+	assert.Equal(t, "3.0.13", top.ToVersion)
+	assert.Equal(t, "upgrade openssl 3.0.8→3.0.13 (closes 2 CVEs, 1 critical)", top.Summary())
+
+	for _, action := range plan.Actions {
+		switch action.Kind {
+		case remediation.ActionBumpBaseImage:
+			assert.Equal(t, "alpine:3.18", action.Target)
+			assert.Equal(t, remediation.DefaultReleases.Latest["alpine"], action.ToVersion)
+			assert.Equal(t, "bump base alpine:3.18→"+action.ToVersion+" (closes 3 CVEs, 1 critical)", action.Summary())
+			// openssl and busybox are upgraded by the actions ranked above, so the bump adds nothing
+			assert.Zero(t, action.RiskReduction)
+		case remediation.ActionUpgradePackage:
+			if action.Target == "golang.org/x/net" {
+				assert.Equal(t, 3, action.Effort, "major version bumps cost more effort")
+			}
+		}
+	}
+}
+
+func TestBuildPlan_DeduplicatesCVEs(t *testing.T) {
+	cves := sampleCVEs()
+	// grype reports a CVE once per location of the package
+	cves = append(cves, cves[0])
+	plan := remediation.BuildPlan(cves, remediation.DefaultReleases)
+	assert.Len(t, plan.Actions[0].CVEs, 2)
+	assert.Equal(t, 15.0, plan.Actions[0].RiskReduction)
+
+	// No bump is offered for the latest release or unknown distributions
+	for i := range cves {
+		if cves[i].Distro != "" {
+			cves[i].Distro = "alpine:" + remediation.DefaultReleases.Latest["alpine"] + ".1"
+		}
+	}
+	for _, action := range remediation.BuildPlan(cves, remediation.DefaultReleases).Actions {
+		assert.Equal(t, remediation.ActionUpgradePackage, action.Kind)
+	}
+	for i := range cves {
+		if cves[i].Distro != "" {
+			cves[i].Distro = "distroless:12"
+		}
+	}
+	assert.Len(t, remediation.BuildPlan(cves, remediation.DefaultReleases).Actions, 3)
+}
+
+func TestBuildPlan_ConfiguredReleases(t *testing.T) {
+	releases := remediation.Releases{Latest: map[string]string{"alpine": "3.23"}, Source: "remediation.latest_releases"}
+	plan := remediation.BuildPlan(sampleCVEs(), releases)
+	assert.Equal(t, releases, plan.Releases)
+
+	bumps := 0
+	for _, action := range plan.Actions {
+		if action.Kind == remediation.ActionBumpBaseImage {
+			bumps++
+			assert.Equal(t, "3.23", action.ToVersion)
+		}
+	}
+	assert.Equal(t, 1, bumps)
+	assert.Contains(t, plan.String(), "Latest distribution releases (remediation.latest_releases: alpine 3.23)\n")
+
+	// Distributions missing from the table get no bump
+	plan = remediation.BuildPlan(sampleCVEs(), remediation.Releases{Latest: map[string]string{"debian": "13"}, Source: "remediation.latest_releases"})
+	for _, action := range plan.Actions {
+		assert.Equal(t, remediation.ActionUpgradePackage, action.Kind)
+	}
+	assert.NotContains(t, plan.String(), "Latest distribution releases")
+}
+
+func TestPlanWrite_Formats(t *testing.T) {
+	plan := remediation.BuildPlan(sampleCVEs(), remediation.DefaultReleases)
+
+	var table bytes.Buffer
+	assert.NoError(t, plan.Write(&table, remediation.FormatTable))
+	assert.Contains(t, table.String(), "upgrade openssl 3.0.8→3.0.13")
+	assert.Contains(t, table.String(), "no fix available")
+	assert.Contains(t, table.String(), "Latest distribution releases (built-in: almalinux 10, alpine 3.22,")
+
+	var encoded bytes.Buffer
+	assert.NoError(t, plan.Write(&encoded, remediation.FormatJSON))
+	var decoded remediation.Plan
+	assert.NoError(t, json.Unmarshal(encoded.Bytes(), &decoded))
+	assert.Equal(t, len(plan.Actions), len(decoded.Actions))
+
+	var text bytes.Buffer
+	assert.NoError(t, plan.Write(&text, remediation.FormatText))
+	assert.Contains(t, text.String(), "1. upgrade openssl")
+
+	assert.Error(t, plan.Write(&text, "yaml"))
+}
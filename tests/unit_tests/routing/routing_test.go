@@ -0,0 +1,124 @@
+package routing
+
+import (
+	"AutomaticCVEResolver/services/routing"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var channels = []string{"ntfy", "payments-slack", "mail", "pager"}
+
+var testConfig = routing.Config{
+	Rules: []routing.Rule{
+		{
+			Name:     "payments",
+			Labels:   map[string]string{"team": "payments"},
+			Route:    routing.Route{Channels: []string{"payments-slack"}, Fallback: []string{"mail"}},
+			Continue: true,
+		},
+		{
+			Name:        "critical",
+			MinSeverity: "critical",
+			Route:       routing.Route{Channels: []string{"pager"}},
+		},
+		{
+			Name:            "shop",
+			ComposeProjects: []string{"shop-*"},
+			Images:          []string{"registry.local/*", "nginx:1.2?"},
+			Route:           routing.Route{Channels: []string{"ntfy"}, Topic: "shop"},
+		},
+	},
+	Default: routing.Route{Channels: []string{"ntfy"}},
+}
+
+func rules(matches []routing.Match) []string {
+	var names []string
+	for _, match := range matches {
+		names = append(names, match.Rule)
+	}
+	return names
+}
+
+func TestRoute(t *testing.T) {
+	router, err := routing.NewRouter(testConfig, channels)
+	assert.NoError(t, err)
+	assert.True(t, router.NeedsLabels())
+
+	// Continue lets the critical rule match as well
+	matches := router.Route(routing.Subject{Labels: map[string]string{"team": "payments"}, Severity: "critical"})
+	assert.Equal(t, []string{"payments", "critical"}, rules(matches))
+	assert.Equal(t, []string{"mail"}, matches[0].Fallback)
+
+	matches = router.Route(routing.Subject{Labels: map[string]string{"team": "payments"}, Severity: "high"})
+	assert.Equal(t, []string{"payments"}, rules(matches))
+
+	shop := map[string]string{routing.ComposeProjectLabel: "shop-prod"}
+	matches = router.Route(routing.Subject{Image: "registry.local/shop/cart:1.0", Labels: shop, Severity: "low"})
+	assert.Equal(t, []string{"shop"}, rules(matches))
+	assert.Equal(t, "shop", matches[0].Topic)
+	assert.Equal(t, []string{"shop"}, rules(router.Route(routing.Subject{Image: "nginx:1.27", Labels: shop})))
+
+	// Both the image and the compose project have to match
+	assert.Equal(t, []string{"default"}, rules(router.Route(routing.Subject{Image: "nginx:1.3", Labels: shop})))
+	assert.Equal(t, []string{"default"}, rules(router.Route(routing.Subject{Image: "nginx:1.27"})))
+
+	// Status messages carry no severity
+	assert.Equal(t, []string{"default"}, rules(router.Route(routing.Subject{})))
+}
+
+func TestLabelWildcard(t *testing.T) {
+	router, err := routing.NewRouter(routing.Config{
+		Rules: []routing.Rule{{Labels: map[string]string{"team": "*"}, Route: routing.Route{Channels: []string{"mail"}}}},
+	}, channels)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"rule 1"}, rules(router.Route(routing.Subject{Labels: map[string]string{"team": "search"}})))
+	matches := router.Route(routing.Subject{})
+	assert.Equal(t, []string{"default"}, rules(matches))
+	assert.Empty(t, matches[0].Channels)
+}
+
+func TestReloadInvalid(t *testing.T) {
+	router, err := routing.NewRouter(testConfig, channels)
+	assert.NoError(t, err)
+
+	err = router.Reload(routing.Config{Rules: []routing.Rule{{Route: routing.Route{Channels: []string{"teams"}}}}})
+	assert.ErrorContains(t, err, `unknown channel "teams"`)
+	err = router.Reload(routing.Config{Rules: []routing.Rule{{MinSeverity: "urgent"}}})
+	assert.ErrorContains(t, err, "unknown severity")
+
+	// The previous rules stay in effect, also after checking valid ones
+	assert.NoError(t, router.Check(routing.Config{Default: routing.Route{Channels: []string{"ntfy"}}}))
+	assert.Equal(t, []string{"critical"}, rules(router.Route(routing.Subject{Severity: "critical"})))
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("default:\n  channels: [ntfy]\n"), 0o600))
+
+	router, err := routing.LoadRouter(path, channels)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ntfy"}, router.Route(routing.Subject{})[0].Channels)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Watch(ctx, path, 10*time.Millisecond, slog.Default())
+
+	// An invalid file is ignored, a valid one replaces the rules
+	assert.NoError(t, os.WriteFile(path, []byte("default:\n  channels: [teams]\n"), 0o600))
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"ntfy"}, router.Route(routing.Subject{})[0].Channels)
+
+	assert.NoError(t, os.WriteFile(path, []byte("default:\n  channels: [mail]\n"), 0o600))
+	os.Chtimes(path, time.Now().Add(2*time.Second), time.Now().Add(2*time.Second))
+	assert.Eventually(t, func() bool {
+		return router.Route(routing.Subject{})[0].Channels[0] == "mail"
+	}, 5*time.Second, 10*time.Millisecond)
+}
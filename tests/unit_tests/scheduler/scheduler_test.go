@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"AutomaticCVEResolver/services/scheduler"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	from := time.Date(2024, time.March, 15, 10, 17, 30, 0, time.UTC) // Friday
+
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, time.March, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.March, 16, 3, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, time.March, 18, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.March, 17, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		schedule, err := scheduler.ParseCron(c.expr)
+		assert.NoError(t, err, c.expr)
+		assert.Equal(t, c.expected, schedule.Next(from), c.expr)
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := scheduler.ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestScheduler_RunStopsOnCancel(t *testing.T) {
+	schedule, err := scheduler.ParseCron("@yearly")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs, cancelled atomic.Int32
+
+	s := scheduler.NewScheduler()
+	err = s.AddJob(scheduler.Job{
+		Name:       "scan",
+		Schedule:   schedule,
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			cancel()
+			// Simulate an in-flight scan that only stops once its context is cancelled
+			<-ctx.Done()
+			cancelled.Add(1)
+			return ctx.Err()
+		},
+	})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Run(ctx))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop after cancellation")
+	}
+	assert.Equal(t, int32(1), runs.Load())
+	assert.Equal(t, int32(1), cancelled.Load())
+}
+
+func TestScheduler_AddJobValidation(t *testing.T) {
+	s := scheduler.NewScheduler()
+	assert.Error(t, s.AddJob(scheduler.Job{Name: "missing schedule"}))
+	assert.Error(t, s.Run(context.Background()))
+}
+
+func TestScheduler_ReplaceKeepsRunsInFlight(t *testing.T) {
+	schedule, err := scheduler.ParseCron("@yearly")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var interrupted, replacedRuns atomic.Int32
+
+	s := scheduler.NewScheduler()
+	assert.NoError(t, s.AddJob(scheduler.Job{
+		Name:       "scan",
+		Schedule:   schedule,
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-ctx.Done():
+				interrupted.Add(1)
+			case <-release:
+			}
+			return nil
+		},
+	}))
+	s.Start(ctx)
+	<-started
+
+	err = s.Replace([]scheduler.Job{{
+		Name:       "scan",
+		Schedule:   schedule,
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			replacedRuns.Add(1)
+			return nil
+		},
+	}})
+	assert.NoError(t, err)
+	close(release)
+
+	cancel()
+	s.Wait()
+	// The run in progress was neither cancelled by the replacement nor followed by a new run on start
+	assert.Equal(t, int32(0), interrupted.Load())
+	assert.Equal(t, int32(0), replacedRuns.Load())
+}
+
+func TestScheduler_ReplaceValidation(t *testing.T) {
+	schedule, err := scheduler.ParseCron("@daily")
+	assert.NoError(t, err)
+	run := func(ctx context.Context) error { return nil }
+
+	s := scheduler.NewScheduler()
+	err = s.Replace([]scheduler.Job{
+		{Name: "scan", Schedule: schedule, Run: run},
+		{Name: "scan", Schedule: schedule, Run: run},
+	})
+	assert.ErrorContains(t, err, "duplicate job scan")
+	assert.Error(t, s.Replace([]scheduler.Job{{Name: "scan", Run: run}}))
+	// Nothing was replaced, so there is still nothing to run
+	assert.Error(t, s.Run(context.Background()))
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"AutomaticCVEResolver/services/store"
+	"AutomaticCVEResolver/services/tableprinter"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_ExecutePersistsRuns(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.NewStore(dir)
+	assert.NoError(t, err)
+
+	run, err := st.Create("running", "cli")
+	assert.NoError(t, err)
+	assert.Equal(t, store.StatusQueued, run.Status)
+
+	err = st.Execute(run.ID, func() (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+		return map[string]string{"12345": `{"sbom": "nginx-sbom"}`},
+			map[string][]tableprinter.CVEInfo{"12345": {{CVEName: "CVE-2021-12345", Severity: "Critical"}}},
+			nil
+	})
+	assert.NoError(t, err)
+
+	// A new store on the same directory sees the finished run
+	reopened, err := store.NewStore(dir)
+	assert.NoError(t, err)
+	saved, ok := reopened.Get(run.ID)
+	assert.True(t, ok)
+	assert.Equal(t, store.StatusSucceeded, saved.Status)
+	assert.Equal(t, "CVE-2021-12345", saved.CVEs["12345"][0].CVEName)
+	assert.False(t, saved.FinishedAt.IsZero())
+
+	// SBOMs are kept in files of their own and read when asked for
+	assert.Nil(t, saved.SBOMs)
+	assert.Equal(t, []string{"12345"}, saved.SBOMTargets)
+	sbom, ok, err := reopened.SBOM(run.ID, "12345")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, `{"sbom": "nginx-sbom"}`, sbom)
+	_, ok, _ = reopened.SBOM(run.ID, "67890")
+	assert.False(t, ok)
+}
+
+func TestStore_MarksInterruptedRunsFailed(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.NewStore(dir)
+	assert.NoError(t, err)
+	run, _ := st.Create("running", "schedule")
+	assert.NoError(t, st.Update(run.ID, func(run *store.Run) { run.Status = store.StatusRunning }))
+
+	// The process stopped while scanning
+	reopened, err := store.NewStore(dir)
+	assert.NoError(t, err)
+	saved, _ := reopened.Get(run.ID)
+	assert.Equal(t, store.StatusFailed, saved.Status)
+	assert.Contains(t, saved.Error, "interrupted")
+}
+
+func TestStore_Retention(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.NewStore(dir)
+	assert.NoError(t, err)
+
+	var ids []string
+	for i := range 4 {
+		run, err := st.Create(fmt.Sprintf("image:app%d", i), "cli")
+		assert.NoError(t, err)
+		assert.NoError(t, st.Execute(run.ID, func() (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+			return map[string]string{"app": "{}"}, nil, nil
+		}))
+		ids = append(ids, run.ID)
+		time.Sleep(time.Millisecond)
+	}
+	// The oldest run has an active alert and outlives the limits
+	assert.NoError(t, st.Update(ids[0], func(run *store.Run) { run.Alert = &store.Alert{Severity: "critical"} }))
+
+	assert.NoError(t, st.SetRetention(2, 0))
+	var kept []string
+	for _, run := range st.List() {
+		kept = append(kept, run.ID)
+	}
+	assert.Equal(t, []string{ids[3], ids[2], ids[0]}, kept)
+	_, err = os.Stat(filepath.Join(dir, ids[1]+".json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(dir, ids[1]+".sboms"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// New runs push the oldest ones out
+	run, _ := st.Create("image:app4", "cli")
+	_, ok := st.Get(ids[2])
+	assert.False(t, ok)
+	_, ok = st.Get(run.ID)
+	assert.True(t, ok)
+
+	assert.NoError(t, st.SetRetention(0, time.Nanosecond))
+	assert.Len(t, st.List(), 2) // The alerting run and the one still queued
+}
+
+func TestStore_ExecuteRecordsFailure(t *testing.T) {
+	st, err := store.NewStore("")
+	assert.NoError(t, err)
+
+	run, err := st.Create("image:nginx", "api")
+	assert.NoError(t, err)
+
+	err = st.Execute(run.ID, func() (map[string]string, map[string][]tableprinter.CVEInfo, error) {
+		return nil, nil, errors.New("docker is not running")
+	})
+	assert.Error(t, err)
+
+	saved, _ := st.Get(run.ID)
+	assert.Equal(t, store.StatusFailed, saved.Status)
+	assert.Equal(t, "docker is not running", saved.Error)
+	assert.Equal(t, 1, len(st.List()))
+	assert.Error(t, st.Update("missing", func(run *store.Run) {}))
+}
+
+func TestDiff(t *testing.T) {
+	from := store.Run{ID: "a", CVEs: map[string][]tableprinter.CVEInfo{
+		"abc123": {{CVEName: "CVE-1", Package: "openssl"}, {CVEName: "CVE-2", Package: "zlib"}},
+	}}
+	to := store.Run{ID: "b", CVEs: map[string][]tableprinter.CVEInfo{
+		"abc123": {{CVEName: "CVE-1", Package: "openssl", CurrentVersion: "3.0.9"}, {CVEName: "CVE-3", Package: "curl"}},
+	}}
+
+	diff := store.Diff(from, to)
+	assert.Equal(t, "a", diff.From)
+	assert.Equal(t, []store.Finding{{Target: "abc123", CVEInfo: tableprinter.CVEInfo{CVEName: "CVE-3", Package: "curl"}}}, diff.New)
+	assert.Equal(t, []store.Finding{{Target: "abc123", CVEInfo: tableprinter.CVEInfo{CVEName: "CVE-2", Package: "zlib"}}}, diff.Fixed)
+
+	// Nothing changed
+	diff = store.Diff(to, to)
+	assert.Empty(t, diff.New)
+	assert.Empty(t, diff.Fixed)
+}
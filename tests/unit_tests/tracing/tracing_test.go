@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"AutomaticCVEResolver/services/docker"
+	"AutomaticCVEResolver/services/notify"
+	ntfyclient "AutomaticCVEResolver/services/ntfy"
+	"AutomaticCVEResolver/services/tracing"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AnthonyHewins/gotfy"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// stubExecutor returns canned output for syft and grype and fails everything else
+type stubExecutor struct{}
+
+func (stubExecutor) ExecCommand(ctx context.Context, command string, args ...string) (docker.ExecResult, error) {
+	switch command + " " + strings.Join(args, " ") {
+	case "syft nginx -o json":
+		return docker.ExecResult{Stdout: []byte(`{"artifacts": []}`)}, nil
+	case "grype nginx -o json":
+		return docker.ExecResult{Stdout: []byte(`{"matches": [{"vulnerability": {"id": "CVE-2024-0001", "severity": "High"}, "artifact": {"name": "openssl", "version": "3.0.8", "locations": [{"path": "/lib"}]}}]}`)}, nil
+	case "docker image inspect --format {{.Id}} nginx":
+		return docker.ExecResult{Stdout: []byte("sha256:abc\n")}, nil
+	}
+	return docker.ExecResult{ExitCode: -1}, &docker.ExecError{Command: command, Args: args, ExitCode: -1, Err: errors.New("unknown command")}
+}
+
+// stubNtfy fails every message
+type stubNtfy struct{}
+
+func (stubNtfy) SendMessage(message, title string) (*gotfy.PublishResp, error) {
+	return nil, errors.New("ntfy is down")
+}
+
+func (stubNtfy) SendMessageAsync(message, title string, resultChan chan<- *gotfy.PublishResp, errorChan chan<- error) {
+}
+
+func (stubNtfy) Publish(ctx context.Context, n ntfyclient.Notification) (*gotfy.PublishResp, error) {
+	return nil, errors.New("ntfy is down")
+}
+
+func spanByName(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func attributeValue(span tracetest.SpanStub, key string) attribute.Value {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestPipelineSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), tracing.Config{})
+
+	ds := docker.NewDockerSBOMService(stubExecutor{})
+	ds.SetTracerProvider(provider)
+	notifications := docker.NewNotificationService(notify.Channel{Name: "ntfy", Notifier: notify.NewNtfyNotifierWithClient(stubNtfy{}, "")})
+	notifications.SetTracerProvider(provider)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, cveResults, _ := ds.ScanTargets(ctx, []docker.ScanTarget{{Image: "nginx"}})
+	assert.Equal(t, 1, len(cveResults["nginx"]))
+	assert.Error(t, notifications.SendNotification(ctx, "report", "title"))
+
+	spans := exporter.GetSpans()
+	target, ok := spanByName(spans, "scan.target")
+	assert.True(t, ok)
+	assert.Equal(t, "sha256:abc", attributeValue(target, "image.digest").AsString())
+
+	for _, name := range []string{"syft", "grype", "parse"} {
+		span, ok := spanByName(spans, name)
+		assert.True(t, ok, name)
+		assert.Equal(t, target.SpanContext.SpanID(), span.Parent.SpanID(), "%s should be a child of the target span", name)
+	}
+
+	grype, _ := spanByName(spans, "grype")
+	assert.Equal(t, "nginx", attributeValue(grype, "image").AsString())
+	assert.Equal(t, int64(0), attributeValue(grype, "process.exit_code").AsInt64())
+	assert.Greater(t, attributeValue(grype, "output.bytes").AsInt64(), int64(0))
+
+	parse, _ := spanByName(spans, "parse")
+	assert.Equal(t, int64(1), attributeValue(parse, "cve.count").AsInt64())
+
+	notification, ok := spanByName(spans, "notification")
+	assert.True(t, ok)
+	assert.Equal(t, "Error", notification.Status.Code.String())
+
+	// Every span shares one tracer name, whichever package created it
+	for _, span := range spans {
+		assert.Equal(t, tracing.TracerName, span.InstrumentationScope.Name, span.Name)
+	}
+}
+
+// recordingExecutor returns empty syft and grype output for any image and records the commands run
+type recordingExecutor struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *recordingExecutor) ExecCommand(ctx context.Context, command string, args ...string) (docker.ExecResult, error) {
+	r.mu.Lock()
+	r.commands = append(r.commands, command+" "+strings.Join(args, " "))
+	r.mu.Unlock()
+	switch command {
+	case "syft":
+		return docker.ExecResult{Stdout: []byte(`{"artifacts": []}`)}, nil
+	case "grype":
+		return docker.ExecResult{Stdout: []byte(`{"matches": []}`)}, nil
+	}
+	return docker.ExecResult{ExitCode: -1}, &docker.ExecError{Command: command, Args: args, ExitCode: -1, Err: errors.New("unknown command")}
+}
+
+func TestPipelineSpans_KnownDigests(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), tracing.Config{})
+	executor := &recordingExecutor{}
+	ds := docker.NewDockerSBOMService(executor)
+	ds.SetTracerProvider(provider)
+
+	// Registry and file images are not in the daemon, the digest of the registry image came with it
+	ds.ScanTargets(context.Background(), []docker.ScanTarget{
+		{Image: "registry:registry.local/app:1.4", Digest: "sha256:def"},
+		{Image: "docker-archive:/build/app.tar"},
+	})
+
+	for _, command := range executor.commands {
+		assert.False(t, strings.HasPrefix(command, "docker "), command)
+	}
+	digests := map[string]string{}
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "scan.target" {
+			digests[attributeValue(span, "image").AsString()] = attributeValue(span, "image.digest").AsString()
+		}
+	}
+	assert.Equal(t, map[string]string{"registry:registry.local/app:1.4": "sha256:def", "docker-archive:/build/app.tar": ""}, digests)
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
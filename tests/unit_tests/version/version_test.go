@@ -0,0 +1,44 @@
+package version
+
+import (
+	"AutomaticCVEResolver/services/version"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"3.0.13", "3.0.8", 1},
+		{"3.0.8-r0", "3.0.8-r1", -1},
+		{"1.2.3", "1.2.3", 0},
+		{"1.0rc1", "1.0", -1},
+		{"1.0", "1.0.1", -1},
+		{"3.0.11-1~deb12u2", "3.0.11-1~deb12u1", 1},
+		{"3.0.11-1~deb12u1", "3.0.11-1", -1},
+		{"1.1.1w", "1.1.1", 1},
+		{"1.1.1w", "1.1.1v", 1},
+		{"1.1.1w", "1.1.2", -1},
+		{"3.0.8", "3.0.8-r0", -1},
+		{"1.5.0-rc1", "1.5.0", -1},
+		{"1.5.0-rc2", "1.5.0-rc1", 1},
+		{"1.0~rc1", "1.0-rc1", -1},
+		{"1:2.3", "3.0", 1},
+		{"2:1.0", "1:9.9", 1},
+		{"1:2.3-1", "1:2.3-1", 0},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, version.Compare(c.a, c.b), "Compare(%q, %q)", c.a, c.b)
+	}
+}
+
+func TestMaxAndMajor(t *testing.T) {
+	assert.Equal(t, "3.0.13", version.Max("3.0.9", "3.0.13", "3.0.10"))
+	assert.Equal(t, "", version.Max())
+	assert.Equal(t, 3, version.Major("3.0.13"))
+	assert.Equal(t, -1, version.Major("latest"))
+}